@@ -0,0 +1,143 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	WorkloadDeployment  = "Deployment"
+	WorkloadStatefulSet = "StatefulSet"
+)
+
+// LogClusterSpec defines the desired state of LogCluster
+type LogClusterSpec struct {
+	// Replicas is the number of aggregator pods, 1 by default
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Image is the Loggie image
+	Image string `json:"image"`
+	// Workload runs the aggregator pods, Deployment by default
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	// +optional
+	Workload string `json:"workload,omitempty"`
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// SystemConfig is the system config of Loggie, which is mounted as loggie.yml
+	SystemConfig string `json:"systemConfig"`
+	// Pipelines is the pipelines config of Loggie, which is mounted as pipelines.yml.
+	// The sidecars send logs to the grpc source listening on ports.grpc.
+	Pipelines string `json:"pipelines"`
+
+	// +optional
+	Service LogClusterService `json:"service,omitempty"`
+	// +optional
+	Ports LogClusterPorts `json:"ports,omitempty"`
+	// MaxUnavailable of the PodDisruptionBudget, which is created if there are more than 1 replicas. 1 by default
+	// +optional
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
+}
+
+type LogClusterService struct {
+	// Type of the Service, ClusterIP by default
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type LogClusterPorts struct {
+	// GRPC is the port of the grpc source in pipelines, 6066 by default
+	// +optional
+	GRPC int32 `json:"grpc,omitempty"`
+	// HTTP is the port of the http server in system config, 9196 by default
+	// +optional
+	HTTP int32 `json:"http,omitempty"`
+}
+
+// LogClusterStatus defines the observed state of LogCluster
+type LogClusterStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Endpoint is the grpc address of the aggregator, which is the host of the grpc sink of sidecars
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.endpoint`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// LogCluster is a tier of Loggie aggregators, which receives logs from the sidecars
+type LogCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LogClusterSpec   `json:"spec,omitempty"`
+	Status LogClusterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LogClusterList contains a list of LogCluster
+type LogClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LogCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LogCluster{}, &LogClusterList{})
+}
+
+// GRPCPort returns the port of the grpc source
+func (in *LogCluster) GRPCPort() int32 {
+	if in.Spec.Ports.GRPC == 0 {
+		return 6066
+	}
+	return in.Spec.Ports.GRPC
+}
+
+// HTTPPort returns the port of the http server
+func (in *LogCluster) HTTPPort() int32 {
+	if in.Spec.Ports.HTTP == 0 {
+		return 9196
+	}
+	return in.Spec.Ports.HTTP
+}
+
+// Endpoint returns the grpc address of the Service of the aggregator
+func (in *LogCluster) Endpoint() string {
+	return fmt.Sprintf("%s.%s.svc:%d", in.Name, in.Namespace, in.GRPCPort())
+}
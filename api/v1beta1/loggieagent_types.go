@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LoggieAgentSpec defines the desired state of LoggieAgent
+type LoggieAgentSpec struct {
+	// Namespace runs the DaemonSet, loggie by default
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Image is the Loggie image, whose version must be at least v1.5.0 to ignore the LogConfigs of sidecars
+	Image string `json:"image"`
+	// SystemConfig is the system config of Loggie, which is mounted as loggie.yml
+	SystemConfig string `json:"systemConfig"`
+	// Pipelines is the static pipelines config of Loggie besides the ones discovered from LogConfigs, which is mounted as pipelines.yml
+	// +optional
+	Pipelines string `json:"pipelines,omitempty"`
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// HostPaths are the log directories of the nodes mounted to the agent at the same path,
+	// /var/log/pods and /var/lib/kubelet/pods by default
+	// +optional
+	HostPaths []string `json:"hostPaths,omitempty"`
+	// RegistryPath is the directory of the nodes to keep the collecting progress, /data/loggie-{name} by default
+	// +optional
+	RegistryPath string `json:"registryPath,omitempty"`
+}
+
+// LoggieAgentStatus defines the observed state of LoggieAgent
+type LoggieAgentStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	DesiredNumberScheduled int32 `json:"desiredNumberScheduled,omitempty"`
+	// +optional
+	UpdatedNumberScheduled int32 `json:"updatedNumberScheduled,omitempty"`
+	// +optional
+	NumberReady int32 `json:"numberReady,omitempty"`
+	// Phase is Progressing, Ready or Invalid
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Namespace runs the DaemonSet applied last, whose objects are deleted when spec.namespace changes
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+const (
+	AgentPhaseProgressing = "Progressing"
+	AgentPhaseReady       = "Ready"
+	AgentPhaseInvalid     = "Invalid"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.status.desiredNumberScheduled`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.numberReady`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// LoggieAgent is the Loggie DaemonSet collecting the logs of nodes, along with the sidecars injected by the operator
+type LoggieAgent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LoggieAgentSpec   `json:"spec,omitempty"`
+	Status LoggieAgentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LoggieAgentList contains a list of LoggieAgent
+type LoggieAgentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LoggieAgent `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LoggieAgent{}, &LoggieAgentList{})
+}
+
+// TargetNamespace returns the namespace of the DaemonSet
+func (in *LoggieAgent) TargetNamespace() string {
+	if in.Spec.Namespace == "" {
+		return "loggie"
+	}
+	return in.Spec.Namespace
+}
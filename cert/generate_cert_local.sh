@@ -138,6 +138,7 @@ webhooks:
       caBundle: ${CA_BUNDLE}
       url: https://${HOST_NAME}:${SERVER_PORT}/mutate-inject-sidecar
     failurePolicy: Ignore
+    reinvocationPolicy: IfNeeded
     matchPolicy: Equivalent
     name: sidecar-injector-webhook.loggie.io
     namespaceSelector: {}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type configDiff struct {
+	name    string
+	running string
+	current string
+}
+
+// runDiff compares the config injected into a running pod with the one rendered from the current LogConfigs
+func runDiff(opts *options, name string) error {
+	cli, err := opts.client()
+	if err != nil {
+		return err
+	}
+	injection, err := opts.injection(cli)
+	if err != nil {
+		return err
+	}
+
+	pod := &corev1.Pod{}
+	if err := cli.Get(context.Background(), types.NamespacedName{Namespace: opts.namespace, Name: name}, pod); err != nil {
+		return err
+	}
+	status, err := webhook.GetInjectedStatus(pod)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return errors.Errorf("pod %s/%s is not injected with Loggie sidecar", opts.namespace, name)
+	}
+
+	e, err := injection.Explain(pod)
+	if err != nil {
+		return err
+	}
+	if !e.Inject || e.Selected == nil {
+		fmt.Printf("pod %s/%s would not be injected now: %s\n", opts.namespace, name, whyNotInject(e))
+		return nil
+	}
+	if e.Error != "" {
+		return errors.New(e.Error)
+	}
+
+	uninjected := pod.DeepCopy()
+	if _, err := webhook.Uninject(uninjected); err != nil {
+		return err
+	}
+	systemConfig, err := webhook.EffectiveSystemConfig(injection.Config, uninjected)
+	if err != nil {
+		return err
+	}
+	diffs := []configDiff{
+		{name: "pipelines", running: webhook.InjectedPipeline(pod), current: e.Pipeline},
+		{name: "system", running: webhook.InjectedSystemConfig(pod), current: systemConfig},
+	}
+
+	changed := false
+	for _, d := range diffs {
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(d.running),
+			B:        difflib.SplitLines(d.current),
+			FromFile: fmt.Sprintf("%s/%s (running %s)", opts.namespace, name, d.name),
+			ToFile:   fmt.Sprintf("%s %s (current %s)", e.Selected.Kind, configName(*e.Selected), d.name),
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		if text != "" {
+			changed = true
+			fmt.Print(text)
+		}
+	}
+
+	if !changed {
+		fmt.Printf("pod %s/%s is up to date\n", opts.namespace, name)
+	}
+	return nil
+}
+
+func whyNotInject(e *webhook.Explanation) string {
+	if !e.Inject {
+		return e.InjectReason
+	}
+	return "no LogConfig/ClusterLogConfig matches the pod"
+}
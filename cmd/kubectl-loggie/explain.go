@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/render"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+func newWorkload(kind string) (client.Object, error) {
+	switch strings.ToLower(kind) {
+	case "pod", "pods", "po":
+		return &corev1.Pod{}, nil
+	case "deployment", "deployments", "deploy":
+		return &appsv1.Deployment{}, nil
+	case "statefulset", "statefulsets", "sts":
+		return &appsv1.StatefulSet{}, nil
+	case "daemonset", "daemonsets", "ds":
+		return &appsv1.DaemonSet{}, nil
+	case "job", "jobs":
+		return &batchv1.Job{}, nil
+	case "cronjob", "cronjobs", "cj":
+		return &batchv1.CronJob{}, nil
+	}
+	return nil, errors.Errorf("unsupported kind %s", kind)
+}
+
+func runExplain(opts *options, kind string, name string) error {
+	cli, err := opts.client()
+	if err != nil {
+		return err
+	}
+	injection, err := opts.injection(cli)
+	if err != nil {
+		return err
+	}
+
+	obj, err := newWorkload(kind)
+	if err != nil {
+		return err
+	}
+	if err := cli.Get(context.Background(), types.NamespacedName{Namespace: opts.namespace, Name: name}, obj); err != nil {
+		return err
+	}
+	pod, _ := render.PodFromWorkload(obj)
+
+	e, err := injection.Explain(pod)
+	if err != nil {
+		return err
+	}
+
+	printExplanation(os.Stdout, fmt.Sprintf("%s %s/%s", kind, opts.namespace, name), e)
+	return nil
+}
+
+func printExplanation(w io.Writer, target string, e *webhook.Explanation) {
+	fmt.Fprintf(w, "Target:\t%s\n", target)
+	if e.Inject {
+		fmt.Fprintf(w, "Inject:\tyes, %s\n", e.InjectReason)
+	} else {
+		fmt.Fprintf(w, "Inject:\tno, %s\n", e.InjectReason)
+	}
+
+	fmt.Fprintln(w, "Configs:")
+	if len(e.Configs) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, c := range e.Configs {
+		result := "not matched"
+		if c.Matched {
+			result = "matched"
+		}
+		if e.Selected != nil && c.Kind == e.Selected.Kind && c.Namespace == e.Selected.Namespace && c.Name == e.Selected.Name {
+			result = "selected"
+		}
+		if c.Priority != 0 {
+			result = fmt.Sprintf("%s, priority %d", result, c.Priority)
+		}
+		fmt.Fprintf(w, "  %s %s: %s\n", c.Kind, configName(c), result)
+		for _, r := range c.Reasons {
+			fmt.Fprintf(w, "    - %s\n", r)
+		}
+	}
+
+	if e.Selected == nil {
+		fmt.Fprintln(w, "Selected:\t<none>, no LogConfig/ClusterLogConfig matches the pod")
+		return
+	}
+	fmt.Fprintf(w, "Selected:\t%s %s\n", e.Selected.Kind, configName(*e.Selected))
+	if !e.Inject {
+		return
+	}
+	if e.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", e.Error)
+		return
+	}
+
+	fmt.Fprintln(w, "Volumes:")
+	for _, v := range e.Volumes {
+		source := "emptyDir injected"
+		if !v.Injected {
+			source = "existing volume"
+		}
+		containers := "<none>"
+		if len(v.Containers) > 0 {
+			containers = strings.Join(v.Containers, ",")
+		}
+		fmt.Fprintf(w, "  %s: volume %s (%s), shared with containers: %s\n", v.MountPath, v.Volume, source, containers)
+	}
+
+	fmt.Fprintln(w, "Pipelines:")
+	for _, l := range strings.Split(strings.TrimRight(e.Pipeline, "\n"), "\n") {
+		fmt.Fprintf(w, "  %s\n", l)
+	}
+}
+
+func configName(c webhook.ConfigMatch) string {
+	if c.Namespace == "" {
+		return c.Name
+	}
+	return c.Namespace + "/" + c.Name
+}
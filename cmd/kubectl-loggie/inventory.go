@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/inventory"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runInventory queries the inventory API of the operator through the service proxy of the API server
+func runInventory(opts *options, what string) error {
+	if what != "pods" && what != "groups" {
+		return errors.Errorf("unknown inventory %s, should be pods or groups", what)
+	}
+	parts := strings.SplitN(opts.operator, "/", 2)
+	if len(parts) != 2 {
+		return errors.Errorf("invalid operator %s, which should be {namespace}/{service}:{port}", opts.operator)
+	}
+	service, port := parts[1], ""
+	if i := strings.LastIndex(service, ":"); i >= 0 {
+		service, port = service[:i], service[i+1:]
+	}
+
+	// the namespace is not defaulted as the other commands, so the inventory is of all namespaces by default
+	restConfig, err := opts.clientConfig().ClientConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"namespace":  opts.namespace,
+		"config":     opts.config,
+		"mode":       opts.mode,
+		"image":      opts.image,
+		"configHash": opts.hash,
+		"by":         opts.by,
+		"continue":   opts.cont,
+	}
+	if opts.limit > 0 {
+		params["limit"] = fmt.Sprint(opts.limit)
+	}
+	for k, v := range params {
+		if v == "" {
+			delete(params, k)
+		}
+	}
+	body, err := clientset.CoreV1().Services(parts[0]).ProxyGet("http", service, port, "/inventory/"+what, params).DoRaw(context.Background())
+	if err != nil {
+		return errors.WithMessagef(err, "query inventory of %s failed", opts.operator)
+	}
+
+	if opts.output == "json" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var total int
+	var cont string
+	if what == "pods" {
+		list := &inventory.PodList{}
+		if err := json.Unmarshal(body, list); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "NAMESPACE\tNAME\tWORKLOAD\tCONFIG\tMODE\tIMAGE\tHASH")
+		for _, p := range list.Items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Namespace, p.Name, p.Workload, p.Config, p.Mode, p.Image, p.ConfigHash)
+		}
+		total, cont = list.Total, list.Continue
+	} else {
+		list := &inventory.GroupList{}
+		if err := json.Unmarshal(body, list); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\tPODS\tWORKLOADS\n", strings.ToUpper(list.By))
+		for _, g := range list.Items {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", g.Key, g.Pods, g.Workloads)
+		}
+		total, cont = list.Total, list.Continue
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if cont != "" {
+		fmt.Fprintf(os.Stderr, "%d in total, get the next page with -continue %s\n", total, cont)
+	}
+	return nil
+}
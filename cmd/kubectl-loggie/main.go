@@ -0,0 +1,235 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-loggie is a kubectl plugin to debug the Loggie sidecar injection, eg:
+// kubectl loggie explain deployment tomcat -n default
+// kubectl loggie diff tomcat-6d8f9c7b5-x2x9q -n default
+// kubectl loggie inventory groups -by image
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/cfg"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+var (
+	scheme = runtime.NewScheme()
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(logconfigv1beta1.AddToScheme(scheme))
+	utilruntime.Must(operatorv1beta1.AddToScheme(scheme))
+}
+
+const usage = `kubectl loggie explains the Loggie sidecar injection.
+
+Usage:
+  kubectl loggie explain <pod|deployment|statefulset|daemonset|job|cronjob> <name> [flags]
+  kubectl loggie diff <pod> [flags]
+  kubectl loggie inventory <pods|groups> [flags]
+
+Flags:
+`
+
+type options struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	configPath string
+	configMap  string
+	configKey  string
+
+	// flags of inventory
+	operator string
+	config   string
+	mode     string
+	image    string
+	hash     string
+	by       string
+	limit    int
+	cont     string
+	output   string
+}
+
+func main() {
+	opts := &options{}
+	fs := flag.NewFlagSet("kubectl-loggie", flag.ExitOnError)
+	fs.StringVar(&opts.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&opts.context, "context", "", "The name of the kubeconfig context to use.")
+	fs.StringVar(&opts.namespace, "n", "", "Namespace of the pod or workload, defaults to the namespace of the kubeconfig context, or all namespaces for inventory.")
+	fs.StringVar(&opts.configPath, "config-path", "", "Configuration path of Loggie operator, used to render the sidecar the same as the operator, instead of config-map.")
+	fs.StringVar(&opts.configMap, "config-map", "loggie/loggie-operator", "The ConfigMap {namespace}/{name} holding the configuration of Loggie operator, read when config-path is not set.")
+	fs.StringVar(&opts.configKey, "config-key", "config.yml", "The key of the configuration in config-map.")
+	fs.StringVar(&opts.operator, "operator", "loggie/loggie-operator:9296", "The service of the operator metrics server as {namespace}/{service}:{port}, used by inventory.")
+	fs.StringVar(&opts.config, "config", "", "Inventory of the pods injected with the config, eg: LogConfig/default/tomcat or ClusterLogConfig/tomcat.")
+	fs.StringVar(&opts.mode, "mode", "", "Inventory of the pods injected in the mode, sidecar or volume.")
+	fs.StringVar(&opts.image, "image", "", "Inventory of the pods injected with the sidecar image.")
+	fs.StringVar(&opts.hash, "hash", "", "Inventory of the pods injected with the config hash.")
+	fs.StringVar(&opts.by, "by", "", "Group the inventory by config, namespace, mode, image or configHash.")
+	fs.IntVar(&opts.limit, "limit", 0, "The page size of the inventory.")
+	fs.StringVar(&opts.cont, "continue", "", "The continue token of the inventory page.")
+	fs.StringVar(&opts.output, "o", "", "Output format of the inventory, empty for a table or json.")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	args, err := parseInterspersed(fs, os.Args[1:])
+	if err != nil || len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log.InitDefaultLogger()
+
+	switch args[0] {
+	case "explain":
+		if len(args) != 3 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		err = runExplain(opts, args[1], args[2])
+
+	case "diff":
+		if len(args) != 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		err = runDiff(opts, args[1])
+
+	case "inventory":
+		if len(args) != 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		err = runInventory(opts, args[1])
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseInterspersed allows flags after the positional arguments, like kubectl does
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func (o *options) client() (client.Client, error) {
+	clientConfig := o.clientConfig()
+	if o.namespace == "" {
+		ns, _, err := clientConfig.Namespace()
+		if err != nil {
+			return nil, err
+		}
+		o.namespace = ns
+	}
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(restConfig, client.Options{Scheme: scheme})
+}
+
+func (o *options) clientConfig() clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.context,
+	})
+}
+
+func (o *options) injection(cli client.Reader) (*webhook.SidecarInjection, error) {
+	conf, err := o.loadConfig(cli)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Sidecar == nil {
+		return nil, errors.New("sidecar is not configured in the configuration of the operator")
+	}
+
+	return &webhook.SidecarInjection{
+		Config: conf.Sidecar,
+		Reader: cli,
+	}, nil
+}
+
+// loadConfig reads the configuration of the operator from config-path, or from config-map the same as the operator does,
+// since the sidecar rendered without the configuration differs from what the operator injects
+func (o *options) loadConfig(cli client.Reader) (*config.Config, error) {
+	if o.configPath != "" {
+		conf := &config.Config{}
+		if err := cfg.UnPackFromFile(o.configPath, conf).Defaults().Validate().Do(); err != nil {
+			return nil, errors.WithMessagef(err, "invalid config %s", o.configPath)
+		}
+		return conf, nil
+	}
+
+	parts := strings.SplitN(o.configMap, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid config-map %s, which should be {namespace}/{name}", o.configMap)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := cli.Get(context.Background(), types.NamespacedName{Namespace: parts[0], Name: parts[1]}, cm); err != nil {
+		return nil, errors.WithMessagef(err, "read the configuration from ConfigMap %s failed, set config-path or config-map", o.configMap)
+	}
+	raw, ok := cm.Data[o.configKey]
+	if !ok {
+		return nil, errors.Errorf("key %s not found in ConfigMap %s", o.configKey, o.configMap)
+	}
+	conf, err := config.Parse([]byte(raw))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid config in ConfigMap %s", o.configMap)
+	}
+	return conf, nil
+}
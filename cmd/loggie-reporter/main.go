@@ -0,0 +1,266 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// loggie-reporter runs Loggie in the sidecar, and reports the pipeline metrics read from the Loggie HTTP API to the
+// heartbeat endpoint of the operator periodically. It is copied to the pod by an init container with
+// `loggie-reporter -install <dir>`.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/heartbeat/report"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// metricPrefixes are the metrics of Loggie reported
+var metricPrefixes = []string{"loggie_sink_", "loggie_filesource_", "loggie_queue_"}
+
+func main() {
+	install := flag.String("install", "", "Copy loggie-reporter to the directory and exit.")
+	url := flag.String("url", "", "The heartbeat endpoint of the operator.")
+	metricsURL := flag.String("metrics-url", "http://127.0.0.1:9196/metrics", "The metrics endpoint of Loggie.")
+	interval := flag.Duration("interval", 30*time.Second, "The interval to report.")
+	insecure := flag.Bool("insecure-skip-verify", false, "Skip verifying the certificate of the operator.")
+	tokenFile := flag.String("token-file", "", "The ServiceAccount token authenticating the reports, which is read at every report since it is rotated.")
+	flag.Parse()
+
+	if *install != "" {
+		if err := installTo(*install); err != nil {
+			fmt.Fprintf(os.Stderr, "loggie-reporter: install failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 || *url == "" {
+		fmt.Fprintln(os.Stderr, "usage: loggie-reporter -url <url> [-interval <duration>] -- <command> [args...]")
+		os.Exit(2)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "loggie-reporter: %v\n", err)
+		os.Exit(127)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	r := &reporter{
+		url:        *url,
+		metricsURL: *metricsURL,
+		tokenFile:  *tokenFile,
+		namespace:  os.Getenv("POD_NAMESPACE"),
+		pod:        os.Getenv("POD_NAME"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// keep the connection alive across reports
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+				IdleConnTimeout: 2 * *interval,
+			},
+		},
+	}
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := r.report(); err != nil {
+				// reporting never breaks Loggie
+				fmt.Fprintf(os.Stderr, "loggie-reporter: %v\n", err)
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	os.Exit(exitCode(err))
+}
+
+type reporter struct {
+	url        string
+	metricsURL string
+	tokenFile  string
+	namespace  string
+	pod        string
+	client     *http.Client
+}
+
+func (r *reporter) report() error {
+	rep := report.Report{Namespace: r.namespace, Pod: r.pod}
+	pipelines, err := r.pipelines()
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	rep.Pipelines = pipelines
+
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.tokenFile != "" {
+		token, err := ioutil.ReadFile(r.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("report failed: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pipelines reads the metrics of Loggie, and sums them by pipeline
+func (r *reporter) pipelines() (map[string]map[string]float64, error) {
+	resp, err := r.client.Get(r.metricsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("read metrics failed: %s", resp.Status)
+	}
+
+	return parseMetrics(resp.Body)
+}
+
+var pipelineLabel = regexp.MustCompile(`(?:^|,)pipeline="([^"]*)"`)
+
+// parseMetrics sums the samples of the metrics in the Prometheus text format by the label pipeline
+func parseMetrics(r io.Reader) (map[string]map[string]float64, error) {
+	pipelines := make(map[string]map[string]float64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, labels, rest := line, "", ""
+		if i := strings.IndexByte(line, '{'); i >= 0 {
+			j := strings.LastIndexByte(line, '}')
+			if j < i {
+				continue
+			}
+			name, labels, rest = line[:i], line[i+1:j], line[j+1:]
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if !hasAnyPrefix(name, metricPrefixes) {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+
+		var pipeline string
+		if m := pipelineLabel.FindStringSubmatch(labels); m != nil {
+			pipeline = m[1]
+		}
+		if pipelines[pipeline] == nil {
+			pipelines[pipeline] = make(map[string]float64)
+		}
+		pipelines[pipeline][name] += value
+	}
+	return pipelines, scanner.Err()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func installTo(dir string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filepath.Join(dir, filepath.Base(self)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_parseMetrics(t *testing.T) {
+	metrics := `# HELP loggie_sink_success_event Loggie sink success event
+# TYPE loggie_sink_success_event gauge
+loggie_sink_success_event{pipeline="local",source="tomcat"} 120
+loggie_sink_success_event{pipeline="local",source="access"} 30
+loggie_sink_failed_event{pipeline="local",source="tomcat"} 2
+loggie_queue_pipeline_size{pipeline="remote",queue="channel"} 1.5e+01
+loggie_reload_total 3
+go_goroutines 42
+`
+	got, err := parseMetrics(strings.NewReader(metrics))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]float64{
+		"local": {
+			"loggie_sink_success_event": 150,
+			"loggie_sink_failed_event":  2,
+		},
+		"remote": {
+			"loggie_queue_pipeline_size": 15,
+		},
+	}, got)
+}
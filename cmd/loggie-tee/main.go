@@ -0,0 +1,188 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// loggie-tee runs a command and writes its stdout and stderr to a file as well, so the sidecar could collect
+// the stdout of app containers while `kubectl logs` keeps working. It is copied to the pod by an init container
+// with `loggie-tee -install <dir>`.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+func main() {
+	install := flag.String("install", "", "Copy loggie-tee to the directory and exit.")
+	file := flag.String("file", "", "The file to write the stdout and stderr of the command.")
+	maxSize := flag.Int("max-size", 100, "The size in MB of the file before rotated.")
+	flag.Parse()
+
+	if *install != "" {
+		if err := installTo(*install); err != nil {
+			fmt.Fprintf(os.Stderr, "loggie-tee: install failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 || *file == "" {
+		fmt.Fprintln(os.Stderr, "usage: loggie-tee -file <file> [-max-size <MB>] -- <command> [args...]")
+		os.Exit(2)
+	}
+
+	w, err := newRotateWriter(*file, int64(*maxSize)<<20)
+	if err != nil {
+		// the app should run anyway, without its stdout collected
+		fmt.Fprintf(os.Stderr, "loggie-tee: %v\n", err)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &tee{out: os.Stdout, file: w}
+	cmd.Stderr = &tee{out: os.Stderr, file: w}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "loggie-tee: %v\n", err)
+		os.Exit(127)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	os.Exit(exitCode(err))
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func installTo(dir string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filepath.Join(dir, filepath.Base(self)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// tee writes to out, and to file if it is available. Failing to write the file never breaks the output.
+type tee struct {
+	out  io.Writer
+	file *rotateWriter
+}
+
+func (t *tee) Write(p []byte) (int, error) {
+	if t.file != nil {
+		_, _ = t.file.Write(p)
+	}
+	return t.out.Write(p)
+}
+
+// rotateWriter appends to the file, and renames it with suffix .1 when its size exceeds maxSize
+type rotateWriter struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	file    *os.File
+}
+
+func newRotateWriter(path string, maxSize int64) (*rotateWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &rotateWriter{path: path, maxSize: maxSize}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		w.file.Close()
+		w.file = nil
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return 0, err
+		}
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func Test_rotateWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "stdout.log")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte("12345"), 0644))
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	// the size of the existing file is counted
+	w, err := newRotateWriter(path, 10)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("67890"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890", read(path))
+
+	// the file is rotated only when the size would exceed maxSize
+	_, err = w.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890", read(path+".1"))
+	assert.Equal(t, "abc", read(path))
+
+	// a write larger than maxSize goes to the empty file without rotation
+	_, err = w.Write([]byte("defghijklmn"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", read(path+".1"))
+	assert.Equal(t, "defghijklmn", read(path))
+	_, err = w.Write([]byte("o"))
+	assert.NoError(t, err)
+	assert.Equal(t, "defghijklmn", read(path+".1"))
+	assert.Equal(t, "o", read(path))
+
+	// the file is reopened after it was closed on failure
+	assert.NoError(t, w.file.Close())
+	w.file = nil
+	_, err = w.Write([]byte("p"))
+	assert.NoError(t, err)
+	assert.Equal(t, "op", read(path))
+}
+
+func Test_exitCode(t *testing.T) {
+	run := func(script string) error {
+		return exec.Command("sh", "-c", script).Run()
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "succeeded", err: run("exit 0"), want: 0},
+		{name: "exited", err: run("exit 3"), want: 3},
+		{name: "terminated", err: run("kill -TERM $$"), want: 128 + 15},
+		{name: "killed", err: run("kill -KILL $$"), want: 128 + 9},
+		{name: "not started", err: errors.New("exec: not found"), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+		})
+	}
+}
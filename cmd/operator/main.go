@@ -17,11 +17,27 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
-	"github.com/loggie-io/loggie/pkg/core/cfg"
-	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/controllers/ephemeral"
+	"github.com/loggie-io/operator/pkg/controllers/logcluster"
+	"github.com/loggie-io/operator/pkg/controllers/logconfig"
+	"github.com/loggie-io/operator/pkg/controllers/loggieagent"
+	"github.com/loggie-io/operator/pkg/controllers/reload"
+	"github.com/loggie-io/operator/pkg/controllers/upgrade"
+	"github.com/loggie-io/operator/pkg/health"
+	"github.com/loggie-io/operator/pkg/heartbeat"
+	"github.com/loggie-io/operator/pkg/inventory"
 	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	runtimeWebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,8 +45,10 @@ import (
 
 	"github.com/loggie-io/loggie/pkg/core/log"
 	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -44,29 +62,47 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(logconfigv1beta1.AddToScheme(scheme))
+	utilruntime.Must(operatorv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(runRender(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rbac" {
+		os.Exit(runRBAC(os.Args[2:]))
+	}
+
 	var port int
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var certDir string
+	var webhookHost string
+	var namespaces string
+	var clusterLogConfigs bool
 	var configPath string
+	var configMap string
+	var configReloadInterval time.Duration
 	flag.IntVar(&port, "port", 9443, "Loggie Operator server port.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":9296", "The address the metric endpoint binds to.")
 	flag.StringVar(&certDir, "cert-dir", "/tmp/cert", "cert-dir is the directory that contains the server key and certificate.")
+	flag.StringVar(&webhookHost, "webhook-host", "", "The host name the webhook certificate should be valid for, such as loggie-operator.loggie.svc, empty skips checking the host.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":9297", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&configPath, "config-path", "config.yml", "Global Configuration path.")
+	flag.StringVar(&configMap, "config-map", "", "Read the configuration from the key named by the base of config-path in the ConfigMap {namespace}/{name}, instead of the file.")
+	flag.StringVar(&namespaces, "namespaces", "", "Comma separated namespaces to watch and inject, empty for all namespaces. The RBAC manifests for the namespaces are printed by `loggie-operator rbac`.")
+	flag.BoolVar(&clusterLogConfigs, "cluster-log-configs", true, "Watch and inject ClusterLogConfigs, which could be disabled when the operator cannot read them.")
+	flag.DurationVar(&configReloadInterval, "config-reload-interval", 10*time.Second, "The interval to reload the configuration, 0 disables reloading.")
 	flag.Parse()
 
 	log.InitDefaultLogger()
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		CertDir:                certDir,
@@ -74,40 +110,187 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "5a8e7206.loggie.io",
-	})
+	}
+	// the cluster-scoped objects are cached cluster wide in any case
+	watchNamespaces := splitNamespaces(namespaces)
+	if len(watchNamespaces) == 1 {
+		options.Namespace = watchNamespaces[0]
+	} else if len(watchNamespaces) > 1 {
+		options.NewCache = cache.MultiNamespacedCacheBuilder(watchNamespaces)
+	}
+	if len(watchNamespaces) > 0 {
+		log.Info("watching namespaces %s", strings.Join(watchNamespaces, ","))
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		log.Fatal("unable to start manager: %v", err)
 	}
 
 	// read configuration
-	conf := config.Config{}
-	unpack := cfg.UnPackFromFile(configPath, &conf)
-	if err := unpack.Defaults().Validate().Do(); err != nil {
-		log.Fatal("invalid config: %v, \n%s", err, unpack.Contents())
+	reloader := &reload.Reloader{
+		Reader:   mgr.GetAPIReader(),
+		Recorder: mgr.GetEventRecorderFor("loggie-operator"),
+		Path:     configPath,
+		Key:      filepath.Base(configPath),
+		Interval: configReloadInterval,
+		Pod:      types.NamespacedName{Namespace: os.Getenv("POD_NAMESPACE"), Name: os.Getenv("POD_NAME")},
+	}
+	if configMap != "" {
+		parts := strings.SplitN(configMap, "/", 2)
+		if len(parts) != 2 {
+			log.Fatal("invalid config-map %s, which should be {namespace}/{name}", configMap)
+		}
+		reloader.ConfigMap = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+	conf, err := reloader.Load(context.Background())
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if configReloadInterval > 0 {
+		if err := mgr.Add(reloader); err != nil {
+			log.Fatal("unable to create config reloader: %v", err)
+		}
 	}
 
-	// TODO
-	//if err = (&logconfig.Reconciler{
-	//	Config: &conf,
-	//	Client: mgr.GetClient(),
-	//	Scheme: mgr.GetScheme(),
-	//}).SetupWithManager(mgr); err != nil {
-	//	log.Fatal("unable to create LogConfig controller: %v", err)
-	//}
+	// LogCluster is optional, the controller runs only if its CRD is installed
+	_, err = mgr.GetRESTMapper().RESTMapping(operatorv1beta1.GroupVersion.WithKind("LogCluster").GroupKind(), operatorv1beta1.GroupVersion.Version)
+	logClusters := err == nil
+	if !logClusters {
+		log.Info("LogCluster controller is disabled: %v", err)
+	} else if err = (&logcluster.Reconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("loggie-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		log.Fatal("unable to create LogCluster controller: %v", err)
+	}
+
+	// LoggieAgent is optional as LogCluster, and it grants the DaemonSet cluster wide, which is not allowed in namespaces
+	if len(watchNamespaces) > 0 {
+		log.Info("LoggieAgent controller is disabled when watching namespaces")
+	} else if _, err := mgr.GetRESTMapper().RESTMapping(operatorv1beta1.GroupVersion.WithKind("LoggieAgent").GroupKind(), operatorv1beta1.GroupVersion.Version); err != nil {
+		log.Info("LoggieAgent controller is disabled: %v", err)
+	} else if err = (&loggieagent.Reconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("loggie-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		log.Fatal("unable to create LoggieAgent controller: %v", err)
+	}
 
 	if conf.Sidecar.Enabled {
+		recorder := mgr.GetEventRecorderFor("loggie-operator")
+		failures := webhook.NewFailures(mgr.GetClient(), recorder)
+		if err := mgr.Add(failures); err != nil {
+			log.Fatal("unable to create injection failures recorder: %v", err)
+		}
+		heartbeats := heartbeat.NewRegistry(mgr.GetClient(), mgr.GetAPIReader(), reloader.Store)
+		if err := mgr.Add(heartbeats); err != nil {
+			log.Fatal("unable to create heartbeat registry: %v", err)
+		}
+		restarter := logconfig.NewRestarter(mgr.GetClient(), recorder, reloader.Store)
+
+		if err = (&logconfig.Reconciler{
+			Config:      reloader.Store,
+			Client:      mgr.GetClient(),
+			Scheme:      mgr.GetScheme(),
+			Recorder:    recorder,
+			Restarter:   restarter,
+			Heartbeats:  heartbeats,
+			LogClusters: logClusters,
+		}).SetupWithManager(mgr); err != nil {
+			log.Fatal("unable to create LogConfig controller: %v", err)
+		}
+		if !clusterLogConfigs {
+			log.Info("ClusterLogConfig controller is disabled")
+		} else if err = (&logconfig.ClusterReconciler{
+			Config:      reloader.Store,
+			Client:      mgr.GetClient(),
+			Scheme:      mgr.GetScheme(),
+			Recorder:    recorder,
+			Restarter:   restarter,
+			Heartbeats:  heartbeats,
+			LogClusters: logClusters,
+		}).SetupWithManager(mgr); err != nil {
+			log.Fatal("unable to create ClusterLogConfig controller: %v", err)
+		}
+		if err = mgr.Add(&upgrade.Upgrader{
+			Client:   mgr.GetClient(),
+			Recorder: recorder,
+			Config:   reloader.Store,
+		}); err != nil {
+			log.Fatal("unable to create sidecar upgrader: %v", err)
+		}
+		if conf.Sidecar.Ephemeral.Enabled {
+			if err = (&ephemeral.Reconciler{
+				Client:                  mgr.GetClient(),
+				Clientset:               kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+				Recorder:                recorder,
+				Config:                  reloader.Store,
+				IgnoreClusterLogConfigs: !clusterLogConfigs,
+			}).SetupWithManager(mgr); err != nil {
+				log.Fatal("unable to create ephemeral controller: %v", err)
+			}
+		}
+
 		log.Info("sidecar injector is enabled")
 		hookServer := mgr.GetWebhookServer()
 		hookServer.Register("/mutate-inject-sidecar", &runtimeWebhook.Admission{Handler: &webhook.SidecarInjection{
-			Client: mgr.GetClient(),
-			Config: conf.Sidecar,
+			Reader:                  mgr.GetClient(),
+			Store:                   reloader.Store,
+			Failures:                failures,
+			Namespaces:              watchNamespaces,
+			IgnoreClusterLogConfigs: !clusterLogConfigs,
 		}})
+		hookServer.Register("/heartbeat", heartbeats)
+
+		// the replica is ready to admit pods once the webhook can read the configs from the cache and serve with valid certificates
+		cached := []client.Object{&logconfigv1beta1.LogConfig{}, &logconfigv1beta1.Sink{}, &logconfigv1beta1.Interceptor{}}
+		if clusterLogConfigs {
+			cached = append(cached, &logconfigv1beta1.ClusterLogConfig{})
+		}
+		cacheSynced, err := health.CacheSynced(context.Background(), mgr.GetCache(), cached...)
+		if err != nil {
+			log.Fatal("unable to set up cache check: %v", err)
+		}
+		if err := mgr.AddReadyzCheck("cache", cacheSynced); err != nil {
+			log.Fatal("unable to set up cache check: %v", err)
+		}
+		if err := mgr.AddReadyzCheck("certificates", health.Certificates(certDir, webhookHost)); err != nil {
+			log.Fatal("unable to set up certificates check: %v", err)
+		}
+		if err := mgr.AddReadyzCheck("webhook", hookServer.StartedChecker()); err != nil {
+			log.Fatal("unable to set up webhook check: %v", err)
+		}
+	}
+
+	// the read-only inventory of injected pods is served on the metrics server from the cache
+	inv := &inventory.Inventory{Reader: mgr.GetClient()}
+	if err := mgr.AddMetricsExtraHandler("/inventory/pods", inv.PodsHandler()); err != nil {
+		log.Fatal("unable to set up inventory: %v", err)
+	}
+	if err := mgr.AddMetricsExtraHandler("/inventory/groups", inv.GroupsHandler()); err != nil {
+		log.Fatal("unable to set up inventory: %v", err)
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Fatal("unable to set up health check: %v", err)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	watchdog := &health.Watchdog{
+		Reader:         mgr.GetClient(),
+		List:           &corev1.PodList{},
+		Interval:       10 * time.Second,
+		Timeout:        time.Minute,
+		StartupTimeout: 5 * time.Minute,
+	}
+	if err := watchdog.SetupWithManager(mgr); err != nil {
+		log.Fatal("unable to create watchdog: %v", err)
+	}
+	if err := mgr.AddHealthzCheck("watchdog", watchdog.Check); err != nil {
+		log.Fatal("unable to set up health check: %v", err)
+	}
+	if err := mgr.AddReadyzCheck("config", reloader.Check); err != nil {
 		log.Fatal("unable to set up ready check: %v", err)
 	}
 
@@ -116,3 +299,14 @@ func main() {
 		log.Fatal("problem running manager: %v", err)
 	}
 }
+
+// splitNamespaces parses a comma separated list of namespaces
+func splitNamespaces(s string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(s, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
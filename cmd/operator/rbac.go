@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/rbac"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// runRBAC prints the RBAC manifests of the operator for the namespace mode, eg:
+// loggie-operator rbac -namespaces team-a,team-b -cluster-log-configs=false
+func runRBAC(args []string) int {
+	fs := flag.NewFlagSet("rbac", flag.ExitOnError)
+	o := &rbac.Options{}
+	var namespaces string
+	fs.StringVar(&o.Namespace, "namespace", "loggie", "Namespace of the operator.")
+	fs.StringVar(&o.ServiceAccount, "service-account", "loggie-operator", "ServiceAccount of the operator.")
+	fs.StringVar(&namespaces, "namespaces", "", "Comma separated namespaces watched by the operator, empty for all namespaces.")
+	fs.BoolVar(&o.ClusterLogConfigs, "cluster-log-configs", true, "Whether the operator reads ClusterLogConfigs.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: loggie-operator rbac [-namespaces <namespace,...>] [-cluster-log-configs=false]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	o.Namespaces = splitNamespaces(namespaces)
+
+	for i, obj := range rbac.Manifests(o) {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshal %T failed: %v\n", obj, err)
+			return 1
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(out))
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/cfg"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/render"
+	"io/ioutil"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runRender previews the sidecar injection of workloads offline, eg:
+// loggie-operator render -f deployment.yml -f logconfig.yml -config-path config.yml
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	var filenames fileList
+	var configPath string
+	fs.Var(&filenames, "f", "Manifest file of workloads and LogConfig/ClusterLogConfig/Sink/Interceptor, can be repeated.")
+	fs.StringVar(&configPath, "config-path", "config.yml", "Global Configuration path.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: loggie-operator render -f <manifest> [-f <manifest>...] [-config-path <path>]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if len(filenames) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	log.InitDefaultLogger()
+
+	conf := config.Config{}
+	unpack := cfg.UnPackFromFile(configPath, &conf)
+	if err := unpack.Defaults().Validate().Do(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		return 1
+	}
+
+	var objs []client.Object
+	for _, f := range filenames {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read %s failed: %v\n", f, err)
+			return 1
+		}
+		o, err := render.Decode(scheme, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "decode %s failed: %v\n", f, err)
+			return 1
+		}
+		objs = append(objs, o...)
+	}
+
+	results, err := render.Render(scheme, conf.Sidecar, objs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "render failed: %v\n", err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "no Pod, Deployment, StatefulSet, DaemonSet, Job or CronJob found in manifests")
+		return 1
+	}
+	if err := render.Print(os.Stdout, results); err != nil {
+		fmt.Fprintf(os.Stderr, "print failed: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -6,7 +6,11 @@ require (
 	github.com/bmatcuk/doublestar/v4 v4.0.2
 	github.com/loggie-io/loggie v1.3.0
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.12.1
 	github.com/stretchr/testify v1.7.5
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8
+	gomodules.xyz/jsonpatch/v2 v2.2.0
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.24.0
 	k8s.io/apimachinery v0.24.0
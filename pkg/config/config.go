@@ -16,14 +16,237 @@ limitations under the License.
 
 package config
 
+import (
+	"github.com/loggie-io/loggie/pkg/core/cfg"
+	"github.com/loggie-io/loggie/pkg/core/interceptor"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	RestartPolicyNever = "never"
+	RestartPolicyAuto  = "auto"
+
+	// ModeSidecar injects a Loggie sidecar to collect the logs
+	ModeSidecar = "sidecar"
+	// ModeVolume only puts the log paths on emptyDir volumes, which are collected by the Loggie DaemonSet
+	ModeVolume = "volume"
+
+	// PortConflictAuto moves the HTTP server of the sidecar to a free port
+	PortConflictAuto = "auto"
+	// PortConflictReject denies the pods whose ports conflict with the HTTP server of the sidecar
+	PortConflictReject = "reject"
+)
+
 type Config struct {
 	Sidecar *Sidecar `yaml:"sidecar,omitempty" validate:"dive"`
 }
 
 type Sidecar struct {
-	Enabled              bool     `yaml:"enabled,omitempty"`
-	Image                string   `yaml:"image,omitempty" validate:"required"`
-	IgnoreNamespaces     []string `yaml:"ignoreNamespaces,omitempty"`
-	IgnoreContainerNames []string `yaml:"ignoreContainerNames,omitempty"`
-	SystemConfig         string   `yaml:"systemConfig,omitempty" validate:"required"`
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Mode is the default of LogConfigs/ClusterLogConfigs and pods without annotation sidecar.loggie.io/mode
+	Mode string `yaml:"mode,omitempty" default:"sidecar" validate:"oneof=sidecar volume"`
+	// ClusterName is the cluster the operator runs in, the configs with a different selector.cluster are not injected
+	ClusterName          string    `yaml:"clusterName,omitempty"`
+	Image                string    `yaml:"image,omitempty" validate:"required"`
+	IgnoreNamespaces     []string  `yaml:"ignoreNamespaces,omitempty"`
+	IgnoreContainerNames []string  `yaml:"ignoreContainerNames,omitempty"`
+	SystemConfig         string    `yaml:"systemConfig,omitempty" validate:"required"`
+	Restart              Restart   `yaml:"restart,omitempty"`
+	Upgrade              Upgrade   `yaml:"upgrade,omitempty"`
+	Canary               *Canary   `yaml:"canary,omitempty"`
+	Stdout               Stdout    `yaml:"stdout,omitempty"`
+	Ephemeral            Ephemeral `yaml:"ephemeral,omitempty"`
+	Heartbeat            Heartbeat `yaml:"heartbeat,omitempty"`
+	Probes               Probes    `yaml:"probes,omitempty"`
+	Metrics              Metrics   `yaml:"metrics,omitempty"`
+	// PortConflict resolves the conflicts between the HTTP server of Loggie in SystemConfig and the ports of pods
+	PortConflict PortConflict `yaml:"portConflict,omitempty"`
+	// DefaultSinkRef is the Sink of the LogConfigs/ClusterLogConfigs without sink,
+	// if the namespace of LogConfig has no annotation sidecar.loggie.io/default-sink
+	DefaultSinkRef string `yaml:"defaultSinkRef,omitempty"`
+	// Interceptors are added to every injected pipeline, which cannot be removed or overridden by LogConfigs
+	Interceptors string `yaml:"interceptors,omitempty"`
+	// ExpiryNotice is how long before the expiry of LogConfigs/ClusterLogConfigs the events are emitted
+	ExpiryNotice time.Duration `yaml:"expiryNotice,omitempty" default:"1h"`
+}
+
+// Ephemeral collects the logs of running pods on demand by ephemeral containers, see annotation sidecar.loggie.io/collect
+type Ephemeral struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Command runs loggie in the sidecar image
+	Command []string `yaml:"command,omitempty" default:"[\"/loggie\"]"`
+	// Timeout wraps Command to stop it at expiry, with the seconds appended, since ephemeral containers cannot be
+	// removed. The sidecar image must provide it, such as timeout of coreutils or busybox.
+	Timeout []string `yaml:"timeout,omitempty" default:"[\"timeout\"]" validate:"min=1"`
+	// DefaultDuration is how long the ephemeral container runs without annotation sidecar.loggie.io/collect-duration
+	DefaultDuration time.Duration `yaml:"defaultDuration,omitempty" default:"1h"`
+	MaxDuration     time.Duration `yaml:"maxDuration,omitempty" default:"24h"`
+}
+
+// Heartbeat wraps the sidecar with loggie-reporter, which reports the pipeline metrics of Loggie to the operator periodically.
+// loggie-reporter is copied from Image by an init container.
+type Heartbeat struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// URL is the heartbeat endpoint of the operator, such as https://loggie-operator.loggie.svc:9443/heartbeat
+	URL string `yaml:"url,omitempty"`
+	// Image contains loggie-reporter at /usr/local/bin/loggie-reporter, such as the image of the operator
+	Image string `yaml:"image,omitempty"`
+	// Command runs loggie in the sidecar image
+	Command []string `yaml:"command,omitempty" default:"[\"/loggie\"]"`
+	// InsecureSkipVerify skips verifying the certificate of the webhook server, which is usually self-signed
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
+	// Interval is the period of reports, and a sidecar is stale if it has not reported for Timeout
+	Interval time.Duration `yaml:"interval,omitempty" default:"30s"`
+	Timeout  time.Duration `yaml:"timeout,omitempty" default:"2m"`
+	// Audience is of the ServiceAccount token projected to the sidecar, which authenticates the reports by TokenReview
+	Audience string `yaml:"audience,omitempty" default:"loggie-operator"`
+}
+
+// Probes adds the liveness and readiness probes to the sidecar on the HTTP server of Loggie,
+// which requires loggie.http.enabled in SystemConfig
+type Probes struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Path is requested by the probes, which is served once Loggie is started
+	Path             string        `yaml:"path,omitempty" default:"/api/v1/controller/pipelines"`
+	InitialDelay     time.Duration `yaml:"initialDelay,omitempty" default:"10s"`
+	Period           time.Duration `yaml:"period,omitempty" default:"10s"`
+	Timeout          time.Duration `yaml:"timeout,omitempty" default:"1s"`
+	FailureThreshold int           `yaml:"failureThreshold,omitempty" default:"3" validate:"gte=1"`
+}
+
+// Metrics exposes the metrics of the sidecar, whose port named loggie-metrics is declared if loggie.http.enabled in SystemConfig
+type Metrics struct {
+	// ScrapeAnnotations adds prometheus.io/scrape, prometheus.io/port and prometheus.io/path to the injected pods,
+	// unless the pods have them already
+	ScrapeAnnotations bool `yaml:"scrapeAnnotations,omitempty"`
+}
+
+// PortConflict resolves the conflicts between loggie.http.port in SystemConfig and the container ports of a pod.
+// The pods in host network share the ports of the node, so ReservedHostPorts are taken as well.
+type PortConflict struct {
+	// Policy is auto which rewrites loggie.http.port with the next free port, or reject
+	Policy string `yaml:"policy,omitempty" default:"auto" validate:"oneof=auto reject"`
+	// ReservedHostPorts are listened on the nodes, such as the HTTP port of the Loggie DaemonSet
+	ReservedHostPorts []int `yaml:"reservedHostPorts,omitempty" default:"[9196]"`
+}
+
+// Stdout captures the stdout and stderr of app containers for the LogConfigs collecting path stdout.
+// The app containers are wrapped by loggie-tee, which is copied from Image by an init container.
+type Stdout struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Image contains loggie-tee at /usr/local/bin/loggie-tee, such as the image of the operator
+	Image string `yaml:"image,omitempty"`
+	// Entrypoints are the ENTRYPOINT and CMD of images, which are used to wrap the containers without command.
+	// The pod annotation entrypoint.sidecar.loggie.io/{container} takes precedence.
+	Entrypoints map[string]Entrypoint `yaml:"entrypoints,omitempty"`
+	// MaxFileSize is the size in MB of the stdout file before rotated
+	MaxFileSize int `yaml:"maxFileSize,omitempty" default:"100" validate:"gte=1"`
+}
+
+type Entrypoint struct {
+	Entrypoint []string `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty"`
+	Cmd        []string `yaml:"cmd,omitempty" json:"cmd,omitempty"`
+}
+
+// Canary injects a candidate image to part of the pods. A pod is in canary if it is in any of the Namespaces,
+// matches the LabelSelector, or its workload is in the Percentage, and the pods of a workload always run the same image.
+type Canary struct {
+	Image         string            `yaml:"image,omitempty" validate:"required"`
+	Percentage    int               `yaml:"percentage,omitempty" validate:"gte=0,lte=100"`
+	Namespaces    []string          `yaml:"namespaces,omitempty"`
+	LabelSelector map[string]string `yaml:"labelSelector,omitempty"`
+}
+
+// Restart controls how workloads are restarted when their injected sidecar config is out of date
+type Restart struct {
+	// Policy is the default of LogConfigs/ClusterLogConfigs without annotation sidecar.loggie.io/restart-policy
+	Policy string `yaml:"policy,omitempty" default:"never" validate:"oneof=never auto"`
+	// Paused stops restarting any workloads
+	Paused bool `yaml:"paused,omitempty"`
+	// Interval is the minimum interval between two workload restarts, Burst restarts are allowed at once
+	Interval time.Duration `yaml:"interval,omitempty" default:"30s"`
+	Burst    int           `yaml:"burst,omitempty" default:"1" validate:"gte=1"`
+	// MaintenanceWindows restricts the time to restart workloads, workloads could be restarted at any time if it is empty
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenanceWindows,omitempty"`
+	TimeZone           string              `yaml:"timeZone,omitempty" default:"UTC"`
+}
+
+// Upgrade controls how workloads are restarted in batches when the sidecar image changes
+type Upgrade struct {
+	// Enabled starts the fleet upgrade, otherwise the version spread of sidecars is reported only
+	Enabled bool `yaml:"enabled,omitempty"`
+	// BatchSize is the number of workloads upgraded at the same time
+	BatchSize int `yaml:"batchSize,omitempty" default:"1" validate:"gte=1"`
+	// Interval is the period to report the version spread and check the progress of the upgrade
+	Interval time.Duration `yaml:"interval,omitempty" default:"1m"`
+	// HealthTimeout is the time for the new sidecars of a workload to be ready, or the workload would be rolled back
+	HealthTimeout time.Duration `yaml:"healthTimeout,omitempty" default:"10m"`
+}
+
+type MaintenanceWindow struct {
+	// Days are the weekdays of the window, eg: Sat, Sun. Empty means every day
+	Days []string `yaml:"days,omitempty"`
+	// Start and End are in the format of 15:04, End before Start means the window ends in the next day
+	Start string `yaml:"start,omitempty" validate:"required"`
+	End   string `yaml:"end,omitempty" validate:"required"`
+}
+
+func (c *Config) Validate() error {
+	if c.Sidecar == nil {
+		return nil
+	}
+	if _, err := c.Sidecar.MandatoryInterceptors(); err != nil {
+		return errors.WithMessage(err, "invalid interceptors")
+	}
+	if c.Sidecar.Stdout.Enabled && c.Sidecar.Stdout.Image == "" {
+		return errors.New("stdout.image is required when stdout capture is enabled")
+	}
+	if c.Sidecar.Heartbeat.Enabled && (c.Sidecar.Heartbeat.URL == "" || c.Sidecar.Heartbeat.Image == "") {
+		return errors.New("heartbeat.url and heartbeat.image are required when heartbeat is enabled")
+	}
+	return c.Sidecar.Restart.Validate()
+}
+
+// MandatoryInterceptors parses Interceptors, which returns a new list every time since the interceptors are merged in place
+func (s *Sidecar) MandatoryInterceptors() ([]*interceptor.Config, error) {
+	interceptors := make([]*interceptor.Config, 0)
+	if s.Interceptors == "" {
+		return interceptors, nil
+	}
+	if err := cfg.UnPackFromRaw([]byte(s.Interceptors), &interceptors).Do(); err != nil {
+		return nil, err
+	}
+	return interceptors, nil
+}
+
+func (r *Restart) Validate() error {
+	if _, err := time.LoadLocation(r.TimeZone); err != nil {
+		return errors.WithMessagef(err, "invalid restart.timeZone %s", r.TimeZone)
+	}
+
+	for _, w := range r.MaintenanceWindows {
+		if _, err := time.Parse("15:04", w.Start); err != nil {
+			return errors.Errorf("invalid maintenance window start %s, should be in format 15:04", w.Start)
+		}
+		if _, err := time.Parse("15:04", w.End); err != nil {
+			return errors.Errorf("invalid maintenance window end %s, should be in format 15:04", w.End)
+		}
+		for _, d := range w.Days {
+			if _, ok := Weekdays[d]; !ok {
+				return errors.Errorf("invalid maintenance window day %s, should be one of Mon, Tue, Wed, Thu, Fri, Sat, Sun", d)
+			}
+		}
+	}
+	return nil
+}
+
+var Weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
 }
@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/loggie-io/loggie/pkg/core/cfg"
+	"sync"
+	"sync/atomic"
+)
+
+// Parse unpacks the configuration with defaults, and validates it
+func Parse(raw []byte) (*Config, error) {
+	conf := &Config{}
+	if err := cfg.UnPackFromRaw(raw, conf).Defaults().Validate().Do(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Store holds the active configuration, which is replaced as a whole when it is reloaded.
+// The configuration returned by Load must not be modified, and the components read it once for each request,
+// so a request is served with the same configuration from the beginning to the end.
+type Store struct {
+	mu         sync.Mutex
+	value      atomic.Value
+	generation int64
+}
+
+func NewStore(conf *Config) *Store {
+	s := &Store{}
+	s.Swap(conf)
+	return s
+}
+
+// Load returns the active configuration
+func (s *Store) Load() *Config {
+	return s.value.Load().(*Config)
+}
+
+// Swap activates the configuration, and returns its generation
+func (s *Store) Swap(conf *Config) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value.Store(conf)
+	return atomic.AddInt64(&s.generation, 1)
+}
+
+// Generation is the number of configurations activated, starting from 1
+func (s *Store) Generation() int64 {
+	return atomic.LoadInt64(&s.generation)
+}
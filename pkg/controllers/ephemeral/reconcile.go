@@ -0,0 +1,259 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ephemeral
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"time"
+)
+
+const (
+	// StatusAnnotationKey records the progress of the collect request in the pod, see Status
+	StatusAnnotationKey = "sidecar.loggie.io/collect-status"
+
+	PhaseRunning = "Running"
+	PhaseExpired = "Expired"
+	PhaseFailed  = "Failed"
+
+	EventReasonCollecting    = "Collecting"
+	EventReasonCollectDone   = "CollectExpired"
+	EventReasonCollectFailed = "CollectFailed"
+)
+
+// Status is the progress of the collect request of a pod
+type Status struct {
+	// Request is the hash of the request annotations, a different one means a new request
+	Request   string `json:"request"`
+	Phase     string `json:"phase"`
+	Container string `json:"container,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Reconciler attaches an ephemeral container running Loggie to the pods with annotation sidecar.loggie.io/collect,
+// and reports the progress in the pod annotation and events until the request expires.
+type Reconciler struct {
+	client.Client
+	Clientset kubernetes.Interface
+	Recorder  record.EventRecorder
+	Config    *config.Store
+	// IgnoreClusterLogConfigs is set when the operator cannot read ClusterLogConfigs
+	IgnoreClusterLogConfigs bool
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods/ephemeralcontainers,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !requested(pod) || pod.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	request := requestHash(pod)
+	status := statusOf(pod)
+	if status != nil && status.Request == request {
+		return r.sync(ctx, pod, status)
+	}
+
+	log.Info("collecting logs of pod %s by ephemeral container", req.NamespacedName)
+	container, duration, err := r.ephemeralContainer(ctx, pod, containerName(request))
+	if err != nil {
+		status = &Status{Request: request, Phase: PhaseFailed, Message: err.Error()}
+		log.Warn("collect logs of pod %s failed: %v", req.NamespacedName, err)
+		r.Recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonCollectFailed, "collect logs failed: %v", err)
+	} else {
+		// the container is named by the request, so it is not added again if recording the status failed after adding it
+		if !hasEphemeralContainer(pod, container.Name) {
+			updated := pod.DeepCopy()
+			updated.Spec.EphemeralContainers = append(updated.Spec.EphemeralContainers, *container)
+			if _, err := r.Clientset.CoreV1().Pods(pod.Namespace).UpdateEphemeralContainers(ctx, pod.Name, updated, metav1.UpdateOptions{}); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		status = &Status{
+			Request:   request,
+			Phase:     PhaseRunning,
+			Container: container.Name,
+			ExpiresAt: time.Now().Add(duration).Format(time.RFC3339),
+		}
+		r.Recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonCollecting, "collecting logs by ephemeral container %s until %s", status.Container, status.ExpiresAt)
+	}
+
+	if err := r.setStatus(ctx, pod, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return r.sync(ctx, pod, status)
+}
+
+// ephemeralContainer returns the ephemeral container of the request, and how long it runs
+func (r *Reconciler) ephemeralContainer(ctx context.Context, pod *corev1.Pod, name string) (*corev1.EphemeralContainer, time.Duration, error) {
+	conf := r.Config.Load().Sidecar
+	duration := conf.Ephemeral.DefaultDuration
+	if d, ok := pod.Annotations[webhook.CollectDurationAnnotationKey]; ok {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil {
+			return nil, 0, errors.WithMessagef(err, "invalid annotation %s", webhook.CollectDurationAnnotationKey)
+		}
+	}
+	if duration > conf.Ephemeral.MaxDuration {
+		duration = conf.Ephemeral.MaxDuration
+	}
+
+	injection := &webhook.SidecarInjection{Reader: r.Client, Config: conf, IgnoreClusterLogConfigs: r.IgnoreClusterLogConfigs}
+	lgc, err := injection.CollectLogConfig(ctx, pod)
+	if err != nil {
+		return nil, 0, err
+	}
+	container, err := injection.EphemeralSidecar(pod, name, lgc, duration)
+	if err != nil {
+		return nil, 0, err
+	}
+	return container, duration, nil
+}
+
+// sync reports the request expired or failed, otherwise requeues it at expiry
+func (r *Reconciler) sync(ctx context.Context, pod *corev1.Pod, status *Status) (ctrl.Result, error) {
+	if status.Phase != PhaseRunning {
+		return ctrl.Result{}, nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, status.ExpiresAt)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	next := *status
+	if wait := time.Until(expiresAt); wait <= 0 {
+		next.Phase = PhaseExpired
+		r.Recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonCollectDone, "ephemeral container %s expired", status.Container)
+	} else if terminated := terminatedState(pod, status.Container); terminated != nil {
+		injection := &webhook.SidecarInjection{Config: r.Config.Load().Sidecar}
+		if expired, reason := injection.EphemeralExited(terminated); expired {
+			next.Phase = PhaseExpired
+			r.Recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonCollectDone, "ephemeral container %s expired", status.Container)
+		} else {
+			next.Phase = PhaseFailed
+			next.Message = reason
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonCollectFailed, "ephemeral container %s: %s", status.Container, next.Message)
+		}
+	} else {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	log.Info("collecting logs of pod %s/%s is %s", pod.Namespace, pod.Name, next.Phase)
+	return ctrl.Result{}, r.setStatus(ctx, pod, &next)
+}
+
+func (r *Reconciler) setStatus(ctx context.Context, pod *corev1.Pod, status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	latest := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), latest); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(latest.DeepCopy())
+	if latest.Annotations == nil {
+		latest.Annotations = make(map[string]string)
+	}
+	latest.Annotations[StatusAnnotationKey] = string(data)
+	return r.Patch(ctx, latest, patch)
+}
+
+// containerName returns the ephemeral container name of the request
+func containerName(request string) string {
+	return fmt.Sprintf("%s-%s", webhook.EphemeralContainerName, request[:8])
+}
+
+func hasEphemeralContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func terminatedState(pod *corev1.Pod, container string) *corev1.ContainerStateTerminated {
+	for _, cs := range pod.Status.EphemeralContainerStatuses {
+		if cs.Name == container {
+			return cs.State.Terminated
+		}
+	}
+	return nil
+}
+
+func requested(pod *corev1.Pod) bool {
+	_, collect := pod.Annotations[webhook.CollectAnnotationKey]
+	_, pipeline := pod.Annotations[webhook.CollectPipelineAnnotationKey]
+	return collect || pipeline
+}
+
+func requestHash(pod *corev1.Pod) string {
+	h := sha256.New()
+	for _, key := range []string{webhook.CollectAnnotationKey, webhook.CollectPipelineAnnotationKey, webhook.CollectDurationAnnotationKey} {
+		h.Write([]byte(pod.Annotations[key]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func statusOf(pod *corev1.Pod) *Status {
+	data, ok := pod.Annotations[StatusAnnotationKey]
+	if !ok {
+		return nil
+	}
+	status := &Status{}
+	if err := json.Unmarshal([]byte(data), status); err != nil {
+		return nil
+	}
+	return status
+}
+
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("ephemeral").
+		For(&corev1.Pod{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			pod, ok := obj.(*corev1.Pod)
+			return ok && requested(pod)
+		}))).
+		Complete(r)
+}
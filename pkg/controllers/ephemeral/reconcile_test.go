@@ -0,0 +1,198 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ephemeral
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	utilkubernetes "github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+// statusPatcher gets the pod from the reader, and records the phases of the collect status patched
+type statusPatcher struct {
+	client.Client
+	reader  client.Reader
+	patched []string
+}
+
+func (p *statusPatcher) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return p.reader.Get(ctx, key, obj)
+}
+
+func (p *statusPatcher) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	pod := obj.(*corev1.Pod)
+	p.patched = append(p.patched, statusOf(pod).Phase)
+	return nil
+}
+
+// ephemeralUpdater records the last ephemeral container of the pods updated, which is the one added
+type ephemeralUpdater struct {
+	kubernetes.Interface
+	corev1client.CoreV1Interface
+	corev1client.PodInterface
+	added []string
+}
+
+func (u *ephemeralUpdater) CoreV1() corev1client.CoreV1Interface {
+	return u
+}
+
+func (u *ephemeralUpdater) Pods(_ string) corev1client.PodInterface {
+	return u
+}
+
+func (u *ephemeralUpdater) UpdateEphemeralContainers(_ context.Context, _ string, pod *corev1.Pod, _ metav1.UpdateOptions) (*corev1.Pod, error) {
+	containers := pod.Spec.EphemeralContainers
+	u.added = append(u.added, containers[len(containers)-1].Name)
+	return pod, nil
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	log.InitDefaultLogger()
+
+	running := func(pod *corev1.Pod, expiresAt time.Time) {
+		request := requestHash(pod)
+		pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: containerName(request)}}}
+		pod.Annotations[StatusAnnotationKey] = `{"request":"` + request + `","phase":"Running","container":"` + containerName(request) + `","expiresAt":"` + expiresAt.Format(time.RFC3339) + `"}`
+	}
+	exited := func(code int32) func(pod *corev1.Pod) {
+		return func(pod *corev1.Pod) {
+			running(pod, time.Now().Add(time.Hour))
+			pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+				Name:  pod.Spec.EphemeralContainers[0].Name,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: code, Reason: "Error"}},
+			}}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		setup       func(pod *corev1.Pod)
+		wantAdded   bool
+		wantPatched []string
+		wantRequeue bool
+	}{
+		{
+			name:        "new request",
+			setup:       func(pod *corev1.Pod) {},
+			wantAdded:   true,
+			wantPatched: []string{PhaseRunning},
+			wantRequeue: true,
+		},
+		{
+			name: "changed request",
+			setup: func(pod *corev1.Pod) {
+				running(pod, time.Now().Add(time.Hour))
+				pod.Annotations[webhook.CollectDurationAnnotationKey] = "30m"
+			},
+			wantAdded:   true,
+			wantPatched: []string{PhaseRunning},
+			wantRequeue: true,
+		},
+		{
+			name: "same request",
+			setup: func(pod *corev1.Pod) {
+				running(pod, time.Now().Add(time.Hour))
+			},
+			wantRequeue: true,
+		},
+		{
+			// the container was attached, but recording the status failed
+			name: "attached without status",
+			setup: func(pod *corev1.Pod) {
+				running(pod, time.Now().Add(time.Hour))
+				delete(pod.Annotations, StatusAnnotationKey)
+			},
+			wantPatched: []string{PhaseRunning},
+			wantRequeue: true,
+		},
+		{
+			name: "expired",
+			setup: func(pod *corev1.Pod) {
+				running(pod, time.Now().Add(-time.Minute))
+			},
+			wantPatched: []string{PhaseExpired},
+		},
+		{
+			name:        "stopped by timeout",
+			setup:       exited(124),
+			wantPatched: []string{PhaseExpired},
+		},
+		{
+			name:        "timeout not executable",
+			setup:       exited(126),
+			wantPatched: []string{PhaseFailed},
+		},
+		{
+			name:        "timeout not found",
+			setup:       exited(127),
+			wantPatched: []string{PhaseFailed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			pod.Namespace = "default"
+			pod.Name = "tomcat"
+			pod.Annotations = map[string]string{
+				webhook.CollectPipelineAnnotationKey: "sources: |\n  - type: file\n    name: app\n    paths: [/usr/local/tomcat/logs/*.log]\nsink: |\n  type: dev\n",
+			}
+			pod.Spec.Containers = []corev1.Container{{
+				Name:         "tomcat",
+				VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/usr/local/tomcat/logs"}},
+			}}
+			tt.setup(pod)
+
+			reader, err := utilkubernetes.NewObjectReader(clientgoscheme.Scheme, pod)
+			assert.NoError(t, err)
+			cli := &statusPatcher{reader: reader}
+			clientset := &ephemeralUpdater{}
+			r := &Reconciler{
+				Client:    cli,
+				Clientset: clientset,
+				Recorder:  record.NewFakeRecorder(10),
+				Config: config.NewStore(&config.Config{Sidecar: &config.Sidecar{
+					Image:     "loggieio/loggie:main",
+					Ephemeral: config.Ephemeral{Timeout: []string{"timeout"}, Command: []string{"/loggie"}, DefaultDuration: time.Hour, MaxDuration: 24 * time.Hour},
+				}}),
+			}
+
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRequeue, result.RequeueAfter > 0)
+			assert.Equal(t, tt.wantPatched, cli.patched)
+			if tt.wantAdded {
+				assert.Equal(t, []string{containerName(requestHash(pod))}, clientset.added)
+			} else {
+				assert.Empty(t, clientset.added)
+			}
+		})
+	}
+}
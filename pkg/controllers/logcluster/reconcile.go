@@ -0,0 +1,172 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logcluster
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	EventReasonReconciled      = "Reconciled"
+	EventReasonReconcileFailed = "ReconcileFailed"
+)
+
+// Reconciler runs the Loggie aggregators of LogCluster by a Deployment or StatefulSet, with the ConfigMap of its config,
+// a Service of its ports, and a PodDisruptionBudget if it has more than 1 replicas. The objects are owned by the LogCluster,
+// so they are deleted with it.
+type Reconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=operator.loggie.io,resources=logclusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operator.loggie.io,resources=logclusters/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log.Info("reconciling logCluster %s", req.NamespacedName)
+
+	lc := &operatorv1beta1.LogCluster{}
+	if err := r.Get(ctx, req.NamespacedName, lc); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if lc.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	status, err := r.reconcile(ctx, lc)
+	if err != nil {
+		log.Warn("reconcile logCluster %s failed: %v", req.NamespacedName, err)
+		r.Recorder.Eventf(lc, corev1.EventTypeWarning, EventReasonReconcileFailed, "reconcile failed: %v", err)
+		status = lc.Status.DeepCopy()
+		status.Message = err.Error()
+	}
+
+	status.ObservedGeneration = lc.Generation
+	status.Endpoint = lc.Endpoint()
+	if *status != lc.Status {
+		lc.Status = *status
+		if err := r.Status().Update(ctx, lc); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+// reconcile creates or updates the objects of the LogCluster, and returns its status
+func (r *Reconciler) reconcile(ctx context.Context, lc *operatorv1beta1.LogCluster) (*operatorv1beta1.LogClusterStatus, error) {
+	meta := objectMeta(lc)
+
+	cm := &corev1.ConfigMap{ObjectMeta: meta}
+	if err := r.apply(ctx, lc, cm, func() { buildConfigMap(lc, cm) }); err != nil {
+		return nil, err
+	}
+	svc := &corev1.Service{ObjectMeta: meta}
+	if err := r.apply(ctx, lc, svc, func() { buildService(lc, svc) }); err != nil {
+		return nil, err
+	}
+
+	status := &operatorv1beta1.LogClusterStatus{}
+	deploy := &appsv1.Deployment{ObjectMeta: meta}
+	sts := &appsv1.StatefulSet{ObjectMeta: meta}
+	switch lc.Spec.Workload {
+	case "", operatorv1beta1.WorkloadDeployment:
+		if err := r.apply(ctx, lc, deploy, func() { buildDeployment(lc, deploy) }); err != nil {
+			return nil, err
+		}
+		if err := r.remove(ctx, lc, sts); err != nil {
+			return nil, err
+		}
+		status.Replicas, status.ReadyReplicas = deploy.Status.Replicas, deploy.Status.ReadyReplicas
+	case operatorv1beta1.WorkloadStatefulSet:
+		if err := r.apply(ctx, lc, sts, func() { buildStatefulSet(lc, sts) }); err != nil {
+			return nil, err
+		}
+		if err := r.remove(ctx, lc, deploy); err != nil {
+			return nil, err
+		}
+		status.Replicas, status.ReadyReplicas = sts.Status.Replicas, sts.Status.ReadyReplicas
+	default:
+		return nil, errors.Errorf("workload %s is not supported", lc.Spec.Workload)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: meta}
+	if replicas(lc) > 1 {
+		if err := r.apply(ctx, lc, pdb, func() { buildPodDisruptionBudget(lc, pdb) }); err != nil {
+			return nil, err
+		}
+	} else if err := r.remove(ctx, lc, pdb); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// apply creates or updates the object owned by the LogCluster
+func (r *Reconciler) apply(ctx context.Context, lc *operatorv1beta1.LogCluster, obj client.Object, build func()) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		build()
+		return controllerutil.SetControllerReference(lc, obj, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		kind := reflect.TypeOf(obj).Elem().Name()
+		log.Info("%s %s of logCluster %s/%s", result, kind, lc.Namespace, lc.Name)
+		r.Recorder.Eventf(lc, corev1.EventTypeNormal, EventReasonReconciled, "%s %s %s", result, kind, obj.GetName())
+	}
+	return nil
+}
+
+// remove deletes the object owned by the LogCluster which is not needed any more, such as the workload of the previous type
+func (r *Reconciler) remove(ctx context.Context, lc *operatorv1beta1.LogCluster, obj client.Object) error {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, lc) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&operatorv1beta1.LogCluster{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Complete(r)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logcluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// ConfigHashAnnotationKey of the pod template rolls the aggregator pods when the config changes
+	ConfigHashAnnotationKey = "operator.loggie.io/config-hash"
+
+	ContainerName    = "loggie"
+	ConfigVolumeName = "loggie-config"
+	ConfigPath       = "/opt/loggie"
+	SystemConfigFile = "loggie.yml"
+	PipelinesFile    = "pipelines.yml"
+
+	PortNameGRPC = "grpc"
+	PortNameHTTP = "http"
+)
+
+// labels are the labels of the objects of the LogCluster, and the selector of its pods
+func labels(lc *operatorv1beta1.LogCluster) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "loggie",
+		"app.kubernetes.io/instance":   lc.Name,
+		"app.kubernetes.io/component":  "aggregator",
+		"app.kubernetes.io/managed-by": "loggie-operator",
+	}
+}
+
+func objectMeta(lc *operatorv1beta1.LogCluster) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: lc.Namespace, Name: lc.Name}
+}
+
+func replicas(lc *operatorv1beta1.LogCluster) int32 {
+	if lc.Spec.Replicas == nil {
+		return 1
+	}
+	return *lc.Spec.Replicas
+}
+
+// buildConfigMap sets the system config and pipelines of the aggregator
+func buildConfigMap(lc *operatorv1beta1.LogCluster, cm *corev1.ConfigMap) {
+	cm.Labels = labels(lc)
+	cm.Data = map[string]string{
+		SystemConfigFile: lc.Spec.SystemConfig,
+		PipelinesFile:    lc.Spec.Pipelines,
+	}
+}
+
+// buildService exposes the grpc and http ports of the aggregator
+func buildService(lc *operatorv1beta1.LogCluster, svc *corev1.Service) {
+	svc.Labels = labels(lc)
+	svc.Annotations = lc.Spec.Service.Annotations
+	svc.Spec.Type = lc.Spec.Service.Type
+	if svc.Spec.Type == "" {
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+	}
+	svc.Spec.Selector = labels(lc)
+	// keep the node ports allocated
+	nodePorts := make(map[string]int32)
+	for _, p := range svc.Spec.Ports {
+		nodePorts[p.Name] = p.NodePort
+	}
+	svc.Spec.Ports = []corev1.ServicePort{
+		{Name: PortNameGRPC, Port: lc.GRPCPort(), TargetPort: intstr.FromString(PortNameGRPC), NodePort: nodePorts[PortNameGRPC]},
+		{Name: PortNameHTTP, Port: lc.HTTPPort(), TargetPort: intstr.FromString(PortNameHTTP), NodePort: nodePorts[PortNameHTTP]},
+	}
+}
+
+// buildPodTemplate runs Loggie with the config in the ConfigMap
+func buildPodTemplate(lc *operatorv1beta1.LogCluster, tmpl *corev1.PodTemplateSpec) {
+	tmpl.Labels = labels(lc)
+	tmpl.Annotations = map[string]string{ConfigHashAnnotationKey: configHash(lc)}
+	tmpl.Spec.NodeSelector = lc.Spec.NodeSelector
+	tmpl.Spec.Tolerations = lc.Spec.Tolerations
+	tmpl.Spec.Volumes = []corev1.Volume{
+		{
+			Name: ConfigVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: lc.Name}},
+			},
+		},
+	}
+	tmpl.Spec.Containers = []corev1.Container{
+		{
+			Name:  ContainerName,
+			Image: lc.Spec.Image,
+			Args: []string{
+				"-meta.nodeName=$(HOST_NAME)",
+				"-config.system=" + ConfigPath + "/" + SystemConfigFile,
+				"-config.pipeline=" + ConfigPath + "/" + PipelinesFile,
+			},
+			Env: []corev1.EnvVar{
+				{
+					Name: "HOST_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "spec.nodeName"},
+					},
+				},
+			},
+			Ports: []corev1.ContainerPort{
+				{Name: PortNameGRPC, ContainerPort: lc.GRPCPort(), Protocol: corev1.ProtocolTCP},
+				{Name: PortNameHTTP, ContainerPort: lc.HTTPPort(), Protocol: corev1.ProtocolTCP},
+			},
+			Resources:    lc.Spec.Resources,
+			VolumeMounts: []corev1.VolumeMount{{Name: ConfigVolumeName, MountPath: ConfigPath}},
+		},
+	}
+}
+
+func buildDeployment(lc *operatorv1beta1.LogCluster, deploy *appsv1.Deployment) {
+	deploy.Labels = labels(lc)
+	r := replicas(lc)
+	deploy.Spec.Replicas = &r
+	if deploy.Spec.Selector == nil {
+		deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels(lc)}
+	}
+	buildPodTemplate(lc, &deploy.Spec.Template)
+}
+
+func buildStatefulSet(lc *operatorv1beta1.LogCluster, sts *appsv1.StatefulSet) {
+	sts.Labels = labels(lc)
+	r := replicas(lc)
+	sts.Spec.Replicas = &r
+	if sts.Spec.Selector == nil {
+		sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels(lc)}
+	}
+	sts.Spec.ServiceName = lc.Name
+	buildPodTemplate(lc, &sts.Spec.Template)
+}
+
+// buildPodDisruptionBudget keeps the aggregators available in voluntary disruptions, such as draining nodes
+func buildPodDisruptionBudget(lc *operatorv1beta1.LogCluster, pdb *policyv1.PodDisruptionBudget) {
+	pdb.Labels = labels(lc)
+	maxUnavailable := intstr.FromInt(1)
+	if lc.Spec.MaxUnavailable != nil {
+		maxUnavailable = intstr.FromInt(int(*lc.Spec.MaxUnavailable))
+	}
+	pdb.Spec.MaxUnavailable = &maxUnavailable
+	pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels(lc)}
+}
+
+func configHash(lc *operatorv1beta1.LogCluster) string {
+	h := sha256.New()
+	for _, s := range []string{lc.Spec.SystemConfig, lc.Spec.Pipelines} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logcluster
+
+import (
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func Test_buildDeployment(t *testing.T) {
+	lc := &operatorv1beta1.LogCluster{}
+	lc.Namespace = "loggie"
+	lc.Name = "aggregator"
+	lc.Spec.Image = "loggieio/loggie:main"
+	lc.Spec.SystemConfig = "loggie: {}"
+	lc.Spec.Pipelines = "pipelines: []"
+	lc.Spec.Ports.GRPC = 7000
+
+	deploy := &appsv1.Deployment{}
+	buildDeployment(lc, deploy)
+	assert.Equal(t, int32(1), *deploy.Spec.Replicas)
+	assert.Equal(t, labels(lc), deploy.Spec.Selector.MatchLabels)
+	c := deploy.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "loggieio/loggie:main", c.Image)
+	assert.Equal(t, []corev1.ContainerPort{
+		{Name: PortNameGRPC, ContainerPort: 7000, Protocol: corev1.ProtocolTCP},
+		{Name: PortNameHTTP, ContainerPort: 9196, Protocol: corev1.ProtocolTCP},
+	}, c.Ports)
+
+	// the pods are rolled when the config changes
+	hash := deploy.Spec.Template.Annotations[ConfigHashAnnotationKey]
+	lc.Spec.Pipelines = "pipelines: [{name: a}]"
+	buildDeployment(lc, deploy)
+	assert.NotEqual(t, hash, deploy.Spec.Template.Annotations[ConfigHashAnnotationKey])
+}
+
+func Test_buildService(t *testing.T) {
+	lc := &operatorv1beta1.LogCluster{}
+	lc.Name = "aggregator"
+	lc.Spec.Service.Type = corev1.ServiceTypeNodePort
+
+	svc := &corev1.Service{}
+	svc.Spec.Ports = []corev1.ServicePort{{Name: PortNameGRPC, Port: 6066, NodePort: 30066}}
+	buildService(lc, svc)
+	assert.Equal(t, corev1.ServiceTypeNodePort, svc.Spec.Type)
+	assert.Equal(t, int32(30066), svc.Spec.Ports[0].NodePort)
+	assert.Equal(t, int32(9196), svc.Spec.Ports[1].Port)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/heartbeat"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+type ClusterReconciler struct {
+	Config *config.Store
+	client.Client
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	Restarter *Restarter
+	// Heartbeats are the heartbeats of the sidecars reported to all the replicas
+	Heartbeats *heartbeat.Registry
+	// LogClusters watches the LogClusters referred by the annotation, which requires the CRD installed
+	LogClusters bool
+}
+
+func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log.Info("reconciling clusterLogConfig %s", req.Name)
+
+	clgc := &logconfigv1beta1.ClusterLogConfig{}
+	err := r.Get(ctx, req.NamespacedName, clgc)
+	if err != nil {
+		log.Info("unable to get clusterLogConfig %s", req.Name)
+		if kerrors.IsNotFound(err) {
+			metrics.DeleteConfig(webhook.KindClusterLogConfig, "", req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if clgc.DeletionTimestamp != nil {
+		log.Info("clusterLogConfig %s is deleting", req.Name)
+
+		return ctrl.Result{}, nil
+	}
+
+	if !webhook.IsSidecarConfig(clgc.ObjectMeta) {
+		return ctrl.Result{}, nil
+	}
+
+	conf := r.Config.Load().Sidecar
+	expired, expiry, err := syncExpiry(ctx, r.Client, r.Recorder, r.Restarter, conf, clgc, clgc.ToLogConfig(), &clgc.Status)
+	if err != nil {
+		log.Warn("sync expiry of clusterLogConfig %s failed: %v", req.Name, err)
+		return ctrl.Result{}, err
+	}
+	if expired {
+		return ctrl.Result{RequeueAfter: expiry}, nil
+	}
+
+	d, err := syncSidecarStatus(ctx, r.Client, r.Recorder, r.Heartbeats, conf, clgc, clgc.ToLogConfig(), &clgc.Status)
+	if err != nil {
+		log.Warn("sync status of clusterLogConfig %s failed: %v", req.Name, err)
+		return ctrl.Result{}, err
+	}
+
+	if err := syncVolumeConfig(ctx, r.Client, r.Scheme, r.Recorder, conf, clgc, clgc.ToLogConfig(), d); err != nil {
+		log.Warn("sync volume config of clusterLogConfig %s failed: %v", req.Name, err)
+		return ctrl.Result{}, err
+	}
+
+	requeue, err := r.Restarter.Restart(ctx, clgc, d, false)
+	if err != nil {
+		log.Warn("restart workloads of clusterLogConfig %s failed: %v", req.Name, err)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: minRequeue(requeue, expiry)}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&logconfigv1beta1.ClusterLogConfig{}).
+		Owns(&logconfigv1beta1.ClusterLogConfig{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(podToConfig(webhook.KindClusterLogConfig))).
+		Watches(&source.Kind{Type: &logconfigv1beta1.Sink{}}, handler.EnqueueRequestsFromMapFunc(r.referencingClusterLogConfigs)).
+		Watches(&source.Kind{Type: &logconfigv1beta1.Interceptor{}}, handler.EnqueueRequestsFromMapFunc(r.referencingClusterLogConfigs)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.defaultSinkClusterLogConfigs))
+	if r.LogClusters {
+		b = b.Watches(&source.Kind{Type: &operatorv1beta1.LogCluster{}}, handler.EnqueueRequestsFromMapFunc(r.logClusterClusterLogConfigs))
+	}
+	if r.Heartbeats != nil {
+		b = b.Watches(&source.Channel{Source: r.Heartbeats.Events(webhook.KindClusterLogConfig)}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(r)
+}
+
+// defaultSinkClusterLogConfigs enqueues the ClusterLogConfigs without sink, which use the default sink of the namespaces of pods
+func (r *ClusterReconciler) defaultSinkClusterLogConfigs(_ client.Object) []reconcile.Request {
+	clgcList := &logconfigv1beta1.ClusterLogConfigList{}
+	if err := r.List(context.Background(), clgcList); err != nil {
+		log.Warn("list clusterLogConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, clgc := range clgcList.Items {
+		if clgc.Spec.Pipeline != nil && clgc.Spec.Pipeline.Sink == "" && clgc.Spec.Pipeline.SinkRef == "" {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: clgc.Name}})
+		}
+	}
+	return reqs
+}
+
+// referencingClusterLogConfigs enqueues the ClusterLogConfigs which refer to the Sink or Interceptor
+func (r *ClusterReconciler) referencingClusterLogConfigs(obj client.Object) []reconcile.Request {
+	clgcList := &logconfigv1beta1.ClusterLogConfigList{}
+	if err := r.List(context.Background(), clgcList); err != nil {
+		log.Warn("list clusterLogConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, clgc := range clgcList.Items {
+		if refers(clgc.Spec.Pipeline, obj) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: clgc.Name}})
+		}
+	}
+	return reqs
+}
+
+// logClusterClusterLogConfigs enqueues the ClusterLogConfigs which send the logs to the LogCluster by the annotation
+func (r *ClusterReconciler) logClusterClusterLogConfigs(obj client.Object) []reconcile.Request {
+	clgcList := &logconfigv1beta1.ClusterLogConfigList{}
+	if err := r.List(context.Background(), clgcList); err != nil {
+		log.Warn("list clusterLogConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, clgc := range clgcList.Items {
+		if refersLogCluster(clgc.Annotations, "", obj) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: clgc.Name}})
+		}
+	}
+	return reqs
+}
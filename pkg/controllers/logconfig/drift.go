@@ -0,0 +1,151 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+// drift is the difference between the running sidecars and the current LogConfig/ClusterLogConfig
+type drift struct {
+	// Hash is the config hash with the stable image, which identifies the current config in restarts
+	Hash string
+	// Injected is the number of running pods injected with the config
+	Injected int
+	// Volume is the number of injected pods in volume mode, whose logs are collected by the Loggie DaemonSet
+	Volume int
+	// Outdated is the number of injected pods whose config hash is different from the one they would be injected with now
+	Outdated int
+	// Workloads are the workloads of the outdated pods
+	Workloads []kubernetes.Workload
+	// InjectedWorkloads are the workloads of all the injected pods
+	InjectedWorkloads []kubernetes.Workload
+}
+
+// checkDrift renders the sidecar config of the LogConfig, and compares its hash with the one stamped on the injected pods.
+// The hash of each pod is computed with the image it would be injected with, so canary and pinned pods are not outdated.
+// A ClusterLogConfig is rendered for each namespace of its pods, since the default sink of the namespace may differ.
+func checkDrift(ctx context.Context, cli client.Client, conf *config.Sidecar, lgc *logconfigv1beta1.LogConfig) (*drift, error) {
+	pods, err := injectedPods(ctx, cli, lgc)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make(map[string]string)
+	render := func(namespace string) (string, error) {
+		if pipes, ok := rendered[namespace]; ok {
+			return pipes, nil
+		}
+		pipes, err := webhook.RenderPipelines(conf, lgc, namespace, cli)
+		if err != nil {
+			return "", err
+		}
+		rendered[namespace] = pipes
+		return pipes, nil
+	}
+	if lgc.Namespace != "" {
+		if _, err := render(lgc.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &drift{}
+	workloads := make(map[kubernetes.Workload]bool)
+	injectedWorkloads := make(map[kubernetes.Workload]bool)
+	for _, pod := range pods {
+		d.Injected++
+		if w := kubernetes.WorkloadOf(pod); !injectedWorkloads[w] {
+			injectedWorkloads[w] = true
+			d.InjectedWorkloads = append(d.InjectedWorkloads, w)
+		}
+		if _, ok := pod.Labels[webhook.VolumeConfigLabelKey]; ok {
+			d.Volume++
+			continue
+		}
+		pipes, err := render(pod.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		hash := webhook.ConfigHash(webhook.PodImage(conf, pod), conf.SystemConfig, pipes)
+		if pod.Annotations[webhook.ConfigHashAnnotationKey] == hash {
+			continue
+		}
+
+		d.Outdated++
+		w := kubernetes.WorkloadOf(pod)
+		if !workloads[w] {
+			workloads[w] = true
+			d.Workloads = append(d.Workloads, w)
+		}
+	}
+
+	d.Hash = webhook.ConfigHash(conf.Image, conf.SystemConfig, joinRendered(rendered))
+	return d, nil
+}
+
+// joinRendered joins the pipelines rendered for the namespaces in order, which are the pipelines of the namespace for a LogConfig
+func joinRendered(rendered map[string]string) string {
+	namespaces := make([]string, 0, len(rendered))
+	for ns := range rendered {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var pipes []string
+	for _, ns := range namespaces {
+		pipes = append(pipes, rendered[ns])
+	}
+	return strings.Join(pipes, "---\n")
+}
+
+// injectedPods returns the running pods injected with the LogConfig, or the ClusterLogConfig it is converted from
+func injectedPods(ctx context.Context, cli client.Client, lgc *logconfigv1beta1.LogConfig) ([]*corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := cli.List(ctx, podList, client.InNamespace(lgc.Namespace)); err != nil {
+		return nil, err
+	}
+
+	ref := webhook.ConfigRef(lgc)
+	var pods []*corev1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Annotations[webhook.ConfigAnnotationKey] != ref {
+			continue
+		}
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (d *drift) workloads() string {
+	var names []string
+	for _, w := range d.Workloads {
+		names = append(names, w.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpiryNoticedAnnotationKey is the expiry which the Expiring event has been emitted for, so it is emitted once
+	ExpiryNoticedAnnotationKey = "sidecar.loggie.io/expiry-noticed"
+
+	EventReasonExpiring      = "Expiring"
+	EventReasonExpired       = "Expired"
+	EventReasonInvalidExpiry = "InvalidExpiry"
+
+	expiredReasonPrefix = "expired at"
+)
+
+// syncExpiry handles the expiry of the LogConfig/ClusterLogConfig. An event is emitted once in the notice period before expiry.
+// After expiry, the config is not injected by the webhook, and it is removed from the running sidecars by restarting
+// their workloads regardless of the restart policy, but in the maintenance windows unless paused. It returns true if the config
+// has expired, and when to check again.
+func syncExpiry(ctx context.Context, cli client.Client, recorder record.EventRecorder, restarter *Restarter, conf *config.Sidecar,
+	obj client.Object, lgc *logconfigv1beta1.LogConfig, status *logconfigv1beta1.Status) (bool, time.Duration, error) {
+
+	expiresAt, ok, err := webhook.ExpiresAt(obj)
+	if err != nil {
+		recorder.Event(obj, corev1.EventTypeWarning, EventReasonInvalidExpiry, err.Error())
+		return false, 0, nil
+	}
+	if !ok {
+		return false, 0, nil
+	}
+
+	until := time.Until(expiresAt)
+	if until > conf.ExpiryNotice {
+		return false, until - conf.ExpiryNotice, nil
+	}
+	if until > 0 {
+		if err := noticeExpiry(ctx, cli, recorder, obj, expiresAt); err != nil {
+			return false, 0, err
+		}
+		return false, until, nil
+	}
+
+	pods, err := injectedPods(ctx, cli, lgc)
+	if err != nil {
+		return true, 0, err
+	}
+	d := &drift{
+		// restart the workloads once for the expiry
+		Hash:     fmt.Sprintf("expired-%d", expiresAt.Unix()),
+		Injected: len(pods),
+		Outdated: len(pods),
+	}
+	workloads := make(map[kubernetes.Workload]bool)
+	for _, pod := range pods {
+		w := kubernetes.WorkloadOf(pod)
+		if !workloads[w] {
+			workloads[w] = true
+			d.Workloads = append(d.Workloads, w)
+		}
+	}
+
+	kind, namespace, name, err := webhook.ParseConfigRef(webhook.ConfigRef(lgc))
+	if err != nil {
+		return true, 0, err
+	}
+	metrics.InjectedPods.WithLabelValues(kind, namespace, name).Set(float64(d.Injected))
+	metrics.OutdatedPods.WithLabelValues(kind, namespace, name).Set(float64(d.Outdated))
+
+	reason := fmt.Sprintf("%s %s, removed from all the sidecars", expiredReasonPrefix, expiresAt.Format(time.RFC3339))
+	if d.Injected > 0 {
+		reason = fmt.Sprintf("%s %s, %d injected pods are still running, workloads: %s", expiredReasonPrefix,
+			expiresAt.Format(time.RFC3339), d.Injected, d.workloads())
+	}
+	if status.Message.Reason != reason {
+		if !strings.HasPrefix(status.Message.Reason, expiredReasonPrefix) {
+			log.Info("%s %s: %s", kind, client.ObjectKeyFromObject(obj), reason)
+			recorder.Event(obj, corev1.EventTypeWarning, EventReasonExpired, reason)
+		}
+		status.Message = logconfigv1beta1.Message{
+			Reason:             reason,
+			LastTransitionTime: time.Now().Format(time.RFC3339),
+			ObservedGeneration: obj.GetGeneration(),
+		}
+		if err := cli.Status().Update(ctx, obj); err != nil {
+			return true, 0, err
+		}
+	}
+
+	requeue, err := restarter.Restart(ctx, obj, d, true)
+	return true, requeue, err
+}
+
+// noticeExpiry emits the Expiring event, and records the expiry in ExpiryNoticedAnnotationKey so it is not emitted on every reconcile.
+// A changed expiry is noticed again.
+func noticeExpiry(ctx context.Context, cli client.Client, recorder record.EventRecorder, obj client.Object, expiresAt time.Time) error {
+	at := expiresAt.Format(time.RFC3339)
+	if obj.GetAnnotations()[ExpiryNoticedAnnotationKey] == at {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[ExpiryNoticedAnnotationKey] = at
+	obj.SetAnnotations(annotations)
+	if err := cli.Patch(ctx, obj, patch); err != nil {
+		return err
+	}
+
+	recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonExpiring, "expires at %s, and would be removed from the sidecars", at)
+	return nil
+}
+
+// minRequeue returns the earlier one of the non-zero durations
+func minRequeue(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
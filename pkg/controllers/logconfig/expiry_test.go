@@ -0,0 +1,47 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+	"testing"
+	"time"
+)
+
+func Test_noticeExpiry(t *testing.T) {
+	cli := &patchRecorder{}
+	recorder := record.NewFakeRecorder(10)
+	lgc := &logconfigv1beta1.LogConfig{}
+	lgc.Namespace = "default"
+	lgc.Name = "tomcat"
+	expiresAt := time.Date(2023, 3, 4, 16, 0, 0, 0, time.UTC)
+
+	// reconciled twice in the notice period, the event is emitted once
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, noticeExpiry(context.Background(), cli, recorder, lgc, expiresAt))
+	}
+	assert.Equal(t, []string{"default/tomcat"}, cli.patched)
+	assert.Equal(t, "2023-03-04T16:00:00Z", lgc.Annotations[ExpiryNoticedAnnotationKey])
+	assert.Len(t, recorder.Events, 1)
+
+	// the changed expiry is noticed again
+	assert.NoError(t, noticeExpiry(context.Background(), cli, recorder, lgc, expiresAt.Add(time.Hour)))
+	assert.Len(t, recorder.Events, 2)
+}
@@ -20,18 +20,44 @@ import (
 	"context"
 	"github.com/loggie-io/loggie/pkg/core/log"
 	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
 	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/heartbeat"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type Reconciler struct {
-	Config *config.Config
+	Config *config.Store
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	Restarter *Restarter
+	// Heartbeats are the heartbeats of the sidecars reported to all the replicas
+	Heartbeats *heartbeat.Registry
+	// LogClusters watches the LogClusters referred by the annotation, which requires the CRD installed
+	LogClusters bool
 }
 
+//+kubebuilder:rbac:groups=loggie.io,resources=logconfigs;clusterlogconfigs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=loggie.io,resources=logconfigs/status;clusterlogconfigs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=loggie.io,resources=sinks;interceptors,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operator.loggie.io,resources=logclusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods;namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log.Info("reconciling logConfig %s", req.NamespacedName)
 
@@ -39,6 +65,9 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	err := r.Get(ctx, req.NamespacedName, lgc)
 	if err != nil {
 		log.Info("unable to get logConfig %s", req.NamespacedName)
+		if kerrors.IsNotFound(err) {
+			metrics.DeleteConfig(webhook.KindLogConfig, req.Namespace, req.Name)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
@@ -47,14 +76,146 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 
 		return ctrl.Result{}, nil
 	}
-	return ctrl.Result{}, nil
+
+	if !webhook.IsSidecarConfig(lgc.ObjectMeta) {
+		return ctrl.Result{}, nil
+	}
+
+	conf := r.Config.Load().Sidecar
+	expired, expiry, err := syncExpiry(ctx, r.Client, r.Recorder, r.Restarter, conf, lgc, lgc, &lgc.Status)
+	if err != nil {
+		log.Warn("sync expiry of logConfig %s failed: %v", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+	if expired {
+		return ctrl.Result{RequeueAfter: expiry}, nil
+	}
+
+	d, err := syncSidecarStatus(ctx, r.Client, r.Recorder, r.Heartbeats, conf, lgc, lgc, &lgc.Status)
+	if err != nil {
+		log.Warn("sync status of logConfig %s failed: %v", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	if err := syncVolumeConfig(ctx, r.Client, r.Scheme, r.Recorder, conf, lgc, lgc, d); err != nil {
+		log.Warn("sync volume config of logConfig %s failed: %v", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	requeue, err := r.Restarter.Restart(ctx, lgc, d, false)
+	if err != nil {
+		log.Warn("restart workloads of logConfig %s failed: %v", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: minRequeue(requeue, expiry)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&logconfigv1beta1.LogConfig{}).
-		Owns(&logconfigv1beta1.Sink{}).
-		Owns(&logconfigv1beta1.Interceptor{}).
-		Complete(r)
+		Owns(&logconfigv1beta1.LogConfig{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(podToConfig(webhook.KindLogConfig))).
+		Watches(&source.Kind{Type: &logconfigv1beta1.Sink{}}, handler.EnqueueRequestsFromMapFunc(r.referencingLogConfigs)).
+		Watches(&source.Kind{Type: &logconfigv1beta1.Interceptor{}}, handler.EnqueueRequestsFromMapFunc(r.referencingLogConfigs)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.defaultSinkLogConfigs))
+	if r.LogClusters {
+		b = b.Watches(&source.Kind{Type: &operatorv1beta1.LogCluster{}}, handler.EnqueueRequestsFromMapFunc(r.logClusterLogConfigs))
+	}
+	if r.Heartbeats != nil {
+		b = b.Watches(&source.Channel{Source: r.Heartbeats.Events(webhook.KindLogConfig)}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(r)
+}
+
+// defaultSinkLogConfigs enqueues the LogConfigs without sink in the namespace, which use the default sink of namespace
+func (r *Reconciler) defaultSinkLogConfigs(obj client.Object) []reconcile.Request {
+	lgcList := &logconfigv1beta1.LogConfigList{}
+	if err := r.List(context.Background(), lgcList, client.InNamespace(obj.GetName())); err != nil {
+		log.Warn("list logConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, lgc := range lgcList.Items {
+		if lgc.Spec.Pipeline != nil && lgc.Spec.Pipeline.Sink == "" && lgc.Spec.Pipeline.SinkRef == "" {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: lgc.Namespace, Name: lgc.Name}})
+		}
+	}
+	return reqs
+}
+
+// logClusterLogConfigs enqueues the LogConfigs which send the logs to the LogCluster by the annotation
+func (r *Reconciler) logClusterLogConfigs(obj client.Object) []reconcile.Request {
+	lgcList := &logconfigv1beta1.LogConfigList{}
+	if err := r.List(context.Background(), lgcList); err != nil {
+		log.Warn("list logConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, lgc := range lgcList.Items {
+		if refersLogCluster(lgc.Annotations, lgc.Namespace, obj) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: lgc.Namespace, Name: lgc.Name}})
+		}
+	}
+	return reqs
+}
+
+// referencingLogConfigs enqueues the LogConfigs which refer to the Sink or Interceptor
+func (r *Reconciler) referencingLogConfigs(obj client.Object) []reconcile.Request {
+	lgcList := &logconfigv1beta1.LogConfigList{}
+	if err := r.List(context.Background(), lgcList); err != nil {
+		log.Warn("list logConfigs failed: %v", err)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, lgc := range lgcList.Items {
+		if refers(lgc.Spec.Pipeline, obj) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: lgc.Namespace, Name: lgc.Name}})
+		}
+	}
+	return reqs
+}
+
+// podToConfig enqueues the LogConfig/ClusterLogConfig injected to the pod
+func podToConfig(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ref, ok := obj.GetAnnotations()[webhook.ConfigAnnotationKey]
+		if !ok {
+			return nil
+		}
+		k, namespace, name, err := webhook.ParseConfigRef(ref)
+		if err != nil || k != kind {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+	}
+}
+
+// refers checks if the pipeline refers to the Sink or Interceptor
+func refers(pipeline *logconfigv1beta1.Pipeline, obj client.Object) bool {
+	if pipeline == nil {
+		return false
+	}
+
+	switch obj.(type) {
+	case *logconfigv1beta1.Sink:
+		// the pipeline without sink may use the Sink as default
+		return pipeline.Sink == "" && (pipeline.SinkRef == obj.GetName() || pipeline.SinkRef == "")
+	case *logconfigv1beta1.Interceptor:
+		for _, ref := range kubernetes.InterceptorRefs(pipeline.InterceptorRef) {
+			if ref == obj.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refersLogCluster checks if the annotations of LogConfig/ClusterLogConfig in the namespace refer to the LogCluster
+func refersLogCluster(annotations map[string]string, namespace string, obj client.Object) bool {
+	key, ok, err := webhook.LogClusterOf(annotations, namespace)
+	return err == nil && ok && key.Namespace == obj.GetNamespace() && key.Name == obj.GetName()
 }
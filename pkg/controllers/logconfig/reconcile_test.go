@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_refersLogCluster(t *testing.T) {
+	lc := &operatorv1beta1.LogCluster{}
+	lc.Namespace = "logging"
+	lc.Name = "aggregator"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		namespace   string
+		want        bool
+	}{
+		{name: "no annotation", namespace: "logging", want: false},
+		{name: "same namespace", annotations: map[string]string{webhook.LogClusterAnnotationKey: "aggregator"}, namespace: "logging", want: true},
+		{name: "other namespace", annotations: map[string]string{webhook.LogClusterAnnotationKey: "aggregator"}, namespace: "default", want: false},
+		{name: "namespaced name", annotations: map[string]string{webhook.LogClusterAnnotationKey: "logging/aggregator"}, namespace: "default", want: true},
+		{name: "cluster config", annotations: map[string]string{webhook.LogClusterAnnotationKey: "logging/aggregator"}, want: true},
+		{name: "invalid cluster config", annotations: map[string]string{webhook.LogClusterAnnotationKey: "aggregator"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, refersLogCluster(tt.annotations, tt.namespace, lc))
+		})
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// RestartPolicyAnnotationKey overrides restart.policy in the configuration for a LogConfig/ClusterLogConfig
+	RestartPolicyAnnotationKey = "sidecar.loggie.io/restart-policy"
+	// RestartPausedAnnotationKey stops restarting the workloads of a LogConfig/ClusterLogConfig
+	RestartPausedAnnotationKey = "sidecar.loggie.io/restart-paused"
+
+	// RestartedAtAnnotationKey is the same as `kubectl rollout restart`
+	RestartedAtAnnotationKey = "kubectl.kubernetes.io/restartedAt"
+	// RestartedForAnnotationKey is the config hash a workload was restarted for, so it would be restarted only once
+	RestartedForAnnotationKey = "sidecar.loggie.io/restarted-for"
+
+	EventReasonRestarted = "Restarted"
+)
+
+// Restarter restarts the workloads whose injected sidecar config is out of date.
+// It is shared by the reconcilers, so the rate limit applies to all restarts of the operator.
+// sidecar.restart is read from the Store for each restart, so the reloaded policy, pause and maintenance windows take effect at once.
+type Restarter struct {
+	client.Client
+	Recorder record.EventRecorder
+	Config   *config.Store
+
+	limiter *rate.Limiter
+}
+
+func NewRestarter(cli client.Client, recorder record.EventRecorder, store *config.Store) *Restarter {
+	conf := store.Load().Sidecar.Restart
+	return &Restarter{
+		Client:   cli,
+		Recorder: recorder,
+		Config:   store,
+		limiter:  rate.NewLimiter(rate.Every(conf.Interval), conf.Burst),
+	}
+}
+
+// Restart patches the pod template of the outdated workloads, like `kubectl rollout restart` does.
+// It returns the duration after which the rest workloads could be restarted, or 0 if all are done.
+// force restarts the workloads regardless of the restart policy, which is used to remove the expired configs,
+// but the pause and the maintenance windows are still respected.
+func (r *Restarter) Restart(ctx context.Context, obj client.Object, d *drift, force bool) (time.Duration, error) {
+	if r == nil || d == nil || len(d.Workloads) == 0 {
+		return 0, nil
+	}
+	conf := r.Config.Load().Sidecar.Restart
+
+	policy := conf.Policy
+	if p, ok := obj.GetAnnotations()[RestartPolicyAnnotationKey]; ok {
+		policy = p
+	}
+	if policy != config.RestartPolicyAuto && !force {
+		return 0, nil
+	}
+	if conf.Paused || obj.GetAnnotations()[RestartPausedAnnotationKey] == "true" {
+		log.Info("restarting workloads of %s is paused", client.ObjectKeyFromObject(obj))
+		return 0, nil
+	}
+
+	location, err := time.LoadLocation(conf.TimeZone)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().In(location)
+	if wait := untilMaintenanceWindow(conf.MaintenanceWindows, now); wait > 0 {
+		log.Info("%d workloads of %s would be restarted in the next maintenance window after %s", len(d.Workloads), client.ObjectKeyFromObject(obj), wait)
+		return wait, nil
+	}
+
+	// the limiter keeps the tokens taken, and follows the reloaded interval and burst
+	r.limiter.SetLimit(rate.Every(conf.Interval))
+	r.limiter.SetBurst(conf.Burst)
+	for _, w := range d.Workloads {
+		tmpl, workload := kubernetes.PodTemplateOf(w)
+		if workload == nil {
+			continue
+		}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: w.Namespace, Name: w.Name}, workload); err != nil {
+			if kerrors.IsNotFound(err) {
+				// deleted since its pods were listed, the rest are still to be restarted
+				continue
+			}
+			return 0, err
+		}
+		if tmpl.Annotations[RestartedForAnnotationKey] == d.Hash {
+			// restarted already, the rollout is in progress
+			continue
+		}
+
+		if !r.limiter.Allow() {
+			return conf.Interval, nil
+		}
+
+		patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		if tmpl.Annotations == nil {
+			tmpl.Annotations = make(map[string]string)
+		}
+		tmpl.Annotations[RestartedAtAnnotationKey] = now.Format(time.RFC3339)
+		tmpl.Annotations[RestartedForAnnotationKey] = d.Hash
+		if err := r.Patch(ctx, workload, patch); err != nil {
+			return 0, err
+		}
+
+		log.Info("restarted %s for the sidecar config of %s", w, client.ObjectKeyFromObject(obj))
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, EventReasonRestarted, "restarted %s for the out of date sidecar config", w)
+	}
+
+	return 0, nil
+}
+
+// untilMaintenanceWindow returns 0 if now is in any of the windows, otherwise the duration until the next window starts
+func untilMaintenanceWindow(windows []config.MaintenanceWindow, now time.Time) time.Duration {
+	if len(windows) == 0 {
+		return 0
+	}
+
+	var wait time.Duration
+	for _, w := range windows {
+		start, _ := time.Parse("15:04", w.Start)
+		end, _ := time.Parse("15:04", w.End)
+
+		// check the windows starting yesterday, today and in the next 7 days
+		for i := -1; i <= 7; i++ {
+			day := now.AddDate(0, 0, i)
+			if !windowOnDay(w, day.Weekday()) {
+				continue
+			}
+
+			s := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
+			e := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, now.Location())
+			if !e.After(s) {
+				e = e.AddDate(0, 0, 1)
+			}
+
+			if !now.Before(s) && now.Before(e) {
+				return 0
+			}
+			if s.After(now) && (wait == 0 || s.Sub(now) < wait) {
+				wait = s.Sub(now)
+			}
+		}
+	}
+	return wait
+}
+
+func windowOnDay(w config.MaintenanceWindow, day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if config.Weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+// patchRecorder reads from the objects, and records the patched ones instead of writing
+type patchRecorder struct {
+	client.Client
+	reader  *kubernetes.ObjectReader
+	patched []string
+}
+
+func (p *patchRecorder) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return p.reader.Get(ctx, key, obj)
+}
+
+func (p *patchRecorder) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	p.patched = append(p.patched, obj.GetNamespace()+"/"+obj.GetName())
+	return nil
+}
+
+func TestRestarter_Restart(t *testing.T) {
+	log.InitDefaultLogger()
+
+	tomcat := &appsv1.Deployment{}
+	tomcat.Namespace = "default"
+	tomcat.Name = "tomcat"
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme, tomcat)
+	assert.NoError(t, err)
+	cli := &patchRecorder{reader: reader}
+
+	store := config.NewStore(&config.Config{Sidecar: &config.Sidecar{
+		Restart: config.Restart{Policy: config.RestartPolicyAuto, Interval: time.Second, Burst: 10, TimeZone: "UTC"},
+	}})
+	r := NewRestarter(cli, record.NewFakeRecorder(10), store)
+
+	lgc := &logconfigv1beta1.LogConfig{}
+	lgc.Namespace = "default"
+	lgc.Name = "tomcat"
+	d := &drift{
+		Hash: "abc",
+		Workloads: []kubernetes.Workload{
+			{Kind: kubernetes.KindDeployment, Namespace: "default", Name: "deleted"},
+			{Kind: kubernetes.KindDeployment, Namespace: "default", Name: "tomcat"},
+		},
+	}
+	wait, err := r.Restart(context.Background(), lgc, d, false)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.Equal(t, []string{"default/tomcat"}, cli.patched)
+
+	// the reloaded configuration takes effect without recreating the restarter
+	store.Swap(&config.Config{Sidecar: &config.Sidecar{
+		Restart: config.Restart{Policy: config.RestartPolicyAuto, Paused: true, Interval: time.Second, Burst: 10, TimeZone: "UTC"},
+	}})
+	d.Hash = "def"
+	_, err = r.Restart(context.Background(), lgc, d, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/tomcat"}, cli.patched)
+
+	// the expired configs are removed regardless of the policy, but not while paused
+	_, err = r.Restart(context.Background(), lgc, d, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/tomcat"}, cli.patched)
+
+	store.Swap(&config.Config{Sidecar: &config.Sidecar{
+		Restart: config.Restart{Policy: config.RestartPolicyNever, Interval: time.Second, Burst: 10, TimeZone: "UTC"},
+	}})
+	_, err = r.Restart(context.Background(), lgc, d, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/tomcat"}, cli.patched)
+	_, err = r.Restart(context.Background(), lgc, d, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/tomcat", "default/tomcat"}, cli.patched)
+}
+
+func Test_untilMaintenanceWindow(t *testing.T) {
+	// 2023-03-04 is Saturday
+	at := func(day int, hour int, min int) time.Time {
+		return time.Date(2023, 3, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		windows []config.MaintenanceWindow
+		now     time.Time
+		want    time.Duration
+	}{
+		{
+			name: "no windows",
+			now:  at(4, 12, 0),
+			want: 0,
+		},
+		{
+			name:    "in daily window",
+			windows: []config.MaintenanceWindow{{Start: "02:00", End: "04:00"}},
+			now:     at(4, 3, 0),
+			want:    0,
+		},
+		{
+			name:    "before daily window",
+			windows: []config.MaintenanceWindow{{Start: "02:00", End: "04:00"}},
+			now:     at(4, 12, 0),
+			want:    14 * time.Hour,
+		},
+		{
+			name:    "in window across midnight",
+			windows: []config.MaintenanceWindow{{Days: []string{"Fri"}, Start: "22:00", End: "02:00"}},
+			now:     at(4, 1, 30),
+			want:    0,
+		},
+		{
+			name:    "next weekday window",
+			windows: []config.MaintenanceWindow{{Days: []string{"Mon", "Tue"}, Start: "01:00", End: "03:00"}},
+			now:     at(4, 1, 0),
+			want:    48 * time.Hour,
+		},
+		{
+			name: "nearest of windows",
+			windows: []config.MaintenanceWindow{
+				{Days: []string{"Sun"}, Start: "01:00", End: "03:00"},
+				{Days: []string{"Sat"}, Start: "20:00", End: "21:00"},
+			},
+			now:  at(4, 12, 0),
+			want: 8 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := untilMaintenanceWindow(tt.windows, tt.now)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
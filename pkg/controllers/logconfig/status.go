@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/heartbeat"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const (
+	EventReasonOutOfDate    = "OutOfDate"
+	EventReasonRenderFailed = "RenderFailed"
+	EventReasonStale        = "SidecarStale"
+
+	StateReady     = "Ready"
+	StateOutOfDate = "OutOfDate"
+	StateStale     = "Stale"
+	StateInvalid   = "Invalid"
+	StateUnused    = "Unused"
+
+	// maxStatusItems is the number of workloads or pods listed in the status
+	maxStatusItems = 5
+)
+
+// configStatus is reported in status.message.reason of a sidecar LogConfig/ClusterLogConfig, see summary.
+// It has the stable conditions only, so the status is not updated by every heartbeat or injection,
+// and the counters are in the metrics.
+type configStatus struct {
+	// Matched is the number of running pods to be injected and selected by the config
+	Matched int
+	// Drift is the injected pods, which is nil if the sidecar config cannot be rendered
+	Drift       *drift
+	RenderError error
+	// Failure is the last injection failure of the current generation, nil if none
+	Failure *webhook.Failure
+	// Heartbeat is nil if no sidecar has reported
+	Heartbeat *heartbeat.Summary
+}
+
+// summary starts with the state, so it fits in a printer column, such as:
+// "Ready: matched 3, injected 3; workloads: Deployment default/nginx"
+func (s *configStatus) summary() string {
+	var state string
+	counts := []string{fmt.Sprintf("matched %d", s.Matched)}
+	switch {
+	case s.Drift == nil:
+		state = StateInvalid
+	case s.Heartbeat != nil && len(s.Heartbeat.Stale) > 0:
+		state = StateStale
+	case s.Drift.Outdated > 0:
+		state = StateOutOfDate
+	case s.Matched == 0 && s.Drift.Injected == 0:
+		state = StateUnused
+	default:
+		state = StateReady
+	}
+	if d := s.Drift; d != nil {
+		injected := fmt.Sprintf("injected %d", d.Injected)
+		if d.Volume > 0 {
+			injected = fmt.Sprintf("%s (%d in volume mode)", injected, d.Volume)
+		}
+		counts = append(counts, injected)
+		if d.Outdated > 0 {
+			counts = append(counts, fmt.Sprintf("%d out of date", d.Outdated))
+		}
+	}
+	if h := s.Heartbeat; h != nil && len(h.Stale) > 0 {
+		counts = append(counts, fmt.Sprintf("%d stale", len(h.Stale)))
+	}
+
+	parts := []string{fmt.Sprintf("%s: %s", state, strings.Join(counts, ", "))}
+	if s.RenderError != nil {
+		parts = append(parts, fmt.Sprintf("render sidecar config failed: %v", s.RenderError))
+	}
+	if s.Drift != nil && len(s.Drift.InjectedWorkloads) > 0 {
+		var names []string
+		for _, w := range s.Drift.InjectedWorkloads {
+			names = append(names, w.String())
+		}
+		parts = append(parts, "workloads: "+truncatedList(names))
+	}
+	if s.Heartbeat != nil && len(s.Heartbeat.Stale) > 0 {
+		parts = append(parts, "stale pods: "+truncatedList(s.Heartbeat.Stale))
+	}
+	if s.Failure != nil {
+		parts = append(parts, fmt.Sprintf("last failure at %s: %s", s.Failure.Time.Format(time.RFC3339), s.Failure.Reason))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func truncatedList(items []string) string {
+	if len(items) <= maxStatusItems {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:maxStatusItems], ", "), len(items)-maxStatusItems)
+}
+
+// syncSidecarStatus checks how the LogConfig/ClusterLogConfig is used by the pods, whether the injected pods are running
+// its current config, and reports it in the status, metrics and events of obj. lgc is obj itself, or the LogConfig converted from it.
+// The drift is nil if the sidecar config cannot be rendered.
+func syncSidecarStatus(ctx context.Context, cli client.Client, recorder record.EventRecorder, heartbeats *heartbeat.Registry,
+	conf *config.Sidecar, obj client.Object, lgc *logconfigv1beta1.LogConfig, status *logconfigv1beta1.Status) (*drift, error) {
+	ref := webhook.ConfigRef(lgc)
+	kind, namespace, name, err := webhook.ParseConfigRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &configStatus{}
+	if failure, ok := webhook.FailureOf(obj); ok {
+		s.Failure = &failure
+	}
+	if summary, ok := heartbeats.Summary(ref); ok {
+		s.Heartbeat = &summary
+	}
+	s.Matched, err = matchedPods(ctx, cli, conf, lgc)
+	if err != nil {
+		return nil, err
+	}
+	s.Drift, s.RenderError = checkDrift(ctx, cli, conf, lgc)
+	if s.Drift != nil {
+		metrics.InjectedPods.WithLabelValues(kind, namespace, name).Set(float64(s.Drift.Injected))
+		metrics.OutdatedPods.WithLabelValues(kind, namespace, name).Set(float64(s.Drift.Outdated))
+	}
+
+	reason := s.summary()
+	d := s.Drift
+	if status.Message.Reason == reason && status.Message.ObservedGeneration == obj.GetGeneration() {
+		return d, nil
+	}
+
+	if d == nil {
+		recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonRenderFailed, "render sidecar config failed: %v", s.RenderError)
+	} else if d.Outdated > 0 {
+		log.Info("%s %s: %s, outdated workloads: %s", kind, client.ObjectKeyFromObject(obj), reason, d.workloads())
+		recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonOutOfDate, "%d/%d injected pods are out of date, workloads: %s",
+			d.Outdated, d.Injected, d.workloads())
+	}
+	if s.Heartbeat != nil && len(s.Heartbeat.Stale) > 0 {
+		recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonStale, "%d sidecars stopped reporting heartbeats, pods: %s",
+			len(s.Heartbeat.Stale), truncatedList(s.Heartbeat.Stale))
+	}
+	status.Message = logconfigv1beta1.Message{
+		Reason:             reason,
+		LastTransitionTime: time.Now().Format(time.RFC3339),
+		ObservedGeneration: obj.GetGeneration(),
+	}
+	return d, cli.Status().Update(ctx, obj)
+}
+
+// matchedPods returns the number of running pods to be injected and selected by the LogConfig, or the ClusterLogConfig
+// it is converted from, regardless of the other configs matching them
+func matchedPods(ctx context.Context, cli client.Client, conf *config.Sidecar, lgc *logconfigv1beta1.LogConfig) (int, error) {
+	podList := &corev1.PodList{}
+	if err := cli.List(ctx, podList, client.InNamespace(lgc.Namespace)); err != nil {
+		return 0, err
+	}
+
+	matcher := &webhook.PodMatcher{Reader: cli, Config: conf}
+	matched := 0
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if matcher.Matches(ctx, lgc, pod) {
+			matched++
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"github.com/loggie-io/operator/pkg/heartbeat"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_configStatus_summary(t *testing.T) {
+	nginx := kubernetes.Workload{Kind: "Deployment", Namespace: "default", Name: "nginx"}
+	web := kubernetes.Workload{Kind: "StatefulSet", Namespace: "default", Name: "web"}
+
+	tests := []struct {
+		name   string
+		status configStatus
+		want   string
+	}{
+		{
+			name:   "unused",
+			status: configStatus{Drift: &drift{}},
+			want:   "Unused: matched 0, injected 0",
+		},
+		{
+			name: "ready",
+			status: configStatus{
+				Matched: 3,
+				Drift:   &drift{Injected: 3, Volume: 1, InjectedWorkloads: []kubernetes.Workload{nginx, web}},
+			},
+			want: "Ready: matched 3, injected 3 (1 in volume mode); workloads: Deployment default/nginx, StatefulSet default/web",
+		},
+		{
+			name: "out of date with failures",
+			status: configStatus{
+				Matched: 3,
+				Drift:   &drift{Injected: 2, Outdated: 1, InjectedWorkloads: []kubernetes.Workload{nginx}},
+				Failure: &webhook.Failure{Reason: "invalid sources", Time: time.Date(2023, 3, 4, 1, 2, 3, 0, time.UTC)},
+			},
+			want: "OutOfDate: matched 3, injected 2, 1 out of date; workloads: Deployment default/nginx; " +
+				"last failure at 2023-03-04T01:02:03Z: invalid sources",
+		},
+		{
+			name: "stale",
+			status: configStatus{
+				Matched:   2,
+				Drift:     &drift{Injected: 2, InjectedWorkloads: []kubernetes.Workload{nginx}},
+				Heartbeat: &heartbeat.Summary{Reporting: 1, Stale: []string{"default/nginx-0"}, SinkFailed: 3},
+			},
+			want: "Stale: matched 2, injected 2, 1 stale; workloads: Deployment default/nginx; " +
+				"stale pods: default/nginx-0",
+		},
+		{
+			name:   "invalid",
+			status: configStatus{Matched: 1, RenderError: errors.New("sink not found")},
+			want:   "Invalid: matched 1; render sidecar config failed: sink not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.status.summary())
+		})
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logconfig
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const EventReasonVolumeConfig = "VolumeConfig"
+
+// syncVolumeConfig keeps the LogConfig/ClusterLogConfig collected by the Loggie DaemonSet for the pods injected in volume mode,
+// which is needed if obj is in volume mode or any pod is still injected in volume mode. It is owned by obj,
+// and has no inject annotation, so it is left to the Loggie DaemonSet. lgc is obj itself, or the LogConfig converted from it.
+func syncVolumeConfig(ctx context.Context, cli client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, conf *config.Sidecar,
+	obj client.Object, lgc *logconfigv1beta1.LogConfig, d *drift) error {
+	mode, err := webhook.InjectionMode(conf, obj, nil)
+	if err != nil {
+		return err
+	}
+
+	var volumeConfig client.Object
+	if lgc.Namespace == "" {
+		volumeConfig = &logconfigv1beta1.ClusterLogConfig{}
+	} else {
+		volumeConfig = &logconfigv1beta1.LogConfig{}
+	}
+	volumeConfig.SetNamespace(lgc.Namespace)
+	volumeConfig.SetName(webhook.VolumeConfigName(lgc.Name))
+
+	if mode != config.ModeVolume && (d == nil || d.Volume == 0) {
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(volumeConfig), volumeConfig); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !metav1.IsControlledBy(volumeConfig, obj) {
+			return nil
+		}
+		log.Info("delete %s of %s, which is not in volume mode", client.ObjectKeyFromObject(volumeConfig), webhook.ConfigRef(lgc))
+		return client.IgnoreNotFound(cli.Delete(ctx, volumeConfig))
+	}
+
+	spec, err := webhook.VolumeConfigSpec(conf, lgc, cli)
+	if err != nil {
+		return err
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, cli, volumeConfig, func() error {
+		if volumeConfig.GetResourceVersion() != "" && !metav1.IsControlledBy(volumeConfig, obj) {
+			return errors.Errorf("%s exists and is not owned by %s", client.ObjectKeyFromObject(volumeConfig), webhook.ConfigRef(lgc))
+		}
+		annotations := volumeConfig.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[webhook.VolumeOfAnnotationKey] = webhook.ConfigRef(lgc)
+		volumeConfig.SetAnnotations(annotations)
+
+		switch v := volumeConfig.(type) {
+		case *logconfigv1beta1.ClusterLogConfig:
+			v.Spec = *spec
+		case *logconfigv1beta1.LogConfig:
+			v.Spec = *spec
+		}
+		return controllerutil.SetControllerReference(obj, volumeConfig, scheme)
+	})
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("%s %s of %s for the Loggie DaemonSet", result, client.ObjectKeyFromObject(volumeConfig), webhook.ConfigRef(lgc))
+		recorder.Eventf(obj, corev1.EventTypeNormal, EventReasonVolumeConfig, "%s %s for the pods injected in volume mode", result, volumeConfig.GetName())
+	}
+	return nil
+}
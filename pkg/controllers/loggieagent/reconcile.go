@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	"context"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	EventReasonReconciled         = "Reconciled"
+	EventReasonReconcileFailed    = "ReconcileFailed"
+	EventReasonUnsupportedVersion = "UnsupportedVersion"
+)
+
+// Reconciler runs the Loggie DaemonSet of LoggieAgent, with its ConfigMap, ServiceAccount and RBAC.
+// The image older than MinVersion is refused, since it would collect the logs of the sidecars again.
+type Reconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=operator.loggie.io,resources=loggieagents,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operator.loggie.io,resources=loggieagents/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// the AgentRules granted to the agents
+//+kubebuilder:rbac:groups="",resources=pods;nodes;namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;replicasets;statefulsets;daemonsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=loggie.io,resources=logconfigs;clusterlogconfigs;sinks;interceptors;vms,verbs=get;list;watch
+//+kubebuilder:rbac:groups=loggie.io,resources=logconfigs/status;clusterlogconfigs/status;vms/status,verbs=get;update;patch
+
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log.Info("reconciling loggieAgent %s", req.Name)
+
+	agent := &operatorv1beta1.LoggieAgent{}
+	if err := r.Get(ctx, req.NamespacedName, agent); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if agent.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	status, err := r.reconcile(ctx, agent)
+	if err != nil {
+		log.Warn("reconcile loggieAgent %s failed: %v", req.Name, err)
+		r.Recorder.Eventf(agent, corev1.EventTypeWarning, EventReasonReconcileFailed, "reconcile failed: %v", err)
+		status = agent.Status.DeepCopy()
+		status.Message = err.Error()
+	}
+
+	status.ObservedGeneration = agent.Generation
+	if *status != agent.Status {
+		agent.Status = *status
+		if err := r.Status().Update(ctx, agent); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, agent *operatorv1beta1.LoggieAgent) (*operatorv1beta1.LoggieAgentStatus, error) {
+	status := &operatorv1beta1.LoggieAgentStatus{Namespace: agent.Status.Namespace}
+	v, ok := imageVersion(agent.Spec.Image)
+	if ok && v.less(MinVersion) {
+		status.Phase = operatorv1beta1.AgentPhaseInvalid
+		status.Message = fmt.Sprintf("image version %s is older than %s, which would collect the logs of sidecars again", v, MinVersion)
+		if agent.Status.Phase != operatorv1beta1.AgentPhaseInvalid {
+			r.Recorder.Event(agent, corev1.EventTypeWarning, EventReasonUnsupportedVersion, status.Message)
+		}
+		return status, nil
+	}
+
+	// the agents in the previous namespace are stopped before starting the new ones, which share the registry of nodes
+	if err := r.prune(ctx, agent); err != nil {
+		return nil, err
+	}
+	status.Namespace = agent.TargetNamespace()
+
+	meta := objectMeta(agent)
+	cm := &corev1.ConfigMap{ObjectMeta: meta}
+	if err := r.apply(ctx, agent, cm, func() { buildConfigMap(agent, cm) }); err != nil {
+		return nil, err
+	}
+	sa := &corev1.ServiceAccount{ObjectMeta: meta}
+	if err := r.apply(ctx, agent, sa, func() { buildServiceAccount(agent, sa) }); err != nil {
+		return nil, err
+	}
+	role := &rbacv1.ClusterRole{ObjectMeta: clusterObjectMeta(agent)}
+	if err := r.apply(ctx, agent, role, func() { buildClusterRole(agent, role) }); err != nil {
+		return nil, err
+	}
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: clusterObjectMeta(agent)}
+	if err := r.apply(ctx, agent, binding, func() { buildClusterRoleBinding(agent, binding) }); err != nil {
+		return nil, err
+	}
+	ds := &appsv1.DaemonSet{ObjectMeta: meta}
+	if err := r.apply(ctx, agent, ds, func() { buildDaemonSet(agent, ds) }); err != nil {
+		return nil, err
+	}
+
+	rolloutStatus(ds, status)
+	if !ok {
+		status.Message = fmt.Sprintf("%s, the version of image is unknown, which must be at least %s", status.Message, MinVersion)
+	}
+	return status, nil
+}
+
+// prune deletes the DaemonSet, ConfigMap and ServiceAccount of the LoggieAgent left in the namespace applied last
+func (r *Reconciler) prune(ctx context.Context, agent *operatorv1beta1.LoggieAgent) error {
+	namespace := agent.Status.Namespace
+	if namespace == "" || namespace == agent.TargetNamespace() {
+		return nil
+	}
+
+	key := client.ObjectKey{Namespace: namespace, Name: agent.Name}
+	for _, obj := range []client.Object{&appsv1.DaemonSet{}, &corev1.ConfigMap{}, &corev1.ServiceAccount{}} {
+		if err := r.Get(ctx, key, obj); err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(obj, agent) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+
+		kind := reflect.TypeOf(obj).Elem().Name()
+		log.Info("deleted %s %s/%s of loggieAgent %s", kind, namespace, agent.Name, agent.Name)
+		r.Recorder.Eventf(agent, corev1.EventTypeNormal, EventReasonReconciled, "deleted %s %s/%s", kind, namespace, agent.Name)
+	}
+	return nil
+}
+
+// apply creates or updates the object owned by the LoggieAgent
+func (r *Reconciler) apply(ctx context.Context, agent *operatorv1beta1.LoggieAgent, obj client.Object, build func()) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		build()
+		return controllerutil.SetControllerReference(agent, obj, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		kind := reflect.TypeOf(obj).Elem().Name()
+		log.Info("%s %s of loggieAgent %s", result, kind, agent.Name)
+		r.Recorder.Eventf(agent, corev1.EventTypeNormal, EventReasonReconciled, "%s %s %s", result, kind, obj.GetName())
+	}
+	return nil
+}
+
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&operatorv1beta1.LoggieAgent{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.ClusterRole{}).
+		Owns(&rbacv1.ClusterRoleBinding{}).
+		Complete(r)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+// deleteRecorder gets the objects from the reader, and records the deleted ones as kind/namespace/name
+type deleteRecorder struct {
+	client.Client
+	reader  client.Reader
+	deleted []string
+}
+
+func (d *deleteRecorder) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return d.reader.Get(ctx, key, obj)
+}
+
+func (d *deleteRecorder) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	d.deleted = append(d.deleted, reflect.TypeOf(obj).Elem().Name()+"/"+obj.GetNamespace()+"/"+obj.GetName())
+	return nil
+}
+
+func TestReconciler_prune(t *testing.T) {
+	log.InitDefaultLogger()
+
+	agent := &operatorv1beta1.LoggieAgent{}
+	agent.Name = "agent"
+	agent.UID = "8d3b7c52"
+	owner := []metav1.OwnerReference{{Name: agent.Name, UID: agent.UID, Controller: pointer.Bool(true)}}
+
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "loggie", Name: "agent", OwnerReferences: owner}}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "loggie", Name: "agent", OwnerReferences: owner}}
+	// the ServiceAccount not controlled by the agent is kept
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "loggie", Name: "agent"}}
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme, ds, cm, sa)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		namespace   string
+		applied     string
+		wantDeleted []string
+	}{
+		{name: "never applied", namespace: "logging", applied: ""},
+		{name: "namespace unchanged", namespace: "", applied: "loggie"},
+		{name: "namespace changed", namespace: "logging", applied: "loggie", wantDeleted: []string{"DaemonSet/loggie/agent", "ConfigMap/loggie/agent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &deleteRecorder{reader: reader}
+			r := &Reconciler{Client: cli, Recorder: record.NewFakeRecorder(10)}
+			agent.Spec.Namespace = tt.namespace
+			agent.Status.Namespace = tt.applied
+			assert.NoError(t, r.prune(context.Background(), agent))
+			assert.Equal(t, tt.wantDeleted, cli.deleted)
+		})
+	}
+}
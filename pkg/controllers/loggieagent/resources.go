@@ -0,0 +1,236 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+)
+
+const (
+	// ConfigHashAnnotationKey of the pod template rolls the agents when the config changes
+	ConfigHashAnnotationKey = "operator.loggie.io/config-hash"
+
+	ContainerName      = "loggie"
+	ConfigVolumeName   = "loggie-config"
+	RegistryVolumeName = "loggie-registry"
+	ConfigPath         = "/opt/loggie"
+	RegistryPath       = "/data"
+	SystemConfigFile   = "loggie.yml"
+	PipelinesFile      = "pipelines.yml"
+)
+
+var defaultHostPaths = []string{"/var/log/pods", "/var/lib/kubelet/pods"}
+
+func labels(agent *operatorv1beta1.LoggieAgent) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "loggie",
+		"app.kubernetes.io/instance":   agent.Name,
+		"app.kubernetes.io/component":  "agent",
+		"app.kubernetes.io/managed-by": "loggie-operator",
+	}
+}
+
+// objectMeta is the metadata of the objects in the namespace of the DaemonSet
+func objectMeta(agent *operatorv1beta1.LoggieAgent) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: agent.TargetNamespace(), Name: agent.Name}
+}
+
+// clusterObjectMeta is the metadata of the cluster scoped objects, which are prefixed to avoid conflicts
+func clusterObjectMeta(agent *operatorv1beta1.LoggieAgent) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: "loggie-agent-" + agent.Name}
+}
+
+func buildConfigMap(agent *operatorv1beta1.LoggieAgent, cm *corev1.ConfigMap) {
+	cm.Labels = labels(agent)
+	cm.Data = map[string]string{
+		SystemConfigFile: agent.Spec.SystemConfig,
+		PipelinesFile:    agent.Spec.Pipelines,
+	}
+}
+
+func buildServiceAccount(agent *operatorv1beta1.LoggieAgent, sa *corev1.ServiceAccount) {
+	sa.Labels = labels(agent)
+}
+
+// AgentRules allow the agent to discover the pods and LogConfigs, and to report the events.
+// The operator must hold all of them to grant them, or the API server rejects the ClusterRole as an escalation,
+// so they are declared by the markers of the Reconciler as well.
+var AgentRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods", "nodes", "namespaces"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments", "replicasets", "statefulsets", "daemonsets"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"batch"},
+		Resources: []string{"jobs", "cronjobs"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"loggie.io"},
+		Resources: []string{"logconfigs", "clusterlogconfigs", "sinks", "interceptors", "vms"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"loggie.io"},
+		Resources: []string{"logconfigs/status", "clusterlogconfigs/status", "vms/status"},
+		Verbs:     []string{"get", "update", "patch"},
+	},
+}
+
+func buildClusterRole(agent *operatorv1beta1.LoggieAgent, role *rbacv1.ClusterRole) {
+	role.Labels = labels(agent)
+	role.Rules = make([]rbacv1.PolicyRule, len(AgentRules))
+	for i := range AgentRules {
+		AgentRules[i].DeepCopyInto(&role.Rules[i])
+	}
+}
+
+func buildClusterRoleBinding(agent *operatorv1beta1.LoggieAgent, binding *rbacv1.ClusterRoleBinding) {
+	binding.Labels = labels(agent)
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "ClusterRole",
+		Name:     clusterObjectMeta(agent).Name,
+	}
+	binding.Subjects = []rbacv1.Subject{
+		{Kind: rbacv1.ServiceAccountKind, Namespace: agent.TargetNamespace(), Name: agent.Name},
+	}
+}
+
+// buildDaemonSet runs Loggie on every node selected, with the host paths and registry mounted
+func buildDaemonSet(agent *operatorv1beta1.LoggieAgent, ds *appsv1.DaemonSet) {
+	ds.Labels = labels(agent)
+	if ds.Spec.Selector == nil {
+		ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels(agent)}
+	}
+
+	tmpl := &ds.Spec.Template
+	tmpl.Labels = labels(agent)
+	tmpl.Annotations = map[string]string{ConfigHashAnnotationKey: configHash(agent)}
+	tmpl.Spec.ServiceAccountName = agent.Name
+	tmpl.Spec.NodeSelector = agent.Spec.NodeSelector
+	tmpl.Spec.Tolerations = agent.Spec.Tolerations
+
+	registry := agent.Spec.RegistryPath
+	if registry == "" {
+		registry = "/data/loggie-" + agent.Name
+	}
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	tmpl.Spec.Volumes = []corev1.Volume{
+		{
+			Name: ConfigVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: agent.Name}},
+			},
+		},
+		{
+			Name: RegistryVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: registry, Type: &hostPathType},
+			},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: ConfigVolumeName, MountPath: ConfigPath},
+		{Name: RegistryVolumeName, MountPath: RegistryPath},
+	}
+
+	hostPaths := agent.Spec.HostPaths
+	if len(hostPaths) == 0 {
+		hostPaths = defaultHostPaths
+	}
+	for i, p := range hostPaths {
+		name := "host-path-" + strconv.Itoa(i)
+		tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: p}},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: name, MountPath: p, ReadOnly: true})
+	}
+
+	tmpl.Spec.Containers = []corev1.Container{
+		{
+			Name:  ContainerName,
+			Image: agent.Spec.Image,
+			Args: []string{
+				"-meta.nodeName=$(HOST_NAME)",
+				fmt.Sprintf("-config.system=%s/%s", ConfigPath, SystemConfigFile),
+				fmt.Sprintf("-config.pipeline=%s/%s", ConfigPath, PipelinesFile),
+			},
+			Env: []corev1.EnvVar{
+				{
+					Name: "HOST_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "spec.nodeName"},
+					},
+				},
+			},
+			Resources:    agent.Spec.Resources,
+			VolumeMounts: mounts,
+		},
+	}
+}
+
+func configHash(agent *operatorv1beta1.LoggieAgent) string {
+	h := sha256.New()
+	for _, s := range []string{agent.Spec.SystemConfig, agent.Spec.Pipelines} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// rolloutStatus summarizes the rollout of the DaemonSet
+func rolloutStatus(ds *appsv1.DaemonSet, status *operatorv1beta1.LoggieAgentStatus) {
+	status.DesiredNumberScheduled = ds.Status.DesiredNumberScheduled
+	status.UpdatedNumberScheduled = ds.Status.UpdatedNumberScheduled
+	status.NumberReady = ds.Status.NumberReady
+
+	switch {
+	case ds.Status.ObservedGeneration < ds.Generation:
+		status.Phase = operatorv1beta1.AgentPhaseProgressing
+		status.Message = "waiting for the DaemonSet to be observed"
+	case ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled:
+		status.Phase = operatorv1beta1.AgentPhaseProgressing
+		status.Message = fmt.Sprintf("%d of %d agents are updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+	case ds.Status.NumberReady < ds.Status.DesiredNumberScheduled:
+		status.Phase = operatorv1beta1.AgentPhaseProgressing
+		status.Message = fmt.Sprintf("%d of %d agents are ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	default:
+		status.Phase = operatorv1beta1.AgentPhaseReady
+		status.Message = fmt.Sprintf("%d agents are ready", ds.Status.NumberReady)
+	}
+}
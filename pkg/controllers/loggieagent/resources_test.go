@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"testing"
+)
+
+func Test_rolloutStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    appsv1.DaemonSetStatus
+		wantPhase string
+	}{
+		{
+			name:      "not observed",
+			status:    appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+			wantPhase: operatorv1beta1.AgentPhaseProgressing,
+		},
+		{
+			name:      "updating",
+			status:    appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberReady: 3},
+			wantPhase: operatorv1beta1.AgentPhaseProgressing,
+		},
+		{
+			name:      "ready",
+			status:    appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+			wantPhase: operatorv1beta1.AgentPhaseReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &appsv1.DaemonSet{Status: tt.status}
+			ds.Generation = 2
+			status := &operatorv1beta1.LoggieAgentStatus{}
+			rolloutStatus(ds, status)
+			assert.Equal(t, tt.wantPhase, status.Phase)
+			assert.Equal(t, tt.status.NumberReady, status.NumberReady)
+		})
+	}
+}
+
+func Test_buildDaemonSet(t *testing.T) {
+	agent := &operatorv1beta1.LoggieAgent{}
+	agent.Name = "agent"
+	agent.Spec.Image = "loggieio/loggie:v1.5.0"
+
+	ds := &appsv1.DaemonSet{}
+	buildDaemonSet(agent, ds)
+	c := ds.Spec.Template.Spec.Containers[0]
+	var paths []string
+	for _, m := range c.VolumeMounts {
+		paths = append(paths, m.MountPath)
+	}
+	assert.Equal(t, []string{ConfigPath, RegistryPath, "/var/log/pods", "/var/lib/kubelet/pods"}, paths)
+	assert.Equal(t, "/data/loggie-agent", ds.Spec.Template.Spec.Volumes[1].HostPath.Path)
+	assert.Equal(t, "agent", ds.Spec.Template.Spec.ServiceAccountName)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MinVersion of Loggie ignores the LogConfigs/ClusterLogConfigs with annotation sidecar.loggie.io/inject,
+// so the agent does not collect the logs of the sidecars again
+var MinVersion = version{1, 5, 0}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+type version [3]int
+
+func (v version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v version) less(o version) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+// imageVersion parses the version in the tag of the image, and returns false if the tag is not a version, such as main or a digest
+func imageVersion(image string) (version, bool) {
+	if strings.Contains(image, "@") {
+		return version{}, false
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return version{}, false
+	}
+
+	m := versionPattern.FindStringSubmatch(image[i+1:])
+	if m == nil {
+		return version{}, false
+	}
+	var v version
+	for j := range v {
+		v[j], _ = strconv.Atoi(m[j+1])
+	}
+	return v, true
+}
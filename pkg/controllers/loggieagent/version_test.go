@@ -0,0 +1,47 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggieagent
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_imageVersion(t *testing.T) {
+	tests := []struct {
+		image  string
+		want   version
+		wantOk bool
+	}{
+		{image: "loggieio/loggie:v1.5.0", want: version{1, 5, 0}, wantOk: true},
+		{image: "registry:5000/loggie:v1.4", want: version{1, 4, 0}, wantOk: true},
+		{image: "loggieio/loggie:1.10.2-amd64", want: version{1, 10, 2}, wantOk: true},
+		{image: "loggieio/loggie:main"},
+		{image: "registry:5000/loggie"},
+		{image: "loggieio/loggie@sha256:0123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, ok := imageVersion(tt.image)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.True(t, version{1, 4, 9}.less(MinVersion))
+	assert.False(t, version{1, 10, 0}.less(MinVersion))
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reload
+
+import (
+	"bytes"
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	EventReasonConfigReloaded = "ConfigReloaded"
+	EventReasonInvalidConfig  = "InvalidConfig"
+)
+
+// Reloader watches the configuration file, or the key of a ConfigMap, and activates it in the Store when it changes.
+// An invalid configuration is rejected and reported, and the last valid one keeps active.
+type Reloader struct {
+	Reader   client.Reader
+	Recorder record.EventRecorder
+
+	// Path is the configuration file, which is read if ConfigMap is not set
+	Path      string
+	ConfigMap types.NamespacedName
+	Key       string
+	// Interval is the period to check the configuration
+	Interval time.Duration
+	// Pod is the pod of the operator, which receives the events in file mode
+	Pod types.NamespacedName
+
+	Store *config.Store
+
+	last []byte
+}
+
+// Load reads and activates the configuration for the first time
+func (r *Reloader) Load(ctx context.Context) (*config.Config, error) {
+	raw, _, err := r.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := config.Parse(raw)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid config:\n%s", raw)
+	}
+
+	r.last = raw
+	r.Store = config.NewStore(conf)
+	metrics.ConfigGeneration.Set(float64(r.Store.Generation()))
+	return conf, nil
+}
+
+func (r *Reloader) Start(ctx context.Context) error {
+	log.Info("watching the configuration every %s", r.Interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.reload(ctx); err != nil {
+			log.Warn("reload configuration failed: %v", err)
+		}
+	}, r.Interval)
+	return nil
+}
+
+// Check is the readiness check, which passes once the configuration is parsed and activated
+func (r *Reloader) Check(_ *http.Request) error {
+	if r.Store == nil {
+		return errors.New("configuration is not loaded")
+	}
+	return nil
+}
+
+// NeedLeaderElection is false, since every replica serves the webhook with its own configuration
+func (r *Reloader) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *Reloader) reload(ctx context.Context) error {
+	raw, obj, err := r.read(ctx)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(raw, r.last) {
+		return nil
+	}
+	r.last = raw
+
+	conf, err := config.Parse(raw)
+	if err != nil {
+		metrics.ConfigReloadFailures.Inc()
+		log.Error("rejected the invalid configuration, keeping generation %d: %v", r.Store.Generation(), err)
+		r.event(obj, corev1.EventTypeWarning, EventReasonInvalidConfig, "rejected the invalid configuration, keeping generation %d: %v", r.Store.Generation(), err)
+		return nil
+	}
+
+	for _, field := range restartRequired(r.Store.Load(), conf) {
+		log.Warn("the change of %s takes effect after the operator restarts", field)
+	}
+	generation := r.Store.Swap(conf)
+	metrics.ConfigGeneration.Set(float64(generation))
+	log.Info("configuration generation %d is active", generation)
+	r.event(obj, corev1.EventTypeNormal, EventReasonConfigReloaded, "configuration generation %d is active", generation)
+	return nil
+}
+
+// read returns the raw configuration, and the object to receive the events
+func (r *Reloader) read(ctx context.Context) ([]byte, runtime.Object, error) {
+	if r.ConfigMap.Name == "" {
+		raw, err := os.ReadFile(r.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r.Pod.Name == "" {
+			return raw, nil, nil
+		}
+		return raw, &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: r.Pod.Namespace, Name: r.Pod.Name}, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Reader.Get(ctx, r.ConfigMap, cm); err != nil {
+		return nil, nil, err
+	}
+	raw, ok := cm.Data[r.Key]
+	if !ok {
+		return nil, nil, errors.Errorf("key %s not found in ConfigMap %s", r.Key, r.ConfigMap)
+	}
+	return []byte(raw), cm, nil
+}
+
+func (r *Reloader) event(obj runtime.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if obj == nil || r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+// restartRequired returns the fields changed which are only read when the operator starts
+func restartRequired(old *config.Config, new *config.Config) []string {
+	if old.Sidecar == nil || new.Sidecar == nil {
+		if (old.Sidecar == nil) != (new.Sidecar == nil) {
+			return []string{"sidecar"}
+		}
+		return nil
+	}
+
+	var fields []string
+	if old.Sidecar.Enabled != new.Sidecar.Enabled {
+		fields = append(fields, "sidecar.enabled")
+	}
+	if old.Sidecar.Ephemeral.Enabled != new.Sidecar.Ephemeral.Enabled {
+		fields = append(fields, "sidecar.ephemeral.enabled")
+	}
+	return fields
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reload
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"testing"
+)
+
+func TestReloader_reload(t *testing.T) {
+	log.InitDefaultLogger()
+
+	configOf := func(image string) string {
+		return "sidecar:\n  enabled: true\n  image: " + image + "\n  systemConfig: |\n    loggie: {}\n"
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.Namespace = "loggie"
+	cm.Name = "operator"
+	cm.Data = map[string]string{"config.yml": configOf("loggie:v1")}
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme, cm)
+	assert.NoError(t, err)
+
+	recorder := record.NewFakeRecorder(10)
+	r := &Reloader{
+		Reader:    reader,
+		Recorder:  recorder,
+		ConfigMap: types.NamespacedName{Namespace: "loggie", Name: "operator"},
+		Key:       "config.yml",
+	}
+	conf, err := r.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "loggie:v1", conf.Sidecar.Image)
+	assert.Equal(t, int64(1), r.Store.Generation())
+
+	tests := []struct {
+		name           string
+		content        string
+		wantImage      string
+		wantGeneration int64
+		wantEvent      string
+	}{
+		{
+			name:           "unchanged",
+			content:        configOf("loggie:v1"),
+			wantImage:      "loggie:v1",
+			wantGeneration: 1,
+		},
+		{
+			name:           "reloaded",
+			content:        configOf("loggie:v2"),
+			wantImage:      "loggie:v2",
+			wantGeneration: 2,
+			wantEvent:      "Normal ConfigReloaded configuration generation 2 is active",
+		},
+		{
+			name:           "invalid",
+			content:        "sidecar:\n  enabled: true\n",
+			wantImage:      "loggie:v2",
+			wantGeneration: 2,
+			wantEvent:      "Warning InvalidConfig rejected the invalid configuration, keeping generation 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm.Data["config.yml"] = tt.content
+			assert.NoError(t, r.reload(context.Background()))
+			assert.Equal(t, tt.wantImage, r.Store.Load().Sidecar.Image)
+			assert.Equal(t, tt.wantGeneration, r.Store.Generation())
+
+			select {
+			case e := <-recorder.Events:
+				assert.Contains(t, e, tt.wantEvent)
+				assert.NotEmpty(t, tt.wantEvent)
+			default:
+				assert.Empty(t, tt.wantEvent)
+			}
+		})
+	}
+}
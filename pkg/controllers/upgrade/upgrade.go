@@ -0,0 +1,366 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/controllers/logconfig"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// StateAnnotationKey records the sidecar upgrade of the workload, see State
+	StateAnnotationKey = "sidecar.loggie.io/upgrade"
+
+	PhaseUpgrading  = "Upgrading"
+	PhaseSucceeded  = "Succeeded"
+	PhaseRolledBack = "RolledBack"
+
+	EventReasonUpgrading  = "SidecarUpgrading"
+	EventReasonUpgraded   = "SidecarUpgraded"
+	EventReasonRolledBack = "SidecarRolledBack"
+
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// State is the upgrade of a workload from one sidecar image to another
+type State struct {
+	Phase     string `json:"phase"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	StartedAt string `json:"startedAt"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Upgrader reports the sidecar images running in the cluster, and upgrades the workloads to the image in the configuration,
+// or the canary image for the canary workloads, in batches if enabled. A workload whose new sidecars are not ready in time is rolled back by pinning the previous image,
+// and no more workloads would be upgraded to the same image.
+type Upgrader struct {
+	client.Client
+	Recorder record.EventRecorder
+	Config   *config.Store
+
+	spread string
+}
+
+// workload is a workload with its injected pods
+type workload struct {
+	kubernetes.Workload
+	pods []*corev1.Pod
+
+	obj    client.Object
+	tmpl   *corev1.PodTemplateSpec
+	state  *State
+	target string
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+
+// Start syncs the upgrade every upgrade.interval, which is read from the Store after each sync to follow the reloaded configuration
+func (u *Upgrader) Start(ctx context.Context) error {
+	for {
+		if err := u.sync(ctx); err != nil {
+			log.Warn("sync sidecar upgrade failed: %v", err)
+		}
+
+		timer := time.NewTimer(u.Config.Load().Sidecar.Upgrade.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (u *Upgrader) sync(ctx context.Context) error {
+	podList := &corev1.PodList{}
+	if err := u.List(ctx, podList); err != nil {
+		return err
+	}
+
+	images := make(map[string]int)
+	index := make(map[kubernetes.Workload]*workload)
+	var workloads []*workload
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		image := webhook.InjectedImage(pod)
+		if image == "" || pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		images[image]++
+
+		w := kubernetes.WorkloadOf(pod)
+		if index[w] == nil {
+			index[w] = &workload{Workload: w}
+			workloads = append(workloads, index[w])
+		}
+		index[w].pods = append(index[w].pods, pod)
+	}
+	u.reportSpread(images)
+
+	conf := u.Config.Load().Sidecar
+	if !conf.Upgrade.Enabled {
+		return nil
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return workloads[i].String() < workloads[j].String()
+	})
+	return u.upgrade(ctx, conf, workloads)
+}
+
+func (u *Upgrader) reportSpread(images map[string]int) {
+	metrics.SidecarPods.Reset()
+	var spread []string
+	for image, n := range images {
+		metrics.SidecarPods.WithLabelValues(image).Set(float64(n))
+		spread = append(spread, fmt.Sprintf("%s(%d)", image, n))
+	}
+	sort.Strings(spread)
+
+	s := strings.Join(spread, ", ")
+	if s != u.spread {
+		u.spread = s
+		log.Info("sidecars are running %d images: %s", len(images), s)
+	}
+}
+
+func (u *Upgrader) upgrade(ctx context.Context, conf *config.Sidecar, workloads []*workload) error {
+	now := time.Now()
+
+	var inProgress int
+	var candidates []*workload
+	// rolledBack are the workloads rolled back from the target images
+	rolledBack := make(map[string][]string)
+	for _, w := range workloads {
+		w.tmpl, w.obj = kubernetes.PodTemplateOf(w.Workload)
+		if w.obj == nil {
+			continue
+		}
+		if err := u.Get(ctx, types.NamespacedName{Namespace: w.Namespace, Name: w.Name}, w.obj); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		w.state = stateOf(w.obj)
+		// the canary workloads are upgraded to the canary image
+		w.target = webhook.ImageOf(conf, w.pods[0])
+
+		if w.state != nil && w.state.To == w.target {
+			switch w.state.Phase {
+			case PhaseUpgrading:
+				done, failure := progress(w.pods, w.state, conf.Upgrade.HealthTimeout, now)
+				var err error
+				switch {
+				case failure != "":
+					err = u.rollback(ctx, w, failure)
+				case done:
+					err = u.succeed(ctx, w)
+				default:
+					inProgress++
+				}
+				if err != nil {
+					return err
+				}
+				continue
+
+			case PhaseRolledBack:
+				rolledBack[w.target] = append(rolledBack[w.target], w.String())
+				continue
+			}
+		}
+
+		if !upToDate(w.pods, w.target) {
+			candidates = append(candidates, w)
+		}
+	}
+
+	for _, w := range candidates {
+		if names, ok := rolledBack[w.target]; ok {
+			log.Warn("upgrading sidecars of %s to %s is halted, since %s are rolled back", w, w.target, strings.Join(names, ", "))
+			continue
+		}
+		if inProgress >= conf.Upgrade.BatchSize {
+			log.Info("%d workloads are upgrading sidecars, %s is waiting", inProgress, w)
+			continue
+		}
+		if err := u.start(ctx, w, now); err != nil {
+			return err
+		}
+		inProgress++
+	}
+	return nil
+}
+
+// start restarts the workload without the pinned image, so its pods would be injected with the image in the configuration
+func (u *Upgrader) start(ctx context.Context, w *workload, now time.Time) error {
+	state := &State{
+		Phase:     PhaseUpgrading,
+		From:      currentImage(w.pods, w.target),
+		To:        w.target,
+		StartedAt: now.Format(time.RFC3339),
+	}
+
+	if err := u.patch(ctx, w, state, func(annotations map[string]string) {
+		annotations[logconfig.RestartedAtAnnotationKey] = now.Format(time.RFC3339)
+		delete(annotations, webhook.PinnedImageAnnotationKey)
+	}); err != nil {
+		return err
+	}
+
+	log.Info("upgrading sidecars of %s from %s to %s", w, state.From, state.To)
+	u.Recorder.Eventf(w.obj, corev1.EventTypeNormal, EventReasonUpgrading, "upgrading sidecars from %s to %s", state.From, state.To)
+	return nil
+}
+
+func (u *Upgrader) succeed(ctx context.Context, w *workload) error {
+	state := *w.state
+	state.Phase = PhaseSucceeded
+	if err := u.patch(ctx, w, &state, nil); err != nil {
+		return err
+	}
+
+	log.Info("upgraded sidecars of %s to %s", w, state.To)
+	u.Recorder.Eventf(w.obj, corev1.EventTypeNormal, EventReasonUpgraded, "upgraded sidecars to %s", state.To)
+	return nil
+}
+
+// rollback restarts the workload with the image pinned to the one before upgrading
+func (u *Upgrader) rollback(ctx context.Context, w *workload, failure string) error {
+	state := *w.state
+	state.Phase = PhaseRolledBack
+	state.Message = failure
+	if err := u.patch(ctx, w, &state, func(annotations map[string]string) {
+		annotations[logconfig.RestartedAtAnnotationKey] = time.Now().Format(time.RFC3339)
+		annotations[webhook.PinnedImageAnnotationKey] = state.From
+	}); err != nil {
+		return err
+	}
+
+	log.Warn("rolled back sidecars of %s to %s: %s", w, state.From, failure)
+	u.Recorder.Eventf(w.obj, corev1.EventTypeWarning, EventReasonRolledBack, "rolled back sidecars to %s: %s", state.From, failure)
+	return nil
+}
+
+// patch records the state in the workload, and changes the annotations of the pod template if mutate is not nil
+func (u *Upgrader) patch(ctx context.Context, w *workload, state *State, mutate func(annotations map[string]string)) error {
+	patch := client.MergeFrom(w.obj.DeepCopyObject().(client.Object))
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	annotations := w.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[StateAnnotationKey] = string(data)
+	w.obj.SetAnnotations(annotations)
+
+	if mutate != nil {
+		if w.tmpl.Annotations == nil {
+			w.tmpl.Annotations = make(map[string]string)
+		}
+		mutate(w.tmpl.Annotations)
+	}
+
+	return u.Patch(ctx, w.obj, patch)
+}
+
+func stateOf(obj client.Object) *State {
+	data, ok := obj.GetAnnotations()[StateAnnotationKey]
+	if !ok {
+		return nil
+	}
+	state := &State{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		log.Warn("invalid annotation %s of %s: %v", StateAnnotationKey, client.ObjectKeyFromObject(obj), err)
+		return nil
+	}
+	return state
+}
+
+// progress checks the pods of an upgrading workload. It is done when all the pods are running the new sidecars and ready,
+// and fails when any new sidecar is crashing, or they are not ready before timeout.
+func progress(pods []*corev1.Pod, state *State, timeout time.Duration, now time.Time) (done bool, failure string) {
+	done = true
+	for _, pod := range pods {
+		if webhook.InjectedImage(pod) != state.To {
+			done = false
+			continue
+		}
+
+		cs := webhook.InjectedSidecarStatus(pod)
+		if cs != nil && cs.State.Waiting != nil && cs.State.Waiting.Reason == reasonCrashLoopBackOff {
+			return false, fmt.Sprintf("sidecar of pod %s is in %s", pod.Name, reasonCrashLoopBackOff)
+		}
+		if cs == nil || !cs.Ready {
+			done = false
+		}
+	}
+	if done {
+		return true, ""
+	}
+
+	startedAt, err := time.Parse(time.RFC3339, state.StartedAt)
+	if err == nil && now.Sub(startedAt) > timeout {
+		return false, fmt.Sprintf("new sidecars are not ready in %s", timeout)
+	}
+	return false, ""
+}
+
+func upToDate(pods []*corev1.Pod, image string) bool {
+	for _, pod := range pods {
+		if webhook.InjectedImage(pod) != image {
+			return false
+		}
+	}
+	return true
+}
+
+// currentImage returns the image run by most of the pods other than target
+func currentImage(pods []*corev1.Pod, target string) string {
+	count := make(map[string]int)
+	var current string
+	for _, pod := range pods {
+		image := webhook.InjectedImage(pod)
+		if image == target {
+			continue
+		}
+		count[image]++
+		if count[image] > count[current] || (count[image] == count[current] && image < current) {
+			current = image
+		}
+	}
+	return current
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func Test_progress(t *testing.T) {
+	pod := func(name string, image string, ready bool, waiting string) *corev1.Pod {
+		cs := corev1.ContainerStatus{Name: webhook.SidecarContainerName, Ready: ready}
+		if waiting != "" {
+			cs.State.Waiting = &corev1.ContainerStateWaiting{Reason: waiting}
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					webhook.ImageAnnotationKey:          image,
+					webhook.InjectedStatusAnnotationKey: `{"containers":["loggie"]}`,
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: webhook.SidecarContainerName, Image: image}},
+			},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{cs}},
+		}
+	}
+
+	startedAt := time.Date(2023, 3, 4, 12, 0, 0, 0, time.UTC)
+	state := &State{Phase: PhaseUpgrading, From: "loggie:v1", To: "loggie:v2", StartedAt: startedAt.Format(time.RFC3339)}
+
+	tests := []struct {
+		name        string
+		pods        []*corev1.Pod
+		now         time.Time
+		wantDone    bool
+		wantFailure string
+	}{
+		{
+			name:     "all ready",
+			pods:     []*corev1.Pod{pod("a", "loggie:v2", true, ""), pod("b", "loggie:v2", true, "")},
+			now:      startedAt.Add(time.Minute),
+			wantDone: true,
+		},
+		{
+			name: "rolling",
+			pods: []*corev1.Pod{pod("a", "loggie:v2", true, ""), pod("b", "loggie:v1", true, "")},
+			now:  startedAt.Add(time.Minute),
+		},
+		{
+			name:        "crashing",
+			pods:        []*corev1.Pod{pod("a", "loggie:v2", false, reasonCrashLoopBackOff), pod("b", "loggie:v1", true, "")},
+			now:         startedAt.Add(time.Minute),
+			wantFailure: "sidecar of pod a is in CrashLoopBackOff",
+		},
+		{
+			name:        "timeout",
+			pods:        []*corev1.Pod{pod("a", "loggie:v2", false, ""), pod("b", "loggie:v1", true, "")},
+			now:         startedAt.Add(11 * time.Minute),
+			wantFailure: "new sidecars are not ready in 10m0s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, failure := progress(tt.pods, state, 10*time.Minute, tt.now)
+			assert.Equal(t, tt.wantDone, done)
+			assert.Equal(t, tt.wantFailure, failure)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CertName = "tls.crt"
+	KeyName  = "tls.key"
+)
+
+// CacheSynced returns a checker which passes once the informers of the objects are synced.
+// The informers are created here before the manager starts, so the checker never blocks on the cache.
+// The objects whose CRDs are not installed are skipped.
+func CacheSynced(ctx context.Context, c cache.Cache, objs ...client.Object) (healthz.Checker, error) {
+	informers := make(map[string]cache.Informer)
+	for _, obj := range objs {
+		kind := reflect.TypeOf(obj).Elem().Name()
+		informer, err := c.GetInformer(ctx, obj)
+		if meta.IsNoMatchError(err) {
+			log.Info("%s is not installed, its cache is not checked: %v", kind, err)
+			continue
+		}
+		if err != nil {
+			return nil, errors.WithMessagef(err, "get informer of %s failed", kind)
+		}
+		informers[kind] = informer
+	}
+
+	return func(_ *http.Request) error {
+		for kind, informer := range informers {
+			if !informer.HasSynced() {
+				return errors.Errorf("cache of %s is not synced", kind)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// Certificates returns a checker which passes if the certificate and key in dir are a pair, valid now,
+// and valid for host if it is not empty. They are read for each check, so the rotated certificates are checked.
+func Certificates(dir string, host string) healthz.Checker {
+	return func(_ *http.Request) error {
+		pair, err := tls.LoadX509KeyPair(filepath.Join(dir, CertName), filepath.Join(dir, KeyName))
+		if err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return errors.Errorf("certificate is valid from %s to %s", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+		}
+		if host != "" {
+			if err := cert.VerifyHostname(host); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Watchdog checks that the manager makes progress. It sends a probe to its own controller every Interval, which is queued
+// and reconciled by a worker of the manager reading the cache, the same as the other controllers. Its checker fails if
+// no probe is reconciled for Timeout, or the first one is not reconciled in StartupTimeout, which means the manager is wedged.
+type Watchdog struct {
+	Reader client.Reader
+	// List is the type of objects read by the probes, only one of them is read each time
+	List           client.ObjectList
+	Interval       time.Duration
+	Timeout        time.Duration
+	StartupTimeout time.Duration
+
+	probes     chan event.GenericEvent
+	controller controller.Controller
+	setupAt    time.Time
+	// last is the unix nanoseconds of the last probe reconciled
+	last int64
+}
+
+// SetupWithManager adds the controller of the probes, which runs in every replica since every replica should be alive
+func (w *Watchdog) SetupWithManager(mgr manager.Manager) error {
+	w.setupAt = time.Now()
+	w.probes = make(chan event.GenericEvent, 1)
+
+	c, err := controller.NewUnmanaged("watchdog", mgr, controller.Options{Reconciler: w})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Channel{Source: w.probes}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	w.controller = c
+	return mgr.Add(w)
+}
+
+func (w *Watchdog) Start(ctx context.Context) error {
+	probe := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "watchdog"}}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		select {
+		case w.probes <- event.GenericEvent{Object: probe}:
+		default:
+			// the last probe is not reconciled yet
+		}
+	}, w.Interval)
+	return w.controller.Start(ctx)
+}
+
+// NeedLeaderElection is false, since every replica should be alive
+func (w *Watchdog) NeedLeaderElection() bool {
+	return false
+}
+
+// Reconcile handles a probe, which reads the cache to make sure it is not blocked
+func (w *Watchdog) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	list := w.List.DeepCopyObject().(client.ObjectList)
+	if err := w.Reader.List(ctx, list, client.Limit(1)); err != nil {
+		log.Warn("watchdog read cache failed: %v", err)
+		return reconcile.Result{}, nil
+	}
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+	return reconcile.Result{}, nil
+}
+
+func (w *Watchdog) Check(_ *http.Request) error {
+	last := atomic.LoadInt64(&w.last)
+	if last == 0 {
+		if since := time.Since(w.setupAt); since > w.StartupTimeout {
+			return errors.Errorf("no probe has been reconciled in %s since the manager started", since.Round(time.Second))
+		}
+		return nil
+	}
+	if since := time.Since(time.Unix(0, last)); since > w.Timeout {
+		return errors.Errorf("no probe has been reconciled for %s", since.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, dir string, host string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, CertName), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, KeyName), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func TestCertificates(t *testing.T) {
+	host := "loggie-operator.loggie.svc"
+	tests := []struct {
+		name     string
+		write    bool
+		host     string
+		notAfter time.Time
+		wantErr  bool
+	}{
+		{
+			name:    "missing",
+			host:    host,
+			wantErr: true,
+		},
+		{
+			name:     "valid",
+			write:    true,
+			host:     host,
+			notAfter: time.Now().Add(time.Hour),
+		},
+		{
+			name:     "host not checked",
+			write:    true,
+			notAfter: time.Now().Add(time.Hour),
+		},
+		{
+			name:     "wrong host",
+			write:    true,
+			host:     "loggie-operator.default.svc",
+			notAfter: time.Now().Add(time.Hour),
+			wantErr:  true,
+		},
+		{
+			name:     "expired",
+			write:    true,
+			host:     host,
+			notAfter: time.Now().Add(-time.Minute),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.write {
+				writeCertificate(t, dir, host, tt.notAfter)
+			}
+			err := Certificates(dir, tt.host)(nil)
+			assert.Equal(t, tt.wantErr, err != nil, "error: %v", err)
+		})
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	log.InitDefaultLogger()
+
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme)
+	assert.NoError(t, err)
+	w := &Watchdog{Reader: reader, List: &corev1.PodList{}, Interval: 10 * time.Millisecond, Timeout: time.Minute,
+		StartupTimeout: 5 * time.Minute, setupAt: time.Now()}
+
+	// passes before the first probe in the startup budget
+	assert.NoError(t, w.Check(nil))
+
+	_, err = w.Reconcile(context.Background(), reconcile.Request{})
+	assert.NoError(t, err)
+	assert.NotZero(t, atomic.LoadInt64(&w.last))
+	assert.NoError(t, w.Check(nil))
+
+	// the probes stopped being reconciled
+	atomic.StoreInt64(&w.last, time.Now().Add(-2*time.Minute).UnixNano())
+	assert.Error(t, w.Check(nil))
+
+	// the first probe is not reconciled in the startup budget
+	atomic.StoreInt64(&w.last, 0)
+	w.setupAt = time.Now().Add(-10 * time.Minute)
+	assert.Error(t, w.Check(nil))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package heartbeat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// the user info of the ServiceAccount tokens bound to pods has the pod in the extra
+	podNameExtraKey = "authentication.kubernetes.io/pod-name"
+	podUIDExtraKey  = "authentication.kubernetes.io/pod-uid"
+
+	serviceAccountPrefix = "system:serviceaccount:"
+	reviewTTL            = time.Minute
+)
+
+// binding is the pod a ServiceAccount token is bound to
+type binding struct {
+	Namespace string
+	Pod       string
+	UID       types.UID
+}
+
+type review struct {
+	binding binding
+	expires time.Time
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// authenticate reviews the ServiceAccount token projected to the sidecar with heartbeat.audience, and returns the pod
+// it is bound to. The reviews are cached for a minute, since the sidecars report with the same token periodically.
+func (r *Registry) authenticate(ctx context.Context, token string, now time.Time) (binding, error) {
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	r.mu.Lock()
+	rv, ok := r.reviewed[hash]
+	r.mu.Unlock()
+	if ok && now.Before(rv.expires) {
+		return rv.binding, nil
+	}
+
+	audience := r.Config.Load().Sidecar.Heartbeat.Audience
+	tr := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: []string{audience}}}
+	if err := r.Client.Create(ctx, tr); err != nil {
+		return binding{}, errors.WithMessage(err, "review token")
+	}
+	if !tr.Status.Authenticated {
+		return binding{}, errors.Errorf("token is not authenticated: %s", tr.Status.Error)
+	}
+	if !contains(tr.Status.Audiences, audience) {
+		return binding{}, errors.Errorf("token is not for audience %s", audience)
+	}
+
+	user := tr.Status.User
+	names, uids := user.Extra[podNameExtraKey], user.Extra[podUIDExtraKey]
+	if !strings.HasPrefix(user.Username, serviceAccountPrefix) || len(names) != 1 || len(uids) != 1 {
+		return binding{}, errors.Errorf("%s is not a ServiceAccount bound to a pod", user.Username)
+	}
+	b := binding{
+		Namespace: strings.SplitN(strings.TrimPrefix(user.Username, serviceAccountPrefix), ":", 2)[0],
+		Pod:       names[0],
+		UID:       types.UID(uids[0]),
+	}
+
+	r.mu.Lock()
+	r.reviewed[hash] = review{binding: b, expires: now.Add(reviewTTL)}
+	r.mu.Unlock()
+	return b, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
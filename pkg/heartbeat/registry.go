@@ -0,0 +1,427 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package heartbeat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/heartbeat/report"
+	"github.com/loggie-io/operator/pkg/metrics"
+	"github.com/loggie-io/operator/pkg/webhook"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MetricSinkSuccess = "loggie_sink_success_event"
+	MetricSinkFailed  = "loggie_sink_failed_event"
+
+	// LeaseLabelKey labels the Leases storing the heartbeats of the sidecars, one for each pod
+	LeaseLabelKey = "sidecar.loggie.io/heartbeat"
+	// ReportAnnotationKey is the last report of the sidecar in its Lease
+	ReportAnnotationKey = "sidecar.loggie.io/heartbeat-report"
+	leasePrefix         = "loggie-heartbeat-"
+
+	maxReportSize = 1 << 20
+)
+
+// Summary is the heartbeats of the sidecars injected with a LogConfig/ClusterLogConfig
+type Summary struct {
+	// Reporting is the number of sidecars reporting in time
+	Reporting int
+	// Stale are the pods {namespace}/{name} which stopped reporting
+	Stale []string
+	// Errors is the number of reporting sidecars whose metrics cannot be read
+	Errors          int
+	SinkSuccess     float64
+	SinkFailed      float64
+	EventsPerSecond float64
+}
+
+// record is the last report of a sidecar stored in its Lease
+type record struct {
+	Report report.Report `json:"report"`
+	// EventsPerSecond is calculated from the previous report by the replica receiving the report
+	EventsPerSecond float64 `json:"eventsPerSecond,omitempty"`
+}
+
+type sidecar struct {
+	config   string
+	record   record
+	received time.Time
+	stale    bool
+}
+
+// Registry receives the heartbeats of sidecars, and rolls them up by the LogConfig/ClusterLogConfig injected.
+// Any replica receiving a report stores it in the Lease of the pod, which is owned by the pod, and the leader reads
+// the Leases periodically to report them in the status of LogConfigs/ClusterLogConfigs and the metrics.
+// The Leases are read by Reader without the cache, since a cache of Leases would watch all the Leases in the cluster,
+// such as the ones of the nodes and leader elections.
+type Registry struct {
+	Client client.Client
+	Reader client.Reader
+	Config *config.Store
+
+	mu       sync.Mutex
+	sidecars map[types.NamespacedName]*sidecar
+	// configs are the LogConfigs/ClusterLogConfigs with heartbeat metrics
+	configs map[string]bool
+	events  map[string]chan event.GenericEvent
+	// reviewed are the authenticated tokens by their hash, see authenticate
+	reviewed map[string]review
+}
+
+func NewRegistry(cli client.Client, reader client.Reader, store *config.Store) *Registry {
+	return &Registry{
+		Client:   cli,
+		Reader:   reader,
+		Config:   store,
+		sidecars: make(map[types.NamespacedName]*sidecar),
+		configs:  make(map[string]bool),
+		events: map[string]chan event.GenericEvent{
+			webhook.KindLogConfig:        make(chan event.GenericEvent, 128),
+			webhook.KindClusterLogConfig: make(chan event.GenericEvent, 128),
+		},
+		reviewed: make(map[string]review),
+	}
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+
+// ServeHTTP receives a report. It must be authenticated by the ServiceAccount token bound to the pod reporting,
+// the pod must be injected, and its config is read from the pod instead of the report.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := bearerToken(req)
+	if token == "" {
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	rep := report.Report{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&rep); err != nil {
+		http.Error(w, fmt.Sprintf("invalid report: %v", err), http.StatusBadRequest)
+		return
+	}
+	if rep.Namespace == "" || rep.Pod == "" {
+		http.Error(w, "namespace and pod are required", http.StatusBadRequest)
+		return
+	}
+
+	bound, err := r.authenticate(req.Context(), token, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	key := types.NamespacedName{Namespace: rep.Namespace, Name: rep.Pod}
+	if bound.Namespace != key.Namespace || bound.Pod != key.Name {
+		http.Error(w, fmt.Sprintf("token is bound to pod %s/%s", bound.Namespace, bound.Pod), http.StatusForbidden)
+		return
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(req.Context(), key, pod); err != nil {
+		code := http.StatusInternalServerError
+		if kerrors.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if pod.UID != bound.UID {
+		http.Error(w, fmt.Sprintf("token is bound to a previous pod %s", key), http.StatusForbidden)
+		return
+	}
+	ref := pod.Annotations[webhook.ConfigAnnotationKey]
+	if ref == "" {
+		http.Error(w, fmt.Sprintf("pod %s is not injected", key), http.StatusNotFound)
+		return
+	}
+
+	if err := r.store(req.Context(), pod, ref, rep, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaseName returns the name of the Lease storing the heartbeats of the pod
+func LeaseName(pod string) string {
+	name := leasePrefix + pod
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		sum := sha256.Sum256([]byte(pod))
+		name = leasePrefix + hex.EncodeToString(sum[:])
+	}
+	return name
+}
+
+// store writes the report to the Lease of the pod, the Lease is renewed at each report
+func (r *Registry) store(ctx context.Context, pod *corev1.Pod, ref string, rep report.Report, now time.Time) error {
+	lease := &coordinationv1.Lease{}
+	err := r.Reader.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: LeaseName(pod.Name)}, lease)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	rec := record{Report: rep}
+	if prev, ok := sidecarOf(lease); exists && ok && prev.config == ref {
+		// the counters restart from 0 if the sidecar restarts
+		delta := rep.Sum(MetricSinkSuccess) - prev.record.Report.Sum(MetricSinkSuccess)
+		if elapsed := now.Sub(prev.received).Seconds(); elapsed > 0 && delta >= 0 {
+			rec.EventsPerSecond = delta / elapsed
+		}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	lease.Namespace = pod.Namespace
+	lease.Name = LeaseName(pod.Name)
+	if lease.Labels == nil {
+		lease.Labels = make(map[string]string)
+	}
+	lease.Labels[LeaseLabelKey] = "true"
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[webhook.ConfigAnnotationKey] = ref
+	lease.Annotations[ReportAnnotationKey] = string(data)
+	// the Lease is deleted with the pod
+	lease.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: pod.Name, UID: pod.UID}}
+
+	holder := pod.Name
+	duration := int32(r.Config.Load().Sidecar.Heartbeat.Timeout.Seconds())
+	renew := metav1.NewMicroTime(now)
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &renew
+
+	if exists {
+		return r.Client.Update(ctx, lease)
+	}
+	return r.Client.Create(ctx, lease)
+}
+
+// sidecarOf reads the heartbeat stored in the Lease, the pod is the holder of the Lease
+func sidecarOf(lease *coordinationv1.Lease) (*sidecar, bool) {
+	if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+		return nil, false
+	}
+	s := &sidecar{config: lease.Annotations[webhook.ConfigAnnotationKey], received: lease.Spec.RenewTime.Time}
+	if err := json.Unmarshal([]byte(lease.Annotations[ReportAnnotationKey]), &s.record); err != nil || s.config == "" {
+		return nil, false
+	}
+	return s, true
+}
+
+// Summary returns the heartbeats of the sidecars injected with the LogConfig/ClusterLogConfig by its reference,
+// and false if none of them has reported
+func (r *Registry) Summary(ref string) (Summary, bool) {
+	if r == nil {
+		return Summary{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary, ok := r.summaries()[ref]
+	if !ok {
+		return Summary{}, false
+	}
+	return *summary, true
+}
+
+func (r *Registry) summaries() map[string]*Summary {
+	summaries := make(map[string]*Summary)
+	for key, s := range r.sidecars {
+		summary, ok := summaries[s.config]
+		if !ok {
+			summary = &Summary{}
+			summaries[s.config] = summary
+		}
+		if s.stale {
+			summary.Stale = append(summary.Stale, key.String())
+			continue
+		}
+		summary.Reporting++
+		if s.record.Report.Error != "" {
+			summary.Errors++
+		}
+		summary.SinkSuccess += s.record.Report.Sum(MetricSinkSuccess)
+		summary.SinkFailed += s.record.Report.Sum(MetricSinkFailed)
+		summary.EventsPerSecond += s.record.EventsPerSecond
+	}
+	for _, summary := range summaries {
+		sort.Strings(summary.Stale)
+	}
+	return summaries
+}
+
+// Start reads the heartbeats in the Leases periodically, until ctx is done
+func (r *Registry) Start(ctx context.Context) error {
+	interval := r.Config.Load().Sidecar.Heartbeat.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		r.sweep(ctx, time.Now())
+	}, interval)
+	return nil
+}
+
+// NeedLeaderElection is true, since the leader reports the heartbeats received by all the replicas
+func (r *Registry) NeedLeaderElection() bool {
+	return true
+}
+
+// sweep reads the heartbeats in the Leases, marks the sidecars not reporting for the timeout as stale, deletes the
+// Leases of the finished or re-injected pods, and updates the metrics
+func (r *Registry) sweep(ctx context.Context, now time.Time) {
+	timeout := r.Config.Load().Sidecar.Heartbeat.Timeout
+
+	leases := &coordinationv1.LeaseList{}
+	if err := r.Reader.List(ctx, leases, client.HasLabels{LeaseLabelKey}); err != nil {
+		log.Warn("list heartbeat leases failed: %v", err)
+		return
+	}
+	sidecars := make(map[types.NamespacedName]*sidecar)
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		s, ok := sidecarOf(lease)
+		if !ok {
+			continue
+		}
+		key := types.NamespacedName{Namespace: lease.Namespace, Name: *lease.Spec.HolderIdentity}
+		if now.Sub(s.received) > timeout {
+			if r.gone(ctx, key, s.config) {
+				if err := r.Client.Delete(ctx, lease); client.IgnoreNotFound(err) != nil {
+					log.Warn("delete heartbeat lease %s/%s failed: %v", lease.Namespace, lease.Name, err)
+				}
+				continue
+			}
+			s.stale = true
+		}
+		sidecars[key] = s
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, s := range sidecars {
+		prev, ok := r.sidecars[key]
+		if ok && prev.config != s.config {
+			r.notify(prev.config)
+		}
+		switch {
+		case s.stale && (!ok || !prev.stale):
+			log.Warn("sidecar of pod %s has not reported since %s", key, s.received.Format(time.RFC3339))
+			r.notify(s.config)
+		case !ok || prev.config != s.config:
+			r.notify(s.config)
+		case !s.stale && prev.stale:
+			log.Info("sidecar of pod %s reports again", key)
+			r.notify(s.config)
+		}
+	}
+	for key, prev := range r.sidecars {
+		if _, ok := sidecars[key]; !ok {
+			r.notify(prev.config)
+		}
+	}
+	r.sidecars = sidecars
+	for hash, rv := range r.reviewed {
+		if !now.Before(rv.expires) {
+			delete(r.reviewed, hash)
+		}
+	}
+
+	summaries := r.summaries()
+	for ref, summary := range summaries {
+		kind, namespace, name, err := webhook.ParseConfigRef(ref)
+		if err != nil {
+			continue
+		}
+		metrics.ReportingSidecars.WithLabelValues(kind, namespace, name).Set(float64(summary.Reporting))
+		metrics.StaleSidecars.WithLabelValues(kind, namespace, name).Set(float64(len(summary.Stale)))
+		metrics.SidecarSinkEvents.WithLabelValues(kind, namespace, name, "success").Set(summary.SinkSuccess)
+		metrics.SidecarSinkEvents.WithLabelValues(kind, namespace, name, "failed").Set(summary.SinkFailed)
+		metrics.SidecarSinkEventsPerSecond.WithLabelValues(kind, namespace, name).Set(summary.EventsPerSecond)
+		r.configs[ref] = true
+	}
+	for ref := range r.configs {
+		if _, ok := summaries[ref]; ok {
+			continue
+		}
+		if kind, namespace, name, err := webhook.ParseConfigRef(ref); err == nil {
+			metrics.DeleteHeartbeats(kind, namespace, name)
+		}
+		delete(r.configs, ref)
+	}
+}
+
+// gone returns whether the pod of a stale sidecar is deleted, finished or re-injected with another config
+func (r *Registry) gone(ctx context.Context, key types.NamespacedName, ref string) bool {
+	pod := &corev1.Pod{}
+	err := r.Client.Get(ctx, key, pod)
+	switch {
+	case kerrors.IsNotFound(err):
+		return true
+	case err != nil:
+		log.Warn("get pod %s failed: %v", key, err)
+		return false
+	}
+	return pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed ||
+		pod.Annotations[webhook.ConfigAnnotationKey] != ref
+}
+
+// notify enqueues the LogConfig/ClusterLogConfig to update its status, the reconciler catches up at the next resync
+// if the channel is full
+func (r *Registry) notify(ref string) {
+	obj, err := webhook.ConfigObjectOf(ref)
+	if err != nil {
+		return
+	}
+	kind, _, _, _ := webhook.ParseConfigRef(ref)
+	select {
+	case r.events[kind] <- event.GenericEvent{Object: obj}:
+	default:
+	}
+}
+
+// Events returns the channel notified of the changes of kind LogConfig or ClusterLogConfig
+func (r *Registry) Events(kind string) <-chan event.GenericEvent {
+	return r.events[kind]
+}
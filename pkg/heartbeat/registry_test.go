@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package heartbeat
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseClient reads the pods from reader, keeps the Leases in memory, and reviews the tokens of tokens
+type leaseClient struct {
+	client.Client
+	reader *kubernetes.ObjectReader
+
+	mu      sync.Mutex
+	leases  map[types.NamespacedName]*coordinationv1.Lease
+	tokens  map[string]*corev1.Pod
+	reviews int
+}
+
+func (c *leaseClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	lease, ok := obj.(*coordinationv1.Lease)
+	if !ok {
+		return c.reader.Get(ctx, key, obj)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stored, ok := c.leases[key]
+	if !ok {
+		return c.reader.Get(ctx, key, obj)
+	}
+	stored.DeepCopyInto(lease)
+	return nil
+}
+
+func (c *leaseClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	leases, ok := list.(*coordinationv1.LeaseList)
+	if !ok {
+		return c.reader.List(ctx, list, opts...)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.leases {
+		leases.Items = append(leases.Items, *l.DeepCopy())
+	}
+	return nil
+}
+
+func (c *leaseClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch o := obj.(type) {
+	case *coordinationv1.Lease:
+		c.leases[client.ObjectKeyFromObject(o)] = o.DeepCopy()
+	case *authenticationv1.TokenReview:
+		c.reviews++
+		pod, ok := c.tokens[o.Spec.Token]
+		if !ok {
+			o.Status.Error = "invalid token"
+			return nil
+		}
+		o.Status.Authenticated = true
+		o.Status.Audiences = o.Spec.Audiences
+		o.Status.User = authenticationv1.UserInfo{
+			Username: "system:serviceaccount:" + pod.Namespace + ":default",
+			Extra: map[string]authenticationv1.ExtraValue{
+				podNameExtraKey: {pod.Name},
+				podUIDExtraKey:  {string(pod.UID)},
+			},
+		}
+	}
+	return nil
+}
+
+func (c *leaseClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.Create(ctx, obj)
+}
+
+func (c *leaseClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.leases, client.ObjectKeyFromObject(obj))
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	log.InitDefaultLogger()
+
+	ref := "LogConfig/default/tomcat"
+	injected := func(name string) *corev1.Pod {
+		pod := &corev1.Pod{}
+		pod.Namespace = "default"
+		pod.Name = name
+		pod.UID = types.UID(name + "-uid")
+		pod.Annotations = map[string]string{webhook.ConfigAnnotationKey: ref}
+		return pod
+	}
+	tomcat0, tomcat1 := injected("tomcat-0"), injected("tomcat-1")
+	plain := &corev1.Pod{}
+	plain.Namespace = "default"
+	plain.Name = "plain"
+
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme, tomcat0, tomcat1, plain)
+	assert.NoError(t, err)
+	cli := &leaseClient{
+		reader: reader,
+		leases: make(map[types.NamespacedName]*coordinationv1.Lease),
+		tokens: map[string]*corev1.Pod{"token-0": tomcat0, "token-1": tomcat1, "token-plain": plain},
+	}
+	store := config.NewStore(&config.Config{Sidecar: &config.Sidecar{Heartbeat: config.Heartbeat{Timeout: time.Minute, Audience: "loggie-operator"}}})
+	// the sidecars report to different replicas, and the leader reports all of them
+	leader, follower := NewRegistry(cli, cli, store), NewRegistry(cli, cli, store)
+
+	post := func(r *Registry, token string, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/heartbeat", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, post(leader, "token-0", `{"namespace": "default", "pod": "tomcat-0", "pipelines": {"local": {"loggie_sink_success_event": 10, "loggie_sink_failed_event": 1}}}`))
+	assert.Equal(t, http.StatusNoContent, post(follower, "token-1", `{"namespace": "default", "pod": "tomcat-1", "pipelines": {"local": {"loggie_sink_success_event": 5}}}`))
+	assert.Equal(t, http.StatusUnauthorized, post(follower, "", `{"namespace": "default", "pod": "tomcat-1"}`))
+	assert.Equal(t, http.StatusUnauthorized, post(follower, "forged", `{"namespace": "default", "pod": "tomcat-1"}`))
+	assert.Equal(t, http.StatusForbidden, post(follower, "token-0", `{"namespace": "default", "pod": "tomcat-1"}`))
+	assert.Equal(t, http.StatusNotFound, post(follower, "token-plain", `{"namespace": "default", "pod": "plain"}`))
+	assert.Equal(t, http.StatusBadRequest, post(follower, "token-0", `{"pod": "tomcat-0"}`))
+
+	leader.sweep(context.Background(), time.Now())
+	summary, ok := leader.Summary(ref)
+	assert.True(t, ok)
+	assert.Equal(t, Summary{Reporting: 2, SinkSuccess: 15, SinkFailed: 1}, summary)
+	_, ok = follower.Summary(ref)
+	assert.False(t, ok)
+
+	// tomcat-0 keeps reporting, and tomcat-1 stops
+	renew := func(name string, ago time.Duration) {
+		cli.mu.Lock()
+		defer cli.mu.Unlock()
+		t := metav1.NewMicroTime(time.Now().Add(-ago))
+		cli.leases[types.NamespacedName{Namespace: "default", Name: LeaseName(name)}].Spec.RenewTime = &t
+	}
+	renew("tomcat-0", 10*time.Second)
+	renew("tomcat-1", 2*time.Minute)
+	assert.Equal(t, http.StatusNoContent, post(follower, "token-0", `{"namespace": "default", "pod": "tomcat-0", "pipelines": {"local": {"loggie_sink_success_event": 110, "loggie_sink_failed_event": 1}}}`))
+	leader.sweep(context.Background(), time.Now())
+
+	summary, ok = leader.Summary(ref)
+	assert.True(t, ok)
+	assert.Equal(t, 1, summary.Reporting)
+	assert.Equal(t, []string{"default/tomcat-1"}, summary.Stale)
+	assert.InDelta(t, 10, summary.EventsPerSecond, 0.5)
+
+	// the stale sidecar reports again
+	assert.Equal(t, http.StatusNoContent, post(leader, "token-1", `{"namespace": "default", "pod": "tomcat-1"}`))
+	leader.sweep(context.Background(), time.Now())
+	summary, _ = leader.Summary(ref)
+	assert.Equal(t, 2, summary.Reporting)
+	assert.Empty(t, summary.Stale)
+
+	// the tokens are reviewed once by each replica, and the forged one every time
+	assert.Equal(t, 6, cli.reviews)
+}
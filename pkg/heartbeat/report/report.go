@@ -0,0 +1,38 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package report is the heartbeat sent by loggie-reporter. It has no dependencies, since loggie-reporter is copied
+// into every injected pod and runs in the Loggie image.
+package report
+
+// Report is sent by loggie-reporter in the sidecar periodically
+type Report struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// Pipelines are the metrics of Loggie by pipeline name, such as loggie_sink_success_event
+	Pipelines map[string]map[string]float64 `json:"pipelines,omitempty"`
+	// Error is set if the metrics of Loggie cannot be read, such as Loggie is not started yet
+	Error string `json:"error,omitempty"`
+}
+
+// Sum returns the sum of the metric in all the pipelines
+func (r *Report) Sum(metric string) float64 {
+	var sum float64
+	for _, m := range r.Pipelines {
+		sum += m[metric]
+	}
+	return sum
+}
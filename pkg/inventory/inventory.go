@@ -0,0 +1,303 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/url"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+)
+
+const (
+	GroupByConfig     = "config"
+	GroupByNamespace  = "namespace"
+	GroupByMode       = "mode"
+	GroupByImage      = "image"
+	GroupByConfigHash = "configHash"
+
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Pod is an injected pod in the inventory
+type Pod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Workload  string `json:"workload"`
+	// Config is the LogConfig/ClusterLogConfig injected, see webhook.ConfigRef
+	Config string `json:"config"`
+	// Mode is sidecar or volume
+	Mode       string `json:"mode"`
+	Image      string `json:"image,omitempty"`
+	ConfigHash string `json:"configHash,omitempty"`
+	Phase      string `json:"phase"`
+}
+
+// PodList is a page of the injected pods ordered by namespace and name
+type PodList struct {
+	Items []Pod `json:"items"`
+	// Total is the number of pods matching the filter in all pages
+	Total int `json:"total"`
+	// Continue is the token to get the next page, which is empty at the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+// Group is the number of injected pods sharing the same key
+type Group struct {
+	Key       string `json:"key"`
+	Pods      int    `json:"pods"`
+	Workloads int    `json:"workloads"`
+}
+
+// GroupList is a page of the groups ordered by key
+type GroupList struct {
+	By       string  `json:"by"`
+	Items    []Group `json:"items"`
+	Total    int     `json:"total"`
+	Continue string  `json:"continue,omitempty"`
+}
+
+// Filter selects the injected pods, the empty fields match all
+type Filter struct {
+	Config     string
+	Namespace  string
+	Mode       string
+	Image      string
+	ConfigHash string
+}
+
+func (f *Filter) matches(p *Pod) bool {
+	return (f.Config == "" || f.Config == p.Config) &&
+		(f.Namespace == "" || f.Namespace == p.Namespace) &&
+		(f.Mode == "" || f.Mode == p.Mode) &&
+		(f.Image == "" || f.Image == p.Image) &&
+		(f.ConfigHash == "" || f.ConfigHash == p.ConfigHash)
+}
+
+// Inventory lists the injected pods for auditing and dashboards. It is read-only, and Reader is expected to be
+// the client of the manager reading from the cache, so the API server is not queried for each request.
+type Inventory struct {
+	Reader client.Reader
+}
+
+// Pods returns the running injected pods matching the filter, ordered by namespace and name
+func (i *Inventory) Pods(ctx context.Context, filter Filter) ([]Pod, error) {
+	podList := &corev1.PodList{}
+	if err := i.Reader.List(ctx, podList, client.InNamespace(filter.Namespace)); err != nil {
+		return nil, err
+	}
+
+	var pods []Pod
+	for j := range podList.Items {
+		pod := &podList.Items[j]
+		ref, ok := pod.Annotations[webhook.ConfigAnnotationKey]
+		if !ok || pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		p := Pod{
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			Workload:   kubernetes.WorkloadOf(pod).String(),
+			Config:     ref,
+			Mode:       config.ModeSidecar,
+			Image:      webhook.InjectedImage(pod),
+			ConfigHash: pod.Annotations[webhook.ConfigHashAnnotationKey],
+			Phase:      string(pod.Status.Phase),
+		}
+		if _, ok := pod.Labels[webhook.VolumeConfigLabelKey]; ok {
+			p.Mode = config.ModeVolume
+		}
+		if filter.matches(&p) {
+			pods = append(pods, p)
+		}
+	}
+
+	sort.Slice(pods, func(a, b int) bool {
+		if pods[a].Namespace != pods[b].Namespace {
+			return pods[a].Namespace < pods[b].Namespace
+		}
+		return pods[a].Name < pods[b].Name
+	})
+	return pods, nil
+}
+
+// Groups counts the pods by the key, ordered by key
+func Groups(pods []Pod, by string) ([]Group, error) {
+	var key func(p *Pod) string
+	switch by {
+	case GroupByConfig:
+		key = func(p *Pod) string { return p.Config }
+	case GroupByNamespace:
+		key = func(p *Pod) string { return p.Namespace }
+	case GroupByMode:
+		key = func(p *Pod) string { return p.Mode }
+	case GroupByImage:
+		key = func(p *Pod) string { return p.Image }
+	case GroupByConfigHash:
+		key = func(p *Pod) string { return p.ConfigHash }
+	default:
+		return nil, errors.Errorf("invalid group by %s, should be one of %s, %s, %s, %s and %s", by,
+			GroupByConfig, GroupByNamespace, GroupByMode, GroupByImage, GroupByConfigHash)
+	}
+
+	groups := make(map[string]*Group)
+	workloads := make(map[string]map[string]bool)
+	for j := range pods {
+		k := key(&pods[j])
+		g, ok := groups[k]
+		if !ok {
+			g = &Group{Key: k}
+			groups[k] = g
+			workloads[k] = make(map[string]bool)
+		}
+		g.Pods++
+		if !workloads[k][pods[j].Workload] {
+			workloads[k][pods[j].Workload] = true
+			g.Workloads++
+		}
+	}
+
+	result := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Key < result[b].Key
+	})
+	return result, nil
+}
+
+// PodsHandler serves GET /inventory/pods, with the query parameters of the filter, limit and continue
+func (i *Inventory) PodsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pods, limit, cont, ok := i.query(w, req)
+		if !ok {
+			return
+		}
+		start, end, next, err := page(len(pods), func(j int) string { return pods[j].Namespace + "/" + pods[j].Name }, limit, cont)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, &PodList{Items: append([]Pod{}, pods[start:end]...), Total: len(pods), Continue: next})
+	})
+}
+
+// GroupsHandler serves GET /inventory/groups?by={key}, with the query parameters of the filter, limit and continue
+func (i *Inventory) GroupsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pods, limit, cont, ok := i.query(w, req)
+		if !ok {
+			return
+		}
+		by := req.URL.Query().Get("by")
+		if by == "" {
+			by = GroupByConfig
+		}
+		groups, err := Groups(pods, by)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, end, next, err := page(len(groups), func(j int) string { return groups[j].Key }, limit, cont)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, &GroupList{By: by, Items: append([]Group{}, groups[start:end]...), Total: len(groups), Continue: next})
+	})
+}
+
+// query lists the pods by the filter in the query, and returns false if the response is written for an error
+func (i *Inventory) query(w http.ResponseWriter, req *http.Request) ([]Pod, int, string, bool) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return nil, 0, "", false
+	}
+
+	q := req.URL.Query()
+	limit, err := parseLimit(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, 0, "", false
+	}
+	pods, err := i.Pods(req.Context(), Filter{
+		Config:     q.Get("config"),
+		Namespace:  q.Get("namespace"),
+		Mode:       q.Get("mode"),
+		Image:      q.Get("image"),
+		ConfigHash: q.Get("configHash"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, 0, "", false
+	}
+	return pods, limit, q.Get("continue"), true
+}
+
+func parseLimit(q url.Values) (int, error) {
+	raw := q.Get("limit")
+	if raw == "" {
+		return DefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		return 0, errors.Errorf("invalid limit %s", raw)
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	return limit, nil
+}
+
+// page returns the range of the page after the key in the continue token, and the token of the next page.
+// The token is the last key of the page, so the pages are stable when the items before it are added or removed.
+func page(n int, key func(int) string, limit int, cont string) (int, int, string, error) {
+	start := 0
+	if cont != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cont)
+		if err != nil {
+			return 0, 0, "", errors.Errorf("invalid continue %s", cont)
+		}
+		start = sort.Search(n, func(j int) bool { return key(j) > string(last) })
+	}
+
+	end := start + limit
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, base64.RawURLEncoding.EncodeToString([]byte(key(end - 1))), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("encode response failed: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInventory(t *testing.T) {
+	pod := func(namespace string, name string, ref string, image string, volume bool) *corev1.Pod {
+		p := &corev1.Pod{}
+		p.Namespace = namespace
+		p.Name = name
+		p.Status.Phase = corev1.PodRunning
+		if ref != "" {
+			p.Annotations = map[string]string{
+				webhook.ConfigAnnotationKey:     ref,
+				webhook.ConfigHashAnnotationKey: "hash-" + image,
+				webhook.ImageAnnotationKey:      image,
+			}
+		}
+		if volume {
+			p.Labels = map[string]string{webhook.VolumeConfigLabelKey: "LogConfig_default_nginx"}
+		}
+		return p
+	}
+	done := pod("default", "job-0", "LogConfig/default/tomcat", "loggie:v1", false)
+	done.Status.Phase = corev1.PodSucceeded
+
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme,
+		pod("default", "tomcat-1", "LogConfig/default/tomcat", "loggie:v1", false),
+		pod("default", "tomcat-0", "LogConfig/default/tomcat", "loggie:v2", false),
+		pod("default", "nginx-0", "LogConfig/default/nginx", "", true),
+		pod("prod", "tomcat-0", "ClusterLogConfig/tomcat", "loggie:v2", false),
+		pod("prod", "plain", "", "", false),
+		done,
+	)
+	assert.NoError(t, err)
+	inv := &Inventory{Reader: reader}
+
+	get := func(h http.Handler, target string, v interface{}) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	pods := &PodList{}
+	assert.Equal(t, http.StatusOK, get(inv.PodsHandler(), "/inventory/pods", pods))
+	assert.Equal(t, 4, pods.Total)
+	var names []string
+	for _, p := range pods.Items {
+		names = append(names, p.Namespace+"/"+p.Name)
+	}
+	assert.Equal(t, []string{"default/nginx-0", "default/tomcat-0", "default/tomcat-1", "prod/tomcat-0"}, names)
+	assert.Equal(t, "volume", pods.Items[0].Mode)
+	assert.Equal(t, Pod{
+		Namespace:  "default",
+		Name:       "tomcat-0",
+		Workload:   "Pod default/tomcat-0",
+		Config:     "LogConfig/default/tomcat",
+		Mode:       "sidecar",
+		Image:      "loggie:v2",
+		ConfigHash: "hash-loggie:v2",
+		Phase:      "Running",
+	}, pods.Items[1])
+
+	// filters
+	pods = &PodList{}
+	assert.Equal(t, http.StatusOK, get(inv.PodsHandler(), "/inventory/pods?namespace=default&image=loggie:v2", pods))
+	assert.Equal(t, 1, pods.Total)
+	assert.Equal(t, "tomcat-0", pods.Items[0].Name)
+
+	// pages
+	first := &PodList{}
+	assert.Equal(t, http.StatusOK, get(inv.PodsHandler(), "/inventory/pods?limit=3", first))
+	assert.Len(t, first.Items, 3)
+	assert.NotEmpty(t, first.Continue)
+	second := &PodList{}
+	assert.Equal(t, http.StatusOK, get(inv.PodsHandler(), "/inventory/pods?limit=3&continue="+first.Continue, second))
+	assert.Len(t, second.Items, 1)
+	assert.Equal(t, "prod", second.Items[0].Namespace)
+	assert.Empty(t, second.Continue)
+
+	groups := &GroupList{}
+	assert.Equal(t, http.StatusOK, get(inv.GroupsHandler(), "/inventory/groups?by=image", groups))
+	assert.Equal(t, []Group{
+		{Key: "", Pods: 1, Workloads: 1},
+		{Key: "loggie:v1", Pods: 1, Workloads: 1},
+		{Key: "loggie:v2", Pods: 2, Workloads: 2},
+	}, groups.Items)
+
+	assert.Equal(t, http.StatusBadRequest, get(inv.GroupsHandler(), "/inventory/groups?by=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, get(inv.PodsHandler(), "/inventory/pods?limit=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, get(inv.PodsHandler(), "/inventory/pods?continue=!", nil))
+}
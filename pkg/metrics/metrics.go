@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "loggie_operator"
+
+var (
+	// InjectedPods is the number of running pods injected with a LogConfig/ClusterLogConfig
+	InjectedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "injected_pods",
+		Help:      "Number of pods injected with the LogConfig/ClusterLogConfig",
+	}, []string{"kind", "namespace", "name"})
+
+	// OutdatedPods is the number of injected pods whose sidecar config differs from the current LogConfig/ClusterLogConfig
+	OutdatedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outdated_pods",
+		Help:      "Number of injected pods whose sidecar config is out of date with the LogConfig/ClusterLogConfig",
+	}, []string{"kind", "namespace", "name"})
+
+	// SidecarPods is the number of running pods by the injected sidecar image
+	SidecarPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sidecar_pods",
+		Help:      "Number of running pods injected with the sidecar image",
+	}, []string{"image"})
+
+	// Injections is the number of pods injected by the webhook
+	Injections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injections_total",
+		Help:      "Number of pods injected with the sidecar image by the webhook",
+	}, []string{"image"})
+
+	// InjectionFailures is the number of pods failed to be injected with a LogConfig/ClusterLogConfig
+	InjectionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injection_failures_total",
+		Help:      "Number of pods failed to be injected with the LogConfig/ClusterLogConfig by the webhook",
+	}, []string{"kind", "namespace", "name"})
+
+	// ReportingSidecars is the number of injected sidecars reporting heartbeats in time
+	ReportingSidecars = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reporting_sidecars",
+		Help:      "Number of sidecars injected with the LogConfig/ClusterLogConfig reporting heartbeats in time",
+	}, []string{"kind", "namespace", "name"})
+
+	// StaleSidecars is the number of injected sidecars which stopped reporting heartbeats
+	StaleSidecars = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stale_sidecars",
+		Help:      "Number of sidecars injected with the LogConfig/ClusterLogConfig which stopped reporting heartbeats",
+	}, []string{"kind", "namespace", "name"})
+
+	// SidecarSinkEvents is the sum of the events sent by the sidecars reporting heartbeats, by status success or failed
+	SidecarSinkEvents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sidecar_sink_events",
+		Help:      "Sum of the events sent by the sidecars injected with the LogConfig/ClusterLogConfig, by status success or failed",
+	}, []string{"kind", "namespace", "name", "status"})
+
+	// SidecarSinkEventsPerSecond is the throughput of the sidecars reporting heartbeats
+	SidecarSinkEventsPerSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sidecar_sink_events_per_second",
+		Help:      "Events sent per second by the sidecars injected with the LogConfig/ClusterLogConfig",
+	}, []string{"kind", "namespace", "name"})
+
+	// ConfigGeneration is the generation of the active configuration, which is increased every time it is reloaded
+	ConfigGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_generation",
+		Help:      "Generation of the active configuration of the operator",
+	})
+
+	// ConfigReloadFailures is the number of invalid configurations rejected
+	ConfigReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reload_failures_total",
+		Help:      "Number of invalid configurations rejected when reloading",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		InjectedPods,
+		OutdatedPods,
+		SidecarPods,
+		Injections,
+		InjectionFailures,
+		ReportingSidecars,
+		StaleSidecars,
+		SidecarSinkEvents,
+		SidecarSinkEventsPerSecond,
+		ConfigGeneration,
+		ConfigReloadFailures,
+	)
+}
+
+// DeleteConfig removes the metrics of a deleted LogConfig/ClusterLogConfig
+func DeleteConfig(kind string, namespace string, name string) {
+	InjectedPods.DeleteLabelValues(kind, namespace, name)
+	OutdatedPods.DeleteLabelValues(kind, namespace, name)
+	InjectionFailures.DeleteLabelValues(kind, namespace, name)
+	DeleteHeartbeats(kind, namespace, name)
+}
+
+// DeleteHeartbeats removes the heartbeat metrics of a LogConfig/ClusterLogConfig without sidecars reporting
+func DeleteHeartbeats(kind string, namespace string, name string) {
+	ReportingSidecars.DeleteLabelValues(kind, namespace, name)
+	StaleSidecars.DeleteLabelValues(kind, namespace, name)
+	SidecarSinkEvents.DeleteLabelValues(kind, namespace, name, "success")
+	SidecarSinkEvents.DeleteLabelValues(kind, namespace, name, "failed")
+	SidecarSinkEventsPerSecond.DeleteLabelValues(kind, namespace, name)
+}
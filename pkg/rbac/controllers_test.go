@@ -0,0 +1,253 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"github.com/loggie-io/operator/pkg/controllers/loggieagent"
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scannedDirs are the packages writing to the API server with the RBAC of the operator
+var scannedDirs = []string{"../controllers", "../heartbeat", "../webhook"}
+
+// marker is a rule declared by +kubebuilder:rbac in a controller package
+type marker struct {
+	pkg       string
+	group     string
+	resources []string
+	verbs     []string
+}
+
+// write is a call writing to the API server in a controller package
+type write struct {
+	pos         string
+	pkg         string
+	verbs       []string
+	subresource string
+}
+
+var writeVerbs = map[string][]string{
+	"Create":                    {"create"},
+	"Update":                    {"update"},
+	"Patch":                     {"patch"},
+	"Delete":                    {"delete"},
+	"CreateOrUpdate":            {"create", "update"},
+	"UpdateEphemeralContainers": {"update"},
+}
+
+func scanPackages(t *testing.T) ([]marker, []write) {
+	var markers []marker
+	var writes []write
+	fset := token.NewFileSet()
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		pkg := filepath.Base(filepath.Dir(path))
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				if m, ok := parseMarker(pkg, c.Text); ok {
+					markers = append(markers, m)
+				}
+			}
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			fun, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			name, receiver := fun.Sel.Name, fun.X
+			verbs, ok := writeVerbs[name]
+			// the writes of the clients take the context first, unlike the in-memory stores
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if ctx, ok := call.Args[0].(*ast.Ident); !ok || ctx.Name != "ctx" {
+				return true
+			}
+
+			w := write{pos: fset.Position(call.Pos()).String(), pkg: pkg, verbs: verbs}
+			if name == "UpdateEphemeralContainers" {
+				w.subresource = "ephemeralcontainers"
+			} else if sub, ok := receiver.(*ast.CallExpr); ok {
+				if sel, ok := sub.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Status" {
+					w.subresource = "status"
+				}
+			}
+			writes = append(writes, w)
+			return true
+		})
+		return nil
+	}
+	for _, dir := range scannedDirs {
+		assert.NoError(t, filepath.Walk(dir, walk))
+	}
+	return markers, writes
+}
+
+func parseMarker(pkg string, text string) (marker, bool) {
+	text = strings.TrimSpace(strings.TrimPrefix(text, "//"))
+	if !strings.HasPrefix(text, "+kubebuilder:rbac:") {
+		return marker{}, false
+	}
+	m := marker{pkg: pkg}
+	for _, field := range strings.Split(strings.TrimPrefix(text, "+kubebuilder:rbac:"), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "groups":
+			m.group = strings.Trim(kv[1], `"`)
+		case "resources":
+			m.resources = strings.Split(kv[1], ";")
+		case "verbs":
+			m.verbs = strings.Split(kv[1], ";")
+		}
+	}
+	return m, true
+}
+
+// clusterRules returns the rules granted to the operator by the manifests, except the leader election
+func clusterRules(o *Options) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	for _, obj := range Manifests(o) {
+		switch r := obj.(type) {
+		case *rbacv1.ClusterRole:
+			rules = append(rules, r.Rules...)
+		case *rbacv1.Role:
+			if r.Name != LeaderElectionName {
+				rules = append(rules, r.Rules...)
+			}
+		}
+	}
+	return rules
+}
+
+func allows(rules []rbacv1.PolicyRule, group string, resource string, verb string) bool {
+	for _, r := range rules {
+		if contains(r.APIGroups, group) && contains(r.Resources, resource) && contains(r.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TestControllerWrites checks that every write of the controllers, the heartbeat registry and the webhook is declared by a marker of its package,
+// and every marker is granted by the manifests, in the cluster mode and the namespace mode.
+func TestControllerWrites(t *testing.T) {
+	markers, writes := scanPackages(t)
+	if !assert.NotEmpty(t, markers) || !assert.NotEmpty(t, writes) {
+		return
+	}
+
+	for _, w := range writes {
+		for _, verb := range w.verbs {
+			declared := false
+			for _, m := range markers {
+				if m.pkg != w.pkg || !contains(m.verbs, verb) {
+					continue
+				}
+				for _, res := range m.resources {
+					parts := strings.SplitN(res, "/", 2)
+					if (len(parts) == 2 && parts[1] == w.subresource) || (len(parts) == 1 && w.subresource == "") {
+						declared = true
+					}
+				}
+			}
+			assert.True(t, declared, "%s: %s is not declared by a marker of package %s", w.pos, verb, w.pkg)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options Options
+		// skipped are the packages not running in the mode
+		skipped []string
+	}{
+		{
+			name:    "cluster",
+			options: Options{Namespace: "loggie", ServiceAccount: "loggie-operator", ClusterLogConfigs: true},
+		},
+		{
+			name:    "namespaces",
+			options: Options{Namespace: "loggie", ServiceAccount: "loggie-operator", Namespaces: []string{"team-a"}, ClusterLogConfigs: true},
+			skipped: []string{"loggieagent"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := clusterRules(&tt.options)
+
+			for _, m := range markers {
+				if contains(tt.skipped, m.pkg) {
+					continue
+				}
+				for _, res := range m.resources {
+					for _, verb := range m.verbs {
+						assert.True(t, allows(rules, m.group, res, verb), "%s: %s %s/%s is not granted", m.pkg, verb, m.group, res)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestAgentRules checks that the operator holds the rules it grants to the LoggieAgents in the cluster mode,
+// otherwise the API server rejects creating the ClusterRole of the agents as an escalation.
+func TestAgentRules(t *testing.T) {
+	for _, clusterLogConfigs := range []bool{true, false} {
+		rules := clusterRules(&Options{Namespace: "loggie", ServiceAccount: "loggie-operator", ClusterLogConfigs: clusterLogConfigs})
+		for _, r := range loggieagent.AgentRules {
+			for _, group := range r.APIGroups {
+				for _, res := range r.Resources {
+					for _, verb := range r.Verbs {
+						assert.True(t, allows(rules, group, res, verb), "%s %s/%s is granted to the agents but not held by the operator, cluster-log-configs=%t",
+							verb, group, res, clusterLogConfigs)
+					}
+				}
+			}
+		}
+	}
+}
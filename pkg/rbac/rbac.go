@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	Name               = "loggie-operator"
+	LeaderElectionName = "loggie-operator-leader-election"
+)
+
+var (
+	all      = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+	readOnly = []string{"get", "list", "watch"}
+	status   = []string{"get", "update", "patch"}
+)
+
+// namespacedRules are of the objects in the watched namespaces, which are granted cluster wide in the cluster mode
+var namespacedRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch", "patch"}},
+	{APIGroups: []string{""}, Resources: []string{"pods/ephemeralcontainers"}, Verbs: status},
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+	{APIGroups: []string{""}, Resources: []string{"configmaps", "services"}, Verbs: all},
+	// the Leases of the sidecar heartbeats, RBAC cannot restrict them by name prefix or label, see heartbeat.Registry
+	{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "list", "create", "update", "delete"}},
+	{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: all},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: readOnly},
+	{APIGroups: []string{"policy"}, Resources: []string{"poddisruptionbudgets"}, Verbs: all},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"logconfigs"}, Verbs: all},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"logconfigs/status"}, Verbs: status},
+	{APIGroups: []string{"operator.loggie.io"}, Resources: []string{"logclusters"}, Verbs: readOnly},
+	{APIGroups: []string{"operator.loggie.io"}, Resources: []string{"logclusters/status"}, Verbs: status},
+}
+
+// clusterScopedRules are of the cluster-scoped objects in all modes, Sinks and Interceptors are cluster-scoped in Loggie,
+// and the tokens of the sidecar heartbeats are reviewed
+var clusterScopedRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: readOnly},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"sinks", "interceptors"}, Verbs: readOnly},
+	{APIGroups: []string{"authentication.k8s.io"}, Resources: []string{"tokenreviews"}, Verbs: []string{"create"}},
+}
+
+var clusterLogConfigRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"clusterlogconfigs"}, Verbs: all},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"clusterlogconfigs/status"}, Verbs: status},
+}
+
+// clusterModeRules are of LoggieAgent, whose controller runs in the cluster mode only since it grants the DaemonSet cluster wide.
+// The operator also holds the rules it grants to the agents, since RBAC does not allow granting more than the operator holds.
+var clusterModeRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: all},
+	{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles", "clusterrolebindings"}, Verbs: all},
+	{APIGroups: []string{"operator.loggie.io"}, Resources: []string{"loggieagents"}, Verbs: readOnly},
+	{APIGroups: []string{"operator.loggie.io"}, Resources: []string{"loggieagents/status"}, Verbs: status},
+	{APIGroups: []string{""}, Resources: []string{"pods", "nodes", "namespaces"}, Verbs: readOnly},
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"get", "list", "watch", "create", "update", "patch"}},
+	{APIGroups: []string{"apps"}, Resources: []string{"deployments", "replicasets", "statefulsets", "daemonsets"}, Verbs: readOnly},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}, Verbs: readOnly},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"logconfigs", "clusterlogconfigs", "sinks", "interceptors", "vms"}, Verbs: readOnly},
+	{APIGroups: []string{"loggie.io"}, Resources: []string{"logconfigs/status", "clusterlogconfigs/status", "vms/status"}, Verbs: status},
+}
+
+// leaderElectionRules are in the namespace of the operator, the ConfigMaps are also read for the configuration
+var leaderElectionRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: all},
+	{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: all},
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+}
+
+// Options are the namespace mode of the operator
+type Options struct {
+	// Namespace and ServiceAccount are of the operator
+	Namespace      string
+	ServiceAccount string
+	// Namespaces are watched by the operator, empty for all namespaces
+	Namespaces        []string
+	ClusterLogConfigs bool
+}
+
+// Manifests returns the least RBAC objects for the operator to run in the namespace mode.
+// In the cluster mode, a ClusterRole grants all the rules. Otherwise, a Role in each watched namespace grants the rules
+// of the namespaced objects, and a ClusterRole grants reading the cluster-scoped ones only.
+func Manifests(o *Options) []client.Object {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: o.Namespace, Name: o.ServiceAccount}}
+
+	var clusterRules []rbacv1.PolicyRule
+	if len(o.Namespaces) == 0 {
+		clusterRules = append(clusterRules, namespacedRules...)
+	}
+	clusterRules = append(clusterRules, clusterScopedRules...)
+	if o.ClusterLogConfigs {
+		clusterRules = append(clusterRules, clusterLogConfigRules...)
+	}
+	if len(o.Namespaces) == 0 {
+		clusterRules = append(clusterRules, clusterModeRules...)
+	}
+
+	objs := []client.Object{
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: Name},
+			Rules:      clusterRules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: Name},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: Name},
+			Subjects:   subjects,
+		},
+	}
+	for _, ns := range o.Namespaces {
+		objs = append(objs, role(ns, Name, namespacedRules, subjects)...)
+	}
+	return append(objs, role(o.Namespace, LeaderElectionName, leaderElectionRules, subjects)...)
+}
+
+func role(namespace string, name string, rules []rbacv1.PolicyRule, subjects []rbacv1.Subject) []client.Object {
+	return []client.Object{
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Rules:      rules,
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		},
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"testing"
+)
+
+func TestManifests(t *testing.T) {
+	granted := func(rules []rbacv1.PolicyRule, resource string) bool {
+		for _, r := range rules {
+			for _, res := range r.Resources {
+				if res == resource {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name           string
+		options        Options
+		wantRoles      []string
+		wantCluster    []string
+		wantNotCluster []string
+	}{
+		{
+			name:        "cluster",
+			options:     Options{Namespace: "loggie", ServiceAccount: "loggie-operator", ClusterLogConfigs: true},
+			wantRoles:   []string{"loggie/" + LeaderElectionName},
+			wantCluster: []string{"pods", "logconfigs", "clusterlogconfigs", "sinks", "clusterroles"},
+		},
+		{
+			name:           "namespaces",
+			options:        Options{Namespace: "loggie", ServiceAccount: "loggie-operator", Namespaces: []string{"team-a", "team-b"}},
+			wantRoles:      []string{"team-a/" + Name, "team-b/" + Name, "loggie/" + LeaderElectionName},
+			wantCluster:    []string{"namespaces", "sinks", "interceptors"},
+			wantNotCluster: []string{"pods", "logconfigs", "clusterlogconfigs", "clusterroles"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roles []string
+			var clusterRole *rbacv1.ClusterRole
+			for _, obj := range Manifests(&tt.options) {
+				switch o := obj.(type) {
+				case *rbacv1.ClusterRole:
+					clusterRole = o
+				case *rbacv1.Role:
+					roles = append(roles, o.Namespace+"/"+o.Name)
+					assert.True(t, granted(o.Rules, "pods") || o.Name == LeaderElectionName)
+				}
+			}
+
+			assert.Equal(t, tt.wantRoles, roles)
+			for _, res := range tt.wantCluster {
+				assert.True(t, granted(clusterRole.Rules, res), res)
+			}
+			for _, res := range tt.wantNotCluster {
+				assert.False(t, granted(clusterRole.Rules, res), res)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/loggie-io/operator/pkg/webhook"
+	"github.com/pkg/errors"
+	"gomodules.xyz/jsonpatch/v2"
+	"hash/fnv"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultNamespace = "default"
+
+// Result is the output of rendering one workload
+type Result struct {
+	// Workload is the kind/namespace/name of the rendered workload
+	Workload string
+	Injected bool
+	// Message tells why the sidecar would not be injected
+	Message string
+
+	Template *corev1.PodTemplateSpec
+	Pipeline string
+	Patch    []jsonpatch.Operation
+}
+
+// Decode reads objects from multi-document yaml or json
+func Decode(scheme *runtime.Scheme, data []byte) ([]client.Object, error) {
+	deserializer := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+
+	var objs []client.Object
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+
+		obj, _, err := deserializer.Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		o, ok := obj.(client.Object)
+		if !ok {
+			return nil, errors.Errorf("%T is not a kubernetes object", obj)
+		}
+		objs = append(objs, o)
+	}
+
+	return objs, nil
+}
+
+// PodFromWorkload returns the pod that would be created from a Pod, Deployment, StatefulSet, DaemonSet, Job or CronJob.
+// The pod is owned by the workload, or the ReplicaSet of the Deployment with the pod-template-hash label, so it is
+// resolved to the workload by the owner selector. The pod of a CronJob is owned by the CronJob instead of its Job.
+func PodFromWorkload(obj client.Object) (*corev1.Pod, bool) {
+	var tmpl *corev1.PodTemplateSpec
+	controller := true
+	owner := metav1.OwnerReference{Name: obj.GetName(), UID: obj.GetUID(), Controller: &controller}
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		pod := o.DeepCopy()
+		if pod.Namespace == "" {
+			pod.Namespace = defaultNamespace
+		}
+		return pod, true
+
+	case *appsv1.Deployment:
+		tmpl = &o.Spec.Template
+		owner.APIVersion, owner.Kind = appsv1.SchemeGroupVersion.String(), kubernetes.KindReplicaSet
+	case *appsv1.StatefulSet:
+		tmpl = &o.Spec.Template
+		owner.APIVersion, owner.Kind = appsv1.SchemeGroupVersion.String(), kubernetes.KindStatefulSet
+	case *appsv1.DaemonSet:
+		tmpl = &o.Spec.Template
+		owner.APIVersion, owner.Kind = appsv1.SchemeGroupVersion.String(), kubernetes.KindDaemonSet
+	case *batchv1.Job:
+		tmpl = &o.Spec.Template
+		owner.APIVersion, owner.Kind = batchv1.SchemeGroupVersion.String(), kubernetes.KindJob
+	case *batchv1.CronJob:
+		tmpl = &o.Spec.JobTemplate.Spec.Template
+		owner.APIVersion, owner.Kind = batchv1.SchemeGroupVersion.String(), kubernetes.KindCronJob
+
+	default:
+		return nil, false
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: *tmpl.ObjectMeta.DeepCopy(),
+		Spec:       *tmpl.Spec.DeepCopy(),
+	}
+	pod.Namespace = obj.GetNamespace()
+	if pod.Namespace == "" {
+		pod.Namespace = defaultNamespace
+	}
+	if owner.Kind == kubernetes.KindReplicaSet {
+		hash := templateHash(tmpl)
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+		owner.Name = obj.GetName() + "-" + hash
+	}
+	pod.OwnerReferences = []metav1.OwnerReference{owner}
+	pod.GenerateName = owner.Name + "-"
+	return pod, true
+}
+
+// templateHash stands for the pod-template-hash of the ReplicaSet, which only has to be stable
+func templateHash(tmpl *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(tmpl)
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum32())
+}
+
+// Render runs the sidecar injection against every workload in objs. The LogConfig, ClusterLogConfig, Sink
+// and Interceptor in objs are served to the injector instead of the ones in the apiserver.
+func Render(scheme *runtime.Scheme, conf *config.Sidecar, objs []client.Object) ([]*Result, error) {
+	for _, o := range objs {
+		if o.GetNamespace() == "" && isNamespaced(o) {
+			o.SetNamespace(defaultNamespace)
+		}
+	}
+
+	reader, err := kubernetes.NewObjectReader(scheme, objs...)
+	if err != nil {
+		return nil, err
+	}
+	injection := &webhook.SidecarInjection{
+		Config: conf,
+		Reader: reader,
+	}
+
+	var results []*Result
+	for _, o := range objs {
+		pod, ok := PodFromWorkload(o)
+		if !ok {
+			continue
+		}
+
+		gvk, err := apiutil.GVKForObject(o, scheme)
+		if err != nil {
+			return nil, err
+		}
+		res, err := renderPod(injection, pod)
+		if err != nil {
+			return nil, err
+		}
+		res.Workload = fmt.Sprintf("%s %s/%s", gvk.Kind, pod.Namespace, o.GetName())
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func renderPod(injection *webhook.SidecarInjection, pod *corev1.Pod) (*Result, error) {
+	res := &Result{}
+	if !webhook.CheckInject(pod.ObjectMeta, injection.Config.IgnoreNamespaces) {
+		res.Message = fmt.Sprintf("pod does not have annotation %s: %s, or its namespace is ignored",
+			webhook.InjectorAnnotationKey, webhook.InjectorAnnotationValueTrue)
+		return res, nil
+	}
+
+	mutated := pod.DeepCopy()
+	if err := injection.AddPodSidecar(mutated); err != nil {
+		res.Message = err.Error()
+		return res, nil
+	}
+	res.Injected = true
+	res.Template = &corev1.PodTemplateSpec{
+		ObjectMeta: mutated.ObjectMeta,
+		Spec:       mutated.Spec,
+	}
+	res.Template.Namespace = ""
+	res.Template.GenerateName = ""
+	// the owner and the pod-template-hash label are set by PodFromWorkload, not in the template
+	res.Template.OwnerReferences = nil
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == kubernetes.KindReplicaSet {
+		res.Template.Labels = copyWithout(res.Template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	}
+	res.Pipeline = webhook.InjectedPipeline(mutated)
+
+	origin, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	current, err := json.Marshal(mutated)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.CreatePatch(origin, current)
+	if err != nil {
+		return nil, err
+	}
+	sortPatch(patch)
+	res.Patch = patch
+
+	return res, nil
+}
+
+// sortPatch makes the order of operations stable. The operations are created by walking maps, so only
+// their order across different fields is random, and operations on the same array keep their relative order.
+func sortPatch(patch []jsonpatch.Operation) {
+	fieldOf := func(path string) string {
+		i := strings.LastIndex(path, "/")
+		if _, err := strconv.Atoi(path[i+1:]); err == nil {
+			return path[:i]
+		}
+		return path
+	}
+	sort.SliceStable(patch, func(i, j int) bool {
+		return fieldOf(patch[i].Path) < fieldOf(patch[j].Path)
+	})
+}
+
+// Print writes the results as a multi-document yaml
+func Print(w io.Writer, results []*Result) error {
+	for _, res := range results {
+		if !res.Injected {
+			fmt.Fprintf(w, "---\n# %s: would not inject Loggie sidecar, %s\n", res.Workload, res.Message)
+			continue
+		}
+
+		tmpl, err := yaml.Marshal(res.Template)
+		if err != nil {
+			return err
+		}
+		patch, err := json.MarshalIndent(res.Patch, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "---\n# %s: pod template\n%s", res.Workload, tmpl)
+		fmt.Fprintf(w, "---\n# %s: pipelines\n%s", res.Workload, res.Pipeline)
+		fmt.Fprintf(w, "---\n# %s: json patch\n%s\n", res.Workload, patch)
+	}
+	return nil
+}
+
+func copyWithout(m map[string]string, key string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func isNamespaced(o client.Object) bool {
+	switch o.(type) {
+	case *corev1.Pod, *appsv1.Deployment, *appsv1.StatefulSet, *appsv1.DaemonSet, *batchv1.Job, *batchv1.CronJob,
+		*logconfigv1beta1.LogConfig:
+		return true
+	}
+	// Sink, Interceptor and ClusterLogConfig are cluster scoped
+	return false
+}
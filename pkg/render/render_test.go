@@ -0,0 +1,150 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"testing"
+)
+
+const manifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: tomcat
+spec:
+  selector:
+    matchLabels:
+      app: tomcat
+  template:
+    metadata:
+      annotations:
+        sidecar.loggie.io/inject: "true"
+      labels:
+        app: tomcat
+    spec:
+      containers:
+        - image: tomcat
+          name: tomcat
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      containers:
+        - image: migrate
+          name: migrate
+---
+apiVersion: loggie.io/v1beta1
+kind: LogConfig
+metadata:
+  annotations:
+    sidecar.loggie.io/inject: "true"
+    sidecar.loggie.io/owner-selector: Deployment/tomcat
+  name: tomcat
+spec:
+  pipeline:
+    sinkRef: default
+    sources: |
+      - type: file
+        name: common
+        paths:
+          - /usr/local/tomcat/logs/*.log
+  selector:
+    labelSelector:
+      app: tomcat
+    type: pod
+---
+apiVersion: loggie.io/v1beta1
+kind: Sink
+metadata:
+  name: default
+spec:
+  sink: |
+    type: dev
+`
+
+func TestRender(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, logconfigv1beta1.AddToScheme(scheme))
+
+	objs, err := Decode(scheme, []byte(manifests))
+	assert.NoError(t, err)
+	assert.Len(t, objs, 4)
+
+	results, err := Render(scheme, &config.Sidecar{Image: "loggieio/loggie:main"}, objs)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	deploy := results[0]
+	assert.Equal(t, "Deployment default/tomcat", deploy.Workload)
+	assert.True(t, deploy.Injected)
+	assert.Len(t, deploy.Template.Spec.Containers, 2)
+	assert.Contains(t, deploy.Pipeline, "/usr/local/tomcat/logs/*.log")
+	assert.Contains(t, deploy.Pipeline, "type: dev")
+	assert.NotEmpty(t, deploy.Patch)
+	assert.Empty(t, deploy.Template.OwnerReferences)
+	assert.Equal(t, map[string]string{"app": "tomcat"}, deploy.Template.Labels)
+
+	job := results[1]
+	assert.Equal(t, "Job default/migrate", job.Workload)
+	assert.False(t, job.Injected)
+	assert.Nil(t, job.Template)
+}
+
+func TestPodFromWorkload(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	objs, err := Decode(scheme, []byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: tomcat
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+`))
+	assert.NoError(t, err)
+
+	want := []kubernetes.Workload{
+		{Kind: kubernetes.KindDeployment, Namespace: "default", Name: "tomcat"},
+		{Kind: kubernetes.KindStatefulSet, Namespace: "default", Name: "web"},
+		{Kind: kubernetes.KindCronJob, Namespace: "default", Name: "backup"},
+	}
+	for i, obj := range objs {
+		pod, ok := PodFromWorkload(obj)
+		assert.True(t, ok)
+		assert.Equal(t, want[i], kubernetes.WorkloadOf(pod))
+	}
+}
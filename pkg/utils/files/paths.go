@@ -41,15 +41,15 @@ func CommonPath(paths []string) []string {
 }
 
 func removeDuplicateDirs(dirs []string) []string {
-	m := make(map[string]bool)
+	// keep the results in the order of dirs, so the volumes generated from them are stable
+	var res []string
 
 	for _, dir := range dirs {
 		add := true
-		for k := range m {
-			result := commonParents(dir, k)
+		for i, r := range res {
+			result := commonParents(dir, r)
 			if result != "" {
-				delete(m, k)
-				m[result] = true
+				res[i] = result
 				add = false
 				break
 			}
@@ -57,15 +57,10 @@ func removeDuplicateDirs(dirs []string) []string {
 
 		if add {
 			// has no common parents
-			m[dir] = true
+			res = append(res, dir)
 		}
 	}
 
-	var res []string
-	for k := range m {
-		res = append(res, k)
-	}
-
 	return res
 }
 
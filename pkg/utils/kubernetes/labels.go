@@ -16,28 +16,48 @@ limitations under the License.
 
 package kubernetes
 
+import (
+	"fmt"
+	"sort"
+)
+
 const MatchAllToken = "*"
 
 // LabelsSubset checks if i is subset of j
 func LabelsSubset(i map[string]string, j map[string]string) bool {
+	ok, _ := LabelsSubsetWithReasons(i, j)
+	return ok
+}
+
+// LabelsSubsetWithReasons checks if i is subset of j like LabelsSubset, and tells the result of each label in i
+func LabelsSubsetWithReasons(i map[string]string, j map[string]string) (bool, []string) {
 	if len(i) <= 0 {
-		return true
+		return true, []string{"labelSelector is empty, matches all pods"}
 	}
 
-	if len(j) <= 0 {
-		return false
+	keys := make([]string, 0, len(i))
+	for key := range i {
+		keys = append(keys, key)
 	}
-
-	for key, val := range i {
-		if val == MatchAllToken {
-			if _, ok := j[key]; ok {
-				continue
-			}
-			return false
-		}
-		if j[key] != val {
-			return false
+	sort.Strings(keys)
+
+	matched := true
+	var reasons []string
+	for _, key := range keys {
+		val := i[key]
+		actual, ok := j[key]
+		switch {
+		case !ok:
+			matched = false
+			reasons = append(reasons, fmt.Sprintf("%s=%s: label %s not found", key, val, key))
+		case val == MatchAllToken:
+			reasons = append(reasons, fmt.Sprintf("%s=%s: matched", key, val))
+		case actual != val:
+			matched = false
+			reasons = append(reasons, fmt.Sprintf("%s=%s: label value is %s", key, val, actual))
+		default:
+			reasons = append(reasons, fmt.Sprintf("%s=%s: matched", key, val))
 		}
 	}
-	return true
+	return matched, reasons
 }
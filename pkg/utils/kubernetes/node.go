@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+)
+
+// NodeLabelsGuaranteedWithReasons checks if every node the pod could be scheduled to has the labels in i, and tells
+// the result of each label. The nodes are resolved from spec.nodeSelector and the required node affinity of the pod,
+// since the pod is not scheduled yet at admission.
+func NodeLabelsGuaranteedWithReasons(i map[string]string, pod *corev1.Pod) (bool, []string) {
+	if len(i) <= 0 {
+		return true, []string{"nodeSelector is empty, matches all nodes"}
+	}
+
+	keys := make([]string, 0, len(i))
+	for key := range i {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	matched := true
+	var reasons []string
+	for _, key := range keys {
+		val := i[key]
+		values, exists := nodeLabelValues(pod, key)
+		switch {
+		case !exists:
+			matched = false
+			reasons = append(reasons, fmt.Sprintf("node %s=%s: label %s is not required by nodeSelector or node affinity of the pod", key, val, key))
+		case val == MatchAllToken:
+			reasons = append(reasons, fmt.Sprintf("node %s=%s: matched", key, val))
+		case len(values) != 1 || values[0] != val:
+			matched = false
+			reasons = append(reasons, fmt.Sprintf("node %s=%s: the pod could be scheduled to nodes with %s in %v", key, val, key, values))
+		default:
+			reasons = append(reasons, fmt.Sprintf("node %s=%s: matched", key, val))
+		}
+	}
+	return matched, reasons
+}
+
+// nodeLabelValues returns if the label key exists on every node the pod could be scheduled to, and the values it could be.
+// The values are nil if the label is required to exist with any value.
+func nodeLabelValues(pod *corev1.Pod, key string) ([]string, bool) {
+	if val, ok := pod.Spec.NodeSelector[key]; ok {
+		return []string{val}, true
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil, false
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return nil, false
+	}
+
+	// terms are ORed, the label is required only if it is required in every term
+	union := make(map[string]bool)
+	anyValue := false
+	for _, term := range terms {
+		values, exists := termLabelValues(term, key)
+		if !exists {
+			return nil, false
+		}
+		if values == nil {
+			anyValue = true
+		}
+		for _, v := range values {
+			union[v] = true
+		}
+	}
+	if anyValue {
+		return nil, true
+	}
+
+	result := make([]string, 0, len(union))
+	for v := range union {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result, true
+}
+
+// termLabelValues is nodeLabelValues of a node selector term, whose expressions are ANDed
+func termLabelValues(term corev1.NodeSelectorTerm, key string) ([]string, bool) {
+	var values []string
+	exists := false
+	for _, expr := range term.MatchExpressions {
+		if expr.Key != key {
+			continue
+		}
+		switch expr.Operator {
+		case corev1.NodeSelectorOpIn:
+			if !exists || values == nil {
+				values = append([]string(nil), expr.Values...)
+			} else {
+				values = intersect(values, expr.Values)
+			}
+			exists = true
+		case corev1.NodeSelectorOpExists, corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+			exists = true
+		}
+	}
+	return values, exists
+}
+
+func intersect(a []string, b []string) []string {
+	result := make([]string, 0)
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				result = append(result, x)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -29,11 +29,13 @@ import (
 	"github.com/loggie-io/loggie/pkg/core/sink"
 	"github.com/loggie-io/loggie/pkg/core/source"
 	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/pkg/errors"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
 )
 
-func LogConfigToPipeline(lgc *logconfigv1beta1.LogConfig, client client.Client) (*control.PipelineConfig, error) {
+func LogConfigToPipeline(lgc *logconfigv1beta1.LogConfig, client client.Reader) (*control.PipelineConfig, error) {
 	pipelineCfg := &control.PipelineConfig{}
 	var pipRaws []pipeline.Config
 	pip := lgc.Spec.Pipeline
@@ -65,7 +67,7 @@ func LogConfigToPipeline(lgc *logconfigv1beta1.LogConfig, client client.Client)
 	return pipelineCfg, nil
 }
 
-func LogConfigToPipelineStr(lgc *logconfigv1beta1.LogConfig, client client.Client) (string, error) {
+func LogConfigToPipelineStr(lgc *logconfigv1beta1.LogConfig, client client.Reader) (string, error) {
 	pipes, err := LogConfigToPipeline(lgc, client)
 	if err != nil {
 		return "", err
@@ -88,19 +90,22 @@ func toPipelineSources(sources string) ([]*source.Config, error) {
 	return sourceCfg, nil
 }
 
-func toPipelineSink(sinkRaw string, sinkRef string, client client.Client) (*sink.Config, error) {
+func toPipelineSink(sinkRaw string, sinkRef string, client client.Reader) (*sink.Config, error) {
 	// we use the sink in logConfig other than sinkRef if sink content is not empty
 	var sinkStr string
 	if sinkRaw != "" {
 		sinkStr = sinkRaw
 	} else {
+		if sinkRef == "" {
+			return nil, errors.New("pipeline has no sink or sinkRef")
+		}
 		sk := logconfigv1beta1.Sink{}
 		err := client.Get(context.Background(), types.NamespacedName{
 			Name: sinkRef,
 		}, &sk)
 		if err != nil {
 			if kerrors.IsNotFound(err) {
-				return nil, nil
+				return nil, errors.Errorf("sink %s not found", sinkRef)
 			}
 			return nil, err
 		}
@@ -117,30 +122,55 @@ func toPipelineSink(sinkRaw string, sinkRef string, client client.Client) (*sink
 	return &sinkConf, nil
 }
 
-func toPipelineInterceptor(interceptorsRaw string, interceptorRef string, client client.Client) ([]*interceptor.Config, error) {
-	var icp string
-	if interceptorsRaw != "" {
-		icp = interceptorsRaw
-	} else {
+// toPipelineInterceptor merges the inline interceptors on top of the referenced Interceptors. interceptorRef is a list
+// of Interceptor names separated by comma, and the former ones take precedence over the latter ones when merging.
+func toPipelineInterceptor(interceptorsRaw string, interceptorRef string, client client.Reader) ([]*interceptor.Config, error) {
+	interConfList, err := unpackInterceptors(interceptorsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range InterceptorRefs(interceptorRef) {
 		intercpt := logconfigv1beta1.Interceptor{}
 		err := client.Get(context.Background(), types.NamespacedName{
-			Name: interceptorRef,
+			Name: ref,
 		}, &intercpt)
 		if err != nil {
 			if kerrors.IsNotFound(err) {
-				return nil, nil
+				return nil, errors.Errorf("interceptor %s not found", ref)
 			}
 			return nil, err
 		}
 
-		icp = intercpt.Spec.Interceptors
+		refList, err := unpackInterceptors(intercpt.Spec.Interceptors)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid interceptor %s", ref)
+		}
+		interConfList = interceptor.MergeInterceptorList(interConfList, refList)
 	}
 
+	return interConfList, nil
+}
+
+// InterceptorRefs splits the interceptorRef of pipeline
+func InterceptorRefs(interceptorRef string) []string {
+	var refs []string
+	for _, ref := range strings.Split(interceptorRef, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func unpackInterceptors(raw string) ([]*interceptor.Config, error) {
 	interConfList := make([]*interceptor.Config, 0)
-	err := cfg.UnPackFromRaw([]byte(icp), &interConfList).Do()
+	if raw == "" {
+		return interConfList, nil
+	}
+	err := cfg.UnPackFromRaw([]byte(raw), &interConfList).Do()
 	if err != nil {
 		return nil, err
 	}
-
 	return interConfList, nil
 }
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func Test_toPipelineInterceptor(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, logconfigv1beta1.AddToScheme(scheme))
+
+	limit := &logconfigv1beta1.Interceptor{}
+	limit.Name = "limit"
+	limit.Spec.Interceptors = `
+- type: rateLimit
+  qps: 1000
+- type: maxbytes
+  maxBytes: 1024`
+	strict := &logconfigv1beta1.Interceptor{}
+	strict.Name = "strict"
+	strict.Spec.Interceptors = `
+- type: rateLimit
+  qps: 10
+  throttle: true`
+	reader, err := NewObjectReader(scheme, limit, strict)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		inline  string
+		ref     string
+		want    map[string]map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "none",
+			want: map[string]map[string]interface{}{},
+		},
+		{
+			name: "inline only",
+			inline: `
+- type: normalize`,
+			want: map[string]map[string]interface{}{
+				"normalize/": {},
+			},
+		},
+		{
+			name: "inline on top of refs",
+			inline: `
+- type: rateLimit
+  qps: 2000`,
+			ref: "limit, strict",
+			want: map[string]map[string]interface{}{
+				"rateLimit/": {"qps": 2000, "throttle": true},
+				"maxbytes/":  {"maxBytes": 1024},
+			},
+		},
+		{
+			name:    "dangling ref",
+			ref:     "limit,missing",
+			wantErr: "interceptor missing not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toPipelineInterceptor(tt.inline, tt.ref, reader)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			uids := make(map[string]map[string]interface{})
+			for _, i := range got {
+				props := make(map[string]interface{})
+				for k, v := range i.Properties {
+					props[k] = v
+				}
+				uids[i.UID()] = props
+			}
+			assert.Equal(t, tt.want, uids)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"strings"
+)
+
+// ObjectReader is a client.Reader serving a fixed set of objects from memory,
+// it is used to run the injector without an apiserver.
+type ObjectReader struct {
+	scheme  *runtime.Scheme
+	objects map[schema.GroupVersionKind][]client.Object
+}
+
+func NewObjectReader(scheme *runtime.Scheme, objs ...client.Object) (*ObjectReader, error) {
+	r := &ObjectReader{
+		scheme:  scheme,
+		objects: make(map[schema.GroupVersionKind][]client.Object),
+	}
+	for _, o := range objs {
+		gvk, err := apiutil.GVKForObject(o, scheme)
+		if err != nil {
+			return nil, err
+		}
+		r.objects[gvk] = append(r.objects[gvk], o)
+	}
+	return r, nil
+}
+
+func (r *ObjectReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range r.objects[gvk] {
+		if o.GetNamespace() != key.Namespace || o.GetName() != key.Name {
+			continue
+		}
+		out := reflect.ValueOf(obj)
+		in := reflect.ValueOf(o.DeepCopyObject())
+		if out.Type() != in.Type() {
+			return errors.Errorf("cannot get %s into %T", gvk.Kind, obj)
+		}
+		out.Elem().Set(in.Elem())
+		return nil
+	}
+
+	return kerrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}, key.Name)
+}
+
+func (r *ObjectReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, r.scheme)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(gvk.Kind, "List") {
+		return errors.Errorf("%s is not a list type", gvk.Kind)
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	var items []runtime.Object
+	for _, o := range r.objects[gvk] {
+		if listOpts.Namespace != "" && o.GetNamespace() != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(o.GetLabels())) {
+			continue
+		}
+		items = append(items, o.DeepCopyObject())
+	}
+
+	return meta.SetList(list, items)
+}
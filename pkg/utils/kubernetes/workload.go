@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+const (
+	KindPod         = "Pod"
+	KindReplicaSet  = "ReplicaSet"
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindJob         = "Job"
+	KindCronJob     = "CronJob"
+)
+
+// Workload is the top level controller of a pod
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// WorkloadOf returns the workload owning the pod. The ReplicaSet created by a Deployment is resolved to the Deployment
+// by the pod-template-hash label, and a pod without controller is a workload of itself.
+func WorkloadOf(pod *corev1.Pod) Workload {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		name := pod.Name
+		if name == "" {
+			name = pod.GenerateName
+		}
+		return Workload{Kind: KindPod, Namespace: pod.Namespace, Name: name}
+	}
+
+	if owner.Kind == KindReplicaSet {
+		hash, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+		if ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			return Workload{Kind: KindDeployment, Namespace: pod.Namespace, Name: strings.TrimSuffix(owner.Name, "-"+hash)}
+		}
+	}
+
+	return Workload{Kind: owner.Kind, Namespace: pod.Namespace, Name: owner.Name}
+}
+
+// PodTemplateOf returns an empty object of the workload to get, and the pod template in it.
+// It returns nil for the workloads without pod template, such as a pod without controller.
+func PodTemplateOf(w Workload) (*corev1.PodTemplateSpec, client.Object) {
+	switch w.Kind {
+	case KindDeployment:
+		o := &appsv1.Deployment{}
+		return &o.Spec.Template, o
+	case KindStatefulSet:
+		o := &appsv1.StatefulSet{}
+		return &o.Spec.Template, o
+	case KindDaemonSet:
+		o := &appsv1.DaemonSet{}
+		return &o.Spec.Template, o
+	}
+	return nil, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// ConfigHash returns the content hash of what is injected to the sidecar, pods with the same hash run the same config
+func ConfigHash(image string, systemConfig string, pipelines string) string {
+	h := sha256.New()
+	for _, s := range []string{image, systemConfig, pipelines} {
+		h.Write([]byte(s))
+		// separator, so the hash of ("ab", "c") is different from ("a", "bc")
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// ConfigRef returns the reference of LogConfig as "LogConfig/{namespace}/{name}".
+// The LogConfig converted from ClusterLogConfig has no namespace, which returns "ClusterLogConfig/{name}".
+func ConfigRef(lgc *logconfigv1beta1.LogConfig) string {
+	if lgc.Namespace == "" {
+		return fmt.Sprintf("%s/%s", KindClusterLogConfig, lgc.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", KindLogConfig, lgc.Namespace, lgc.Name)
+}
+
+// ParseConfigRef parses the reference returned by ConfigRef
+func ParseConfigRef(ref string) (kind string, namespace string, name string, err error) {
+	parts := strings.Split(ref, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == KindLogConfig:
+		return parts[0], parts[1], parts[2], nil
+	case len(parts) == 2 && parts[0] == KindClusterLogConfig:
+		return parts[0], "", parts[1], nil
+	}
+	return "", "", "", errors.Errorf("invalid config reference %s", ref)
+}
+
+// ConfigObjectOf returns an empty LogConfig/ClusterLogConfig with the namespace and name of the reference returned by ConfigRef
+func ConfigObjectOf(ref string) (client.Object, error) {
+	kind, namespace, name, err := ParseConfigRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if kind == KindClusterLogConfig {
+		clgc := &logconfigv1beta1.ClusterLogConfig{}
+		clgc.Name = name
+		return clgc, nil
+	}
+	lgc := &logconfigv1beta1.LogConfig{}
+	lgc.Namespace = namespace
+	lgc.Name = name
+	return lgc, nil
+}
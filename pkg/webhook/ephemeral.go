@@ -0,0 +1,200 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CollectAnnotationKey requests to collect the logs of a running pod by an ephemeral container. The value is
+	// the LogConfig to run: "LogConfig/{name}" in the namespace of pod, "ClusterLogConfig/{name}", or empty for the matched one.
+	CollectAnnotationKey = "sidecar.loggie.io/collect"
+	// CollectPipelineAnnotationKey is the inline pipeline of the request, which takes precedence over CollectAnnotationKey
+	CollectPipelineAnnotationKey = "sidecar.loggie.io/collect-pipeline"
+	// CollectDurationAnnotationKey is how long the ephemeral container runs, such as 30m
+	CollectDurationAnnotationKey = "sidecar.loggie.io/collect-duration"
+
+	EphemeralContainerName = "loggie-collect"
+
+	// timeoutExitCode is the exit code of timeout at expiry
+	timeoutExitCode = 124
+)
+
+// CollectLogConfig resolves the LogConfig requested by the annotations of pod
+func (s *SidecarInjection) CollectLogConfig(ctx context.Context, pod *corev1.Pod) (*logconfigv1beta1.LogConfig, error) {
+	if inline, ok := pod.Annotations[CollectPipelineAnnotationKey]; ok {
+		pipeline := logconfigv1beta1.Pipeline{}
+		if err := yaml.Unmarshal([]byte(inline), &pipeline); err != nil {
+			return nil, errors.WithMessagef(err, "invalid annotation %s", CollectPipelineAnnotationKey)
+		}
+		lgc := &logconfigv1beta1.LogConfig{}
+		lgc.Namespace = pod.Namespace
+		lgc.Name = pod.Name
+		lgc.Spec.Pipeline = &pipeline
+		return lgc, nil
+	}
+
+	ref := pod.Annotations[CollectAnnotationKey]
+	if ref == "" {
+		lgc, _, err := s.getMatchedLogConfig(pod)
+		if err != nil {
+			return nil, err
+		}
+		if lgc == nil {
+			return nil, errors.New("pod does not have a matching LogConfig/ClusterLogConfig")
+		}
+		return lgc, nil
+	}
+
+	parts := strings.SplitN(ref, "/", 2)
+	switch kind, name := parts[0], parts[len(parts)-1]; {
+	case len(parts) != 2:
+	case kind == KindLogConfig:
+		lgc := &logconfigv1beta1.LogConfig{}
+		if err := s.Reader.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: name}, lgc); err != nil {
+			return nil, err
+		}
+		return lgc, nil
+
+	case kind == KindClusterLogConfig && s.IgnoreClusterLogConfigs:
+		return nil, errors.Errorf("invalid annotation %s: %s, ClusterLogConfigs are ignored by the operator", CollectAnnotationKey, ref)
+	case kind == KindClusterLogConfig:
+		clgc := &logconfigv1beta1.ClusterLogConfig{}
+		if err := s.Reader.Get(ctx, types.NamespacedName{Name: name}, clgc); err != nil {
+			return nil, err
+		}
+		return clgc.ToLogConfig(), nil
+	}
+	return nil, errors.Errorf("invalid annotation %s: %s, should be LogConfig/{name} or ClusterLogConfig/{name}", CollectAnnotationKey, ref)
+}
+
+// EphemeralSidecar returns the ephemeral container named name running the pipelines of lgc for duration, which mounts
+// the existing volumes of the pod covering the log paths. Ephemeral containers cannot be removed from a pod, so the
+// command is wrapped by Ephemeral.Timeout to stop it at expiry.
+func (s *SidecarInjection) EphemeralSidecar(pod *corev1.Pod, name string, lgc *logconfigv1beta1.LogConfig, duration time.Duration) (*corev1.EphemeralContainer, error) {
+	if len(s.Config.Ephemeral.Timeout) == 0 {
+		return nil, errors.New("ephemeral.timeout is empty")
+	}
+	// timeout 0 never stops the command
+	if duration < time.Second {
+		return nil, errors.Errorf("duration %s is less than 1s", duration)
+	}
+	if lgc.Spec.Pipeline == nil {
+		return nil, errors.New("pipeline is empty")
+	}
+	paths, err := retrievePathsFromSource(lgc.Spec.Pipeline.Sources, false)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := coveringVolumeMounts(pod, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	pipes, err := RenderPipelines(s.Config, lgc, pod.Namespace, s.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ephemeral container shares the network of the pod, including the injected sidecar
+	systemConfig, err := EffectiveSystemConfig(s.Config, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	var command []string
+	command = append(command, s.Config.Ephemeral.Timeout...)
+	command = append(command, strconv.Itoa(int(duration.Seconds())))
+	command = append(command, s.Config.Ephemeral.Command...)
+	command = append(command,
+		"-config.from=env",
+		fmt.Sprintf("-config.system=%s", EnvKeySystem),
+		fmt.Sprintf("-config.pipeline=%s", EnvKeyPipeline),
+	)
+
+	return &corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:         name,
+			Image:        PodImage(s.Config, pod),
+			Command:      command,
+			Env:          configEnvs(pipes, systemConfig),
+			VolumeMounts: mounts,
+		},
+	}, nil
+}
+
+// EphemeralExited returns whether the ephemeral container was stopped by timeout at expiry, or the reason it exited.
+// Exit code 126 and 127 mean the image does not provide Ephemeral.Timeout or Ephemeral.Command.
+func (s *SidecarInjection) EphemeralExited(terminated *corev1.ContainerStateTerminated) (expired bool, reason string) {
+	switch terminated.ExitCode {
+	case timeoutExitCode:
+		return true, ""
+	case 126, 127:
+		return false, fmt.Sprintf("ephemeral container exited with code %d, the image must provide %q and %q",
+			terminated.ExitCode, strings.Join(s.Config.Ephemeral.Timeout, " "), strings.Join(s.Config.Ephemeral.Command, " "))
+	}
+	return false, fmt.Sprintf("ephemeral container exited with code %d: %s", terminated.ExitCode, terminated.Reason)
+}
+
+// coveringVolumeMounts returns the volumeMounts of app containers whose mount path contains the log paths.
+// Ephemeral containers cannot add volumes, so the logs must be in the existing volumes.
+func coveringVolumeMounts(pod *corev1.Pod, paths []string) ([]corev1.VolumeMount, error) {
+	var mounts []corev1.VolumeMount
+	used := make(map[string]bool)
+	for _, p := range paths {
+		m, ok := volumeMountCovering(pod, p)
+		if !ok {
+			return nil, errors.Errorf("log path %s is not in any volume of the pod", p)
+		}
+		if used[m.MountPath] {
+			continue
+		}
+		used[m.MountPath] = true
+		m.ReadOnly = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+func volumeMountCovering(pod *corev1.Pod, path string) (corev1.VolumeMount, bool) {
+	var found corev1.VolumeMount
+	var ok bool
+	for _, c := range pod.Spec.Containers {
+		for _, m := range c.VolumeMounts {
+			rel, err := filepath.Rel(m.MountPath, path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+				continue
+			}
+			// the deepest mount wins
+			if !ok || len(m.MountPath) > len(found.MountPath) {
+				found, ok = m, true
+			}
+		}
+	}
+	return found, ok
+}
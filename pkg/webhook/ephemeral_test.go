@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func Test_coveringVolumeMounts(t *testing.T) {
+	pod := testPod()
+	pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "logs", MountPath: "/usr/local/tomcat/logs"},
+		{Name: "data", MountPath: "/data"},
+		{Name: "data-logs", MountPath: "/data/logs"},
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "covered",
+			paths: []string{"/usr/local/tomcat/logs/*.log", "/usr/local/tomcat/logs/access/*.log"},
+			want:  []string{"logs"},
+		},
+		{
+			name:  "deepest mount",
+			paths: []string{"/data/logs/*.log", "/data/app.log"},
+			want:  []string{"data-logs", "data"},
+		},
+		{
+			name:    "not in volumes",
+			paths:   []string{"/var/log/*.log"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts, err := coveringVolumeMounts(pod, tt.paths)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			var names []string
+			for _, m := range mounts {
+				assert.True(t, m.ReadOnly)
+				names = append(names, m.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestSidecarInjection_EphemeralExited(t *testing.T) {
+	s := &SidecarInjection{Config: &config.Sidecar{Ephemeral: config.Ephemeral{Timeout: []string{"timeout"}, Command: []string{"/loggie"}}}}
+
+	tests := []struct {
+		name        string
+		terminated  corev1.ContainerStateTerminated
+		wantExpired bool
+		wantReason  string
+	}{
+		{
+			name:        "stopped by timeout",
+			terminated:  corev1.ContainerStateTerminated{ExitCode: 124},
+			wantExpired: true,
+		},
+		{
+			name:       "timeout not found",
+			terminated: corev1.ContainerStateTerminated{ExitCode: 127, Reason: "Error"},
+			wantReason: `ephemeral container exited with code 127, the image must provide "timeout" and "/loggie"`,
+		},
+		{
+			name:       "failed",
+			terminated: corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"},
+			wantReason: "ephemeral container exited with code 1: Error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, reason := s.EphemeralExited(&tt.terminated)
+			assert.Equal(t, tt.wantExpired, expired)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+
+	// timeout 0 never stops
+	_, err := s.EphemeralSidecar(testPod(), EphemeralContainerName, &logconfigv1beta1.LogConfig{}, 500*time.Millisecond)
+	assert.EqualError(t, err, "duration 500ms is less than 1s")
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+const (
+	// ExpiresAtAnnotationKey is the time in RFC3339 after which the LogConfig/ClusterLogConfig is not injected any more
+	ExpiresAtAnnotationKey = "sidecar.loggie.io/expires-at"
+	// TTLAnnotationKey expires the LogConfig/ClusterLogConfig the duration after it is created, such as 4h
+	TTLAnnotationKey = "sidecar.loggie.io/ttl"
+)
+
+// ExpiresAt returns the expiry of the LogConfig/ClusterLogConfig, and false if it never expires.
+// ExpiresAtAnnotationKey takes precedence over TTLAnnotationKey.
+func ExpiresAt(obj metav1.Object) (time.Time, bool, error) {
+	if at, ok := obj.GetAnnotations()[ExpiresAtAnnotationKey]; ok {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return time.Time{}, false, errors.WithMessagef(err, "invalid annotation %s", ExpiresAtAnnotationKey)
+		}
+		return t, true, nil
+	}
+
+	if ttl, ok := obj.GetAnnotations()[TTLAnnotationKey]; ok {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return time.Time{}, false, errors.WithMessagef(err, "invalid annotation %s", TTLAnnotationKey)
+		}
+		return obj.GetCreationTimestamp().Add(d), true, nil
+	}
+	return time.Time{}, false, nil
+}
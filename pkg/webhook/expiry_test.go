@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestExpiresAt(t *testing.T) {
+	created := time.Date(2023, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        time.Time
+		wantOk      bool
+		wantErr     bool
+	}{
+		{
+			name: "never expires",
+		},
+		{
+			name:        "expires at",
+			annotations: map[string]string{ExpiresAtAnnotationKey: "2023-03-04T16:00:00Z", TTLAnnotationKey: "1h"},
+			want:        time.Date(2023, 3, 4, 16, 0, 0, 0, time.UTC),
+			wantOk:      true,
+		},
+		{
+			name:        "ttl",
+			annotations: map[string]string{TTLAnnotationKey: "4h"},
+			want:        created.Add(4 * time.Hour),
+			wantOk:      true,
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{TTLAnnotationKey: "4 hours"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := &metav1.ObjectMeta{Annotations: tt.annotations, CreationTimestamp: metav1.NewTime(created)}
+			got, ok, err := ExpiresAt(meta)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.True(t, tt.want.Equal(got))
+		})
+	}
+}
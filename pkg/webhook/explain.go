@@ -0,0 +1,194 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	KindLogConfig        = "LogConfig"
+	KindClusterLogConfig = "ClusterLogConfig"
+)
+
+// Explanation tells how the sidecar would be injected to a pod
+type Explanation struct {
+	Inject       bool
+	InjectReason string
+
+	// Configs are all the LogConfigs and ClusterLogConfigs, in the order they are matched by the injector.
+	// Selected is the first matched one.
+	Configs  []ConfigMatch
+	Selected *ConfigMatch
+
+	// Error is the reason why the selected config cannot be injected
+	Error    string
+	Pipeline string
+	Volumes  []VolumePlan
+}
+
+type ConfigMatch struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Priority  int
+	Matched   bool
+	Reasons   []string
+}
+
+// VolumePlan is a log path mounted to the sidecar
+type VolumePlan struct {
+	MountPath string
+	Volume    string
+	// Injected is false if the volume belongs to the pod already
+	Injected   bool
+	Containers []string
+}
+
+// Explain runs the injection against a copy of the pod, and records the decision of each step.
+// The pod would be uninjected first if it has been injected.
+func (s *SidecarInjection) Explain(pod *corev1.Pod) (*Explanation, error) {
+	origin := pod.DeepCopy()
+	if _, err := Uninject(origin); err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{}
+	e.Inject, e.InjectReason = checkInjectWithReason(origin.ObjectMeta, s.Config.IgnoreNamespaces)
+
+	matches, err := s.matchConfigs(context.Background(), origin)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		e.Configs = append(e.Configs, m.ConfigMatch)
+	}
+	for i := range e.Configs {
+		if e.Configs[i].Matched {
+			e.Selected = &e.Configs[i]
+			break
+		}
+	}
+
+	if !e.Inject || e.Selected == nil {
+		return e, nil
+	}
+
+	mutated := origin.DeepCopy()
+	if err := s.AddPodSidecar(mutated); err != nil {
+		e.Error = err.Error()
+		return e, nil
+	}
+	e.Pipeline = InjectedPipeline(mutated)
+	e.Volumes = volumePlans(mutated)
+
+	return e, nil
+}
+
+// InjectedPipeline returns the pipelines config of the injected sidecar
+func InjectedPipeline(pod *corev1.Pod) string {
+	return injectedEnv(pod, EnvKeyPipeline)
+}
+
+// InjectedSystemConfig returns the system config of the injected sidecar
+func InjectedSystemConfig(pod *corev1.Pod) string {
+	return injectedEnv(pod, EnvKeySystem)
+}
+
+// InjectedImage returns the sidecar image of the pod, or empty if the pod is not injected.
+// Pods injected before the image annotation is introduced are resolved by the image of their sidecar container.
+func InjectedImage(pod *corev1.Pod) string {
+	if image := pod.Annotations[ImageAnnotationKey]; image != "" {
+		return image
+	}
+	if c := injectedSidecar(pod); c != nil {
+		return c.Image
+	}
+	return ""
+}
+
+// InjectedSidecarStatus returns the container status of the injected sidecar
+func InjectedSidecarStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	c := injectedSidecar(pod)
+	if c == nil {
+		return nil
+	}
+	for i, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == c.Name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+func injectedEnv(pod *corev1.Pod, key string) string {
+	c := injectedSidecar(pod)
+	if c == nil {
+		return ""
+	}
+	for _, env := range c.Env {
+		if env.Name == key {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func injectedSidecar(pod *corev1.Pod) *corev1.Container {
+	status, err := GetInjectedStatus(pod)
+	if err != nil || status == nil {
+		return nil
+	}
+
+	for i, c := range pod.Spec.Containers {
+		if contains(status.Containers, c.Name) {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func volumePlans(pod *corev1.Pod) []VolumePlan {
+	status, err := GetInjectedStatus(pod)
+	sidecar := injectedSidecar(pod)
+	if err != nil || sidecar == nil {
+		return nil
+	}
+
+	var plans []VolumePlan
+	for _, m := range sidecar.VolumeMounts {
+		plan := VolumePlan{
+			MountPath: m.MountPath,
+			Volume:    m.Name,
+			Injected:  contains(status.Volumes, m.Name),
+		}
+		for _, c := range pod.Spec.Containers {
+			if c.Name == sidecar.Name {
+				continue
+			}
+			for _, cm := range c.VolumeMounts {
+				if cm.Name == m.Name {
+					plan.Containers = append(plan.Containers, c.Name)
+					break
+				}
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans
+}
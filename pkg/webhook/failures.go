@@ -0,0 +1,181 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
+	"time"
+)
+
+const (
+	// InjectionFailureAnnotationKey is the last injection failure of a LogConfig/ClusterLogConfig, see Failure
+	InjectionFailureAnnotationKey = "sidecar.loggie.io/injection-failure"
+
+	EventReasonInjectionFailed = "InjectionFailed"
+)
+
+// Failure is the last injection failure of a LogConfig/ClusterLogConfig
+type Failure struct {
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+	// Generation is of the config failed, the failure is outdated once the config is updated
+	Generation int64 `json:"generation"`
+}
+
+// FailureOf returns the last injection failure of the current generation of the LogConfig/ClusterLogConfig
+func FailureOf(obj client.Object) (Failure, bool) {
+	data, ok := obj.GetAnnotations()[InjectionFailureAnnotationKey]
+	if !ok {
+		return Failure{}, false
+	}
+	failure := Failure{}
+	if err := json.Unmarshal([]byte(data), &failure); err != nil || failure.Generation != obj.GetGeneration() {
+		return Failure{}, false
+	}
+	return failure, true
+}
+
+// Failures records the injection failures of LogConfigs/ClusterLogConfigs in the webhook of every replica. A failure
+// is counted in the metrics at once, while the event of the config and its annotation, which is reported in the status
+// by the leader, are written in the background at most once per Interval for each config, with its last failure.
+type Failures struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	// Interval coalesces the failures of a config, 10s by default
+	Interval time.Duration
+
+	queue   workqueue.RateLimitingInterface
+	lock    sync.Mutex
+	pending map[string]string
+}
+
+// NewFailures returns the Failures, which writes the failures after it is started by the manager
+func NewFailures(cli client.Client, recorder record.EventRecorder) *Failures {
+	return &Failures{
+		Client:   cli,
+		Recorder: recorder,
+		Interval: 10 * time.Second,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "injection-failures"),
+		pending:  make(map[string]string),
+	}
+}
+
+//+kubebuilder:rbac:groups=loggie.io,resources=logconfigs;clusterlogconfigs,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Record records the failure to inject the LogConfig, or the ClusterLogConfig it is converted from, without blocking
+// the admission. It does nothing if f is nil.
+func (f *Failures) Record(lgc *logconfigv1beta1.LogConfig, err error) {
+	if f == nil {
+		return
+	}
+	ref := ConfigRef(lgc)
+	kind, namespace, name, _ := ParseConfigRef(ref)
+	metrics.InjectionFailures.WithLabelValues(kind, namespace, name).Inc()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.pending[ref]; !ok {
+		f.queue.AddAfter(ref, f.Interval)
+	}
+	f.pending[ref] = err.Error()
+}
+
+// Start writes the failures until the context is done
+func (f *Failures) Start(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		f.queue.ShutDown()
+	}()
+	for f.processNext(ctx) {
+	}
+	return nil
+}
+
+// NeedLeaderElection is false, since every replica serves the webhook
+func (f *Failures) NeedLeaderElection() bool {
+	return false
+}
+
+// processNext writes the last failure of the next config, and retries it with backoff if failed
+func (f *Failures) processNext(ctx context.Context) bool {
+	item, shutdown := f.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer f.queue.Done(item)
+
+	ref := item.(string)
+	f.lock.Lock()
+	reason, ok := f.pending[ref]
+	delete(f.pending, ref)
+	f.lock.Unlock()
+	if !ok {
+		return true
+	}
+
+	if err := f.write(ctx, ref, reason); err != nil {
+		log.Warn("record injection failure of %s failed: %v", ref, err)
+		f.lock.Lock()
+		if _, ok := f.pending[ref]; !ok {
+			f.pending[ref] = reason
+			f.queue.AddRateLimited(ref)
+		}
+		f.lock.Unlock()
+		return true
+	}
+	f.queue.Forget(ref)
+	return true
+}
+
+// write emits the event of the failure, and writes it to the annotation of the config if the reason changes
+func (f *Failures) write(ctx context.Context, ref string, reason string) error {
+	obj, err := ConfigObjectOf(ref)
+	if err != nil {
+		return nil
+	}
+	if err := f.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	f.Recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonInjectionFailed, "inject sidecar failed: %s", reason)
+
+	// the annotation is written once while the same failure repeats, so the status is not updated by every pod
+	if last, ok := FailureOf(obj); ok && last.Reason == reason {
+		return nil
+	}
+	data, err := json.Marshal(Failure{Reason: reason, Time: time.Now().UTC().Truncate(time.Second), Generation: obj.GetGeneration()})
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[InjectionFailureAnnotationKey] = string(data)
+	obj.SetAnnotations(annotations)
+	return f.Client.Patch(ctx, obj, patch)
+}
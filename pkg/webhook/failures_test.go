@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+// failurePatcher gets the configs from the reader, and records the failures patched
+type failurePatcher struct {
+	client.Client
+	reader  client.Reader
+	patched []string
+}
+
+func (p *failurePatcher) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return p.reader.Get(ctx, key, obj)
+}
+
+func (p *failurePatcher) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	failure, _ := FailureOf(obj)
+	p.patched = append(p.patched, obj.GetNamespace()+"/"+obj.GetName()+": "+failure.Reason)
+	return nil
+}
+
+func TestFailures_Record(t *testing.T) {
+	log.InitDefaultLogger()
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, logconfigv1beta1.AddToScheme(scheme))
+	tomcat := &logconfigv1beta1.LogConfig{}
+	tomcat.Namespace = "default"
+	tomcat.Name = "tomcat"
+	reader, err := kubernetes.NewObjectReader(scheme, tomcat)
+	assert.NoError(t, err)
+	cli := &failurePatcher{reader: reader}
+	recorder := record.NewFakeRecorder(10)
+
+	f := NewFailures(cli, recorder)
+	f.Interval = 0
+	deleted := &logconfigv1beta1.LogConfig{}
+	deleted.Namespace = "default"
+	deleted.Name = "deleted"
+
+	// the failures of a config are coalesced to the last one
+	f.Record(tomcat, errors.New("no sink"))
+	f.Record(tomcat, errors.New("no sink"))
+	f.Record(tomcat, errors.New("invalid pipelines"))
+	f.Record(deleted, errors.New("no sink"))
+	assert.Equal(t, 2, f.queue.Len())
+
+	assert.True(t, f.processNext(context.Background()))
+	assert.True(t, f.processNext(context.Background()))
+	assert.Equal(t, []string{"default/tomcat: invalid pipelines"}, cli.patched)
+	assert.Len(t, recorder.Events, 1)
+	assert.Equal(t, 0, f.queue.Len())
+
+	var nilFailures *Failures
+	nilFailures.Record(tomcat, errors.New("no sink"))
+}
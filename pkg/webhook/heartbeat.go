@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"github.com/loggie-io/operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"path/filepath"
+)
+
+const (
+	ReporterInitContainerName = "loggie-reporter"
+	ReporterVolumeName        = "loggie-reporter"
+	ReporterPath              = "/loggie-reporter"
+	// ReporterImagePath is where loggie-reporter is in heartbeat.image
+	ReporterImagePath = "/usr/local/bin/loggie-reporter"
+
+	// ReporterTokenVolumeName is the ServiceAccount token of the pod projected with heartbeat.audience,
+	// which authenticates the reports
+	ReporterTokenVolumeName = "loggie-reporter-token"
+	ReporterTokenPath       = "/var/run/secrets/loggie.io/heartbeat"
+	reporterTokenExpiration = 3600
+
+	EnvKeyPodName      = "POD_NAME"
+	EnvKeyPodNamespace = "POD_NAMESPACE"
+)
+
+// wrapReporter runs the sidecar by loggie-reporter, which reports the pipeline metrics of the sidecar to the operator
+func wrapReporter(pod *corev1.Pod, sidecar *corev1.Container, conf *config.Heartbeat, status *InjectedStatus) {
+	volName := uniqueVolumeName(pod, ReporterVolumeName)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         volName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	status.Volumes = append(status.Volumes, volName)
+
+	initContainer := corev1.Container{
+		Name:    uniqueContainerName(pod, ReporterInitContainerName),
+		Image:   conf.Image,
+		Command: []string{ReporterImagePath, "-install", ReporterPath},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: volName, MountPath: ReporterPath},
+		},
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
+	status.InitContainers = append(status.InitContainers, initContainer.Name)
+
+	tokenVolName := uniqueVolumeName(pod, ReporterTokenVolumeName)
+	expiration := int64(reporterTokenExpiration)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: tokenVolName,
+		VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{{
+				ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Audience: conf.Audience, ExpirationSeconds: &expiration, Path: "token"},
+			}},
+		}},
+	})
+	status.Volumes = append(status.Volumes, tokenVolName)
+
+	command := []string{
+		filepath.Join(ReporterPath, filepath.Base(ReporterImagePath)),
+		"-url", conf.URL,
+		"-interval", conf.Interval.String(),
+		"-token-file", filepath.Join(ReporterTokenPath, "token"),
+	}
+	if port := metricsPort(sidecar); port != 0 && port != DefaultHTTPPort {
+		command = append(command, "-metrics-url", fmt.Sprintf("http://127.0.0.1:%d%s", port, MetricsPath))
+	}
+	if conf.InsecureSkipVerify {
+		command = append(command, "-insecure-skip-verify")
+	}
+	command = append(command, "--")
+	sidecar.Command = append(command, conf.Command...)
+
+	sidecar.Env = append(sidecar.Env,
+		corev1.EnvVar{
+			Name:      EnvKeyPodName,
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+		},
+		corev1.EnvVar{
+			Name:      EnvKeyPodNamespace,
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+		},
+	)
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts,
+		corev1.VolumeMount{Name: volName, MountPath: ReporterPath, ReadOnly: true},
+		corev1.VolumeMount{Name: tokenVolName, MountPath: ReporterTokenPath, ReadOnly: true},
+	)
+}
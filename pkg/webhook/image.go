@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"hash/fnv"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodImage returns the sidecar image injected to the pod, which is the image pinned by the pod, or ImageOf
+func PodImage(conf *config.Sidecar, pod *corev1.Pod) string {
+	if image := pod.Annotations[PinnedImageAnnotationKey]; image != "" {
+		return image
+	}
+	return ImageOf(conf, pod)
+}
+
+// ImageOf returns the sidecar image in the configuration for the pod, which is the canary image if the pod is in canary
+func ImageOf(conf *config.Sidecar, pod *corev1.Pod) string {
+	if inCanary(conf.Canary, pod) {
+		return conf.Canary.Image
+	}
+	return conf.Image
+}
+
+func inCanary(canary *config.Canary, pod *corev1.Pod) bool {
+	if canary == nil || canary.Image == "" {
+		return false
+	}
+
+	for _, ns := range canary.Namespaces {
+		if pod.Namespace == ns {
+			return true
+		}
+	}
+	if len(canary.LabelSelector) > 0 && kubernetes.LabelsSubset(canary.LabelSelector, pod.Labels) {
+		return true
+	}
+	return canaryBucket(kubernetes.WorkloadOf(pod)) < canary.Percentage
+}
+
+// canaryBucket hashes the workload to [0, 100), so all the pods of the workload are in the same bucket
+func canaryBucket(w kubernetes.Workload) int {
+	h := fnv.New32a()
+	h.Write([]byte(w.String()))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestPodImage(t *testing.T) {
+	pod := func(namespace string, labels map[string]string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   namespace,
+				Name:        "app-0",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		canary *config.Canary
+		pod    *corev1.Pod
+		want   string
+	}{
+		{
+			name: "no canary",
+			pod:  pod("default", nil, nil),
+			want: "loggie:stable",
+		},
+		{
+			name:   "canary namespace",
+			canary: &config.Canary{Image: "loggie:canary", Namespaces: []string{"test"}},
+			pod:    pod("test", nil, nil),
+			want:   "loggie:canary",
+		},
+		{
+			name:   "canary label selector",
+			canary: &config.Canary{Image: "loggie:canary", LabelSelector: map[string]string{"canary": "true"}},
+			pod:    pod("default", map[string]string{"app": "app", "canary": "true"}, nil),
+			want:   "loggie:canary",
+		},
+		{
+			name:   "not in canary",
+			canary: &config.Canary{Image: "loggie:canary", Namespaces: []string{"test"}, LabelSelector: map[string]string{"canary": "true"}},
+			pod:    pod("default", map[string]string{"app": "app"}, nil),
+			want:   "loggie:stable",
+		},
+		{
+			name:   "all in percentage",
+			canary: &config.Canary{Image: "loggie:canary", Percentage: 100},
+			pod:    pod("default", nil, nil),
+			want:   "loggie:canary",
+		},
+		{
+			name:   "pinned",
+			canary: &config.Canary{Image: "loggie:canary", Percentage: 100},
+			pod:    pod("default", nil, map[string]string{PinnedImageAnnotationKey: "loggie:old"}),
+			want:   "loggie:old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Sidecar{Image: "loggie:stable", Canary: tt.canary}
+			assert.Equal(t, tt.want, PodImage(conf, tt.pod))
+		})
+	}
+}
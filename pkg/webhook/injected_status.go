@@ -0,0 +1,232 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InjectedStatus records everything the injector added to a pod, so that the
+// injection can be repeated or reverted without touching user defined fields.
+type InjectedStatus struct {
+	Containers     []string `json:"containers,omitempty"`
+	InitContainers []string `json:"initContainers,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+	// VolumeMounts are the mounts added to the app containers, keyed by container name
+	VolumeMounts map[string][]string `json:"volumeMounts,omitempty"`
+	Annotations  []string            `json:"annotations,omitempty"`
+	Labels       []string            `json:"labels,omitempty"`
+	// Commands are the original command and args of the app containers wrapped to capture stdout, keyed by container name
+	Commands map[string]Command `json:"commands,omitempty"`
+}
+
+type Command struct {
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// GetInjectedStatus returns the injected status recorded in the pod annotations, or nil if the pod was not injected.
+func GetInjectedStatus(pod *corev1.Pod) (*InjectedStatus, error) {
+	raw, ok := pod.Annotations[InjectedStatusAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	status := &InjectedStatus{}
+	if err := json.Unmarshal([]byte(raw), status); err != nil {
+		return nil, errors.WithMessagef(err, "invalid annotation %s", InjectedStatusAnnotationKey)
+	}
+	return status, nil
+}
+
+func setInjectedStatus(pod *corev1.Pod, status *InjectedStatus) error {
+	out, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[InjectedStatusAnnotationKey] = string(out)
+	return nil
+}
+
+// Uninject removes the containers, volumes, volumeMounts, labels and annotations recorded in the injected status annotation,
+// and returns false if the pod was not injected.
+// The annotation may be stale, such as in a pod template copied from an injected pod and edited later, so an entry is undone
+// only if it still looks injected: the containers with the injected image or the command of the injected init containers,
+// the volumes with the emptyDir or projected token source, and the commands wrapped by loggie-tee.
+func Uninject(pod *corev1.Pod) (bool, error) {
+	status, err := GetInjectedStatus(pod)
+	if err != nil {
+		return false, err
+	}
+	if status == nil {
+		return false, nil
+	}
+
+	removedVolumes := make(map[string]bool)
+	volumes := pod.Spec.Volumes[:0]
+	for _, v := range pod.Spec.Volumes {
+		if contains(status.Volumes, v.Name) && injectedVolume(v) {
+			removedVolumes[v.Name] = true
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	pod.Spec.Volumes = volumes
+	if len(pod.Spec.Volumes) == 0 {
+		pod.Spec.Volumes = nil
+	}
+
+	image := pod.Annotations[ImageAnnotationKey]
+	containers := pod.Spec.Containers[:0]
+	for _, c := range pod.Spec.Containers {
+		if contains(status.Containers, c.Name) && image != "" && c.Image == image {
+			continue
+		}
+
+		var mounts []string
+		for _, name := range status.VolumeMounts[c.Name] {
+			if removedVolumes[name] {
+				mounts = append(mounts, name)
+			}
+		}
+		if len(mounts) > 0 {
+			c.VolumeMounts = removeVolumeMounts(c.VolumeMounts, mounts)
+		}
+		if command, ok := status.Commands[c.Name]; ok && len(c.Command) > 0 && c.Command[0] == teeCommand {
+			c.Command, c.Args = command.Command, command.Args
+		}
+		containers = append(containers, c)
+	}
+	pod.Spec.Containers = containers
+
+	if len(status.InitContainers) > 0 {
+		initContainers := pod.Spec.InitContainers[:0]
+		for _, c := range pod.Spec.InitContainers {
+			if contains(status.InitContainers, c.Name) && len(c.Command) > 0 && (c.Command[0] == TeeImagePath || c.Command[0] == ReporterImagePath) {
+				continue
+			}
+			initContainers = append(initContainers, c)
+		}
+		pod.Spec.InitContainers = initContainers
+		if len(pod.Spec.InitContainers) == 0 {
+			pod.Spec.InitContainers = nil
+		}
+	}
+
+	for _, k := range status.Annotations {
+		delete(pod.Annotations, k)
+	}
+	for _, k := range status.Labels {
+		delete(pod.Labels, k)
+	}
+	if len(pod.Labels) == 0 {
+		pod.Labels = nil
+	}
+	delete(pod.Annotations, InjectedStatusAnnotationKey)
+	if len(pod.Annotations) == 0 {
+		pod.Annotations = nil
+	}
+	return true, nil
+}
+
+// injectedVolume tells if the volume has the source of the injected ones, an emptyDir or a projected service account token
+func injectedVolume(v corev1.Volume) bool {
+	if v.EmptyDir != nil {
+		return true
+	}
+	if v.Projected == nil || len(v.Projected.Sources) != 1 {
+		return false
+	}
+	return v.Projected.Sources[0].ServiceAccountToken != nil
+}
+
+func removeVolumeMounts(mounts []corev1.VolumeMount, names []string) []corev1.VolumeMount {
+	var res []corev1.VolumeMount
+	for _, m := range mounts {
+		if contains(names, m.Name) {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func (s *InjectedStatus) addVolumeMount(container string, name string) {
+	if s.VolumeMounts == nil {
+		s.VolumeMounts = make(map[string][]string)
+	}
+	s.VolumeMounts[container] = append(s.VolumeMounts[container], name)
+}
+
+func (s *InjectedStatus) addCommand(c *corev1.Container) {
+	if s.Commands == nil {
+		s.Commands = make(map[string]Command)
+	}
+	s.Commands[c.Name] = Command{Command: c.Command, Args: c.Args}
+}
+
+// uniqueContainerName returns name, or name with the smallest numeric suffix that is not used by the pod
+func uniqueContainerName(pod *corev1.Pod, name string) string {
+	used := make(map[string]bool)
+	for _, c := range pod.Spec.InitContainers {
+		used[c.Name] = true
+	}
+	for _, c := range pod.Spec.Containers {
+		used[c.Name] = true
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		used[c.Name] = true
+	}
+	return uniqueName(used, name)
+}
+
+// uniqueVolumeName returns name, or name with the smallest numeric suffix that is not used by the pod
+func uniqueVolumeName(pod *corev1.Pod, name string) string {
+	used := make(map[string]bool)
+	for _, v := range pod.Spec.Volumes {
+		used[v.Name] = true
+	}
+	return uniqueName(used, name)
+}
+
+func uniqueName(used map[string]bool, name string) string {
+	if !used[name] {
+		return name
+	}
+	for i := 1; ; i++ {
+		n := fmt.Sprintf("%s-%d", name, i)
+		if !used[n] {
+			return n
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// LogClusterAnnotationKey of LogConfig/ClusterLogConfig sends the logs to the aggregators of the LogCluster,
+// which is {name} in the namespace of LogConfig, or {namespace}/{name}
+const LogClusterAnnotationKey = "sidecar.loggie.io/log-cluster"
+
+// LogClusterOf returns the LogCluster referred by the annotation of LogConfig/ClusterLogConfig in the namespace, and false if there is none
+func LogClusterOf(annotations map[string]string, namespace string) (types.NamespacedName, bool, error) {
+	ref, ok := annotations[LogClusterAnnotationKey]
+	if !ok {
+		return types.NamespacedName{}, false, nil
+	}
+
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 {
+		return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, true, nil
+	}
+	if namespace == "" {
+		return types.NamespacedName{}, false, errors.Errorf("invalid annotation %s: %s, ClusterLogConfig refers to LogCluster as {namespace}/{name}", LogClusterAnnotationKey, ref)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: ref}, true, nil
+}
+
+// logClusterSink returns the grpc sink sending to the Service of the LogCluster
+func logClusterSink(ctx context.Context, key types.NamespacedName, reader client.Reader) (string, error) {
+	lc := &operatorv1beta1.LogCluster{}
+	if err := reader.Get(ctx, key, lc); err != nil {
+		return "", errors.WithMessagef(err, "get LogCluster %s", key)
+	}
+	return fmt.Sprintf("type: grpc\nhost: %s", lc.Endpoint()), nil
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/loggie-io/loggie/pkg/core/log"
 	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
 	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/metrics"
 	"github.com/loggie-io/operator/pkg/utils/files"
 	"github.com/loggie-io/operator/pkg/utils/kubernetes"
 	"github.com/pkg/errors"
@@ -32,13 +33,29 @@ import (
 	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
 )
 
 const (
 	InjectorAnnotationKey       = "sidecar.loggie.io/inject"
 	InjectorAnnotationValueTrue = "true"
 
+	// InjectedStatusAnnotationKey records the containers and volumes added by the injector
+	InjectedStatusAnnotationKey = "sidecar.loggie.io/status"
+
+	// ConfigAnnotationKey is the LogConfig/ClusterLogConfig injected to the pod, see ConfigRef
+	ConfigAnnotationKey = "sidecar.loggie.io/config"
+	// ConfigHashAnnotationKey is the hash of the sidecar image, system config and pipelines injected to the pod
+	ConfigHashAnnotationKey = "sidecar.loggie.io/config-hash"
+	// ImageAnnotationKey is the sidecar image injected to the pod
+	ImageAnnotationKey = "sidecar.loggie.io/image"
+	// PinnedImageAnnotationKey in the pod template overrides the sidecar image in the configuration,
+	// it is set when the upgrade of the workload is rolled back
+	PinnedImageAnnotationKey = "sidecar.loggie.io/pinned-image"
+
 	SidecarContainerName = "loggie"
+	RegistryVolumeName   = "loggie-registry"
+	LogVolumeNamePrefix  = "loggie-logs-"
 
 	EnvKeySystem   = "loggie_config"
 	EnvKeyPipeline = "pipeline_config"
@@ -46,25 +63,49 @@ const (
 
 type SidecarInjection struct {
 	Config *config.Sidecar
-	client.Client
+	// Store is the reloadable configuration of the operator, Config is taken from it for each request if it is set
+	Store *config.Store
+	// Failures records the failures to inject the matched LogConfigs/ClusterLogConfigs if it is set
+	Failures *Failures
+	// Namespaces are where the pods are injected, empty for all namespaces
+	Namespaces []string
+	// IgnoreClusterLogConfigs matches the LogConfigs only, when the operator cannot read ClusterLogConfigs
+	IgnoreClusterLogConfigs bool
+	client.Reader
 	decoder *admission.Decoder
 }
 
 func (s *SidecarInjection) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if s.Store != nil {
+		snapshot := *s
+		snapshot.Config = s.Store.Load().Sidecar
+		snapshot.Store = nil
+		return snapshot.Handle(ctx, req)
+	}
+
 	pod := &corev1.Pod{}
 
 	err := s.decoder.Decode(req, pod)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
 
 	if !CheckInject(pod.ObjectMeta, s.Config.IgnoreNamespaces) {
 		return admission.Allowed("allowed but would not inject Loggie sidecar")
 	}
+	if len(s.Namespaces) > 0 && !contains(s.Namespaces, pod.Namespace) {
+		return admission.Allowed(fmt.Sprintf("allowed but would not inject Loggie sidecar, namespace %s is not watched", pod.Namespace))
+	}
 
 	mutatePod := pod.DeepCopy()
 	lgc, paths, err := s.getMatchedLogConfig(mutatePod)
 	if err != nil {
+		if lgc != nil {
+			s.Failures.Record(lgc, err)
+		}
 		w := fmt.Sprintf("cannot get Pod(%s/%s) matched LogConfig/ClusterLogConfig: %v", mutatePod.Namespace, mutatePod.GenerateName, err)
 		log.Warn(w)
 		return admission.Allowed("allowed but would not inject Loggie sidecar, " + w)
@@ -76,6 +117,7 @@ func (s *SidecarInjection) Handle(ctx context.Context, req admission.Request) ad
 	}
 
 	if err := s.patchWithModeEnv(mutatePod, lgc, paths); err != nil {
+		s.Failures.Record(lgc, err)
 		log.Warn("inject pod %s/%s sidecar failed: %v", mutatePod.Namespace, mutatePod.GenerateName, err)
 		return admission.Errored(http.StatusBadRequest, err)
 	}
@@ -85,6 +127,7 @@ func (s *SidecarInjection) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 	log.Info("injecting pod, namespace: %s, GenerateName: %s", mutatePod.Namespace, mutatePod.GenerateName)
+	metrics.Injections.WithLabelValues(InjectedImage(mutatePod)).Inc()
 	log.Debug("injecting pod yaml: %s", string(marshaledPod))
 
 	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
@@ -100,25 +143,32 @@ func (s *SidecarInjection) InjectDecoder(d *admission.Decoder) error {
 }
 
 func CheckInject(meta metav1.ObjectMeta, ignoredNamespaces []string) bool {
-	if meta.Annotations == nil {
-		return false
-	}
+	inject, _ := checkInjectWithReason(meta, ignoredNamespaces)
+	return inject
+}
+
+// IsSidecarConfig checks if the LogConfig/ClusterLogConfig is created for sidecar injection
+func IsSidecarConfig(meta metav1.ObjectMeta) bool {
+	return meta.Annotations[InjectorAnnotationKey] == InjectorAnnotationValueTrue
+}
 
+func checkInjectWithReason(meta metav1.ObjectMeta, ignoredNamespaces []string) (bool, string) {
 	if meta.Annotations[InjectorAnnotationKey] != InjectorAnnotationValueTrue {
-		return false
+		return false, fmt.Sprintf("pod does not have annotation %s: \"%s\"", InjectorAnnotationKey, InjectorAnnotationValueTrue)
 	}
 
 	// check namespace
 	for _, ns := range ignoredNamespaces {
 		if meta.Namespace == ns {
-			return false
+			return false, fmt.Sprintf("namespace %s is ignored", ns)
 		}
 	}
 
-	return true
+	return true, fmt.Sprintf("pod has annotation %s: \"%s\"", InjectorAnnotationKey, InjectorAnnotationValueTrue)
 }
 
-func (s *SidecarInjection) addPodSidecar(pod *corev1.Pod) error {
+// AddPodSidecar injects the sidecar with the matched LogConfig/ClusterLogConfig to the pod, the same as the webhook does
+func (s *SidecarInjection) AddPodSidecar(pod *corev1.Pod) error {
 	lgc, paths, err := s.getMatchedLogConfig(pod)
 	if err != nil {
 		return err
@@ -130,20 +180,36 @@ func (s *SidecarInjection) addPodSidecar(pod *corev1.Pod) error {
 }
 
 func (s *SidecarInjection) patchWithModeEnv(pod *corev1.Pod, logConfig *logconfigv1beta1.LogConfig, paths []string) error {
-	var mounts []corev1.VolumeMount
-	var volumes []corev1.Volume
-	registryMount, registryVol := registryVolumes()
-	logMount, logVol := logVolumes(pod, paths, s.Config.IgnoreContainerNames)
-	mounts = append(mounts, registryMount)
-	mounts = append(mounts, logMount...)
-	volumes = append(volumes, registryVol)
-	volumes = append(volumes, logVol...)
-
-	envs, err := configEnvs(logConfig, s.Client, s.Config.SystemConfig)
+	mode, err := InjectionMode(s.Config, logConfig, pod)
+	if err != nil {
+		return err
+	}
+	if mode == config.ModeVolume {
+		return injectVolumes(pod, logConfig, paths, s.Config.IgnoreContainerNames, map[string]string{
+			ConfigAnnotationKey: ConfigRef(logConfig),
+		})
+	}
+
+	pipes, err := RenderPipelines(s.Config, logConfig, pod.Namespace, s.Reader)
+	if err != nil {
+		return err
+	}
+
+	// the ports of the sidecar injected before are not taken
+	uninjected := pod.DeepCopy()
+	if _, err := Uninject(uninjected); err != nil {
+		return err
+	}
+	systemConfig, err := EffectiveSystemConfig(s.Config, uninjected)
 	if err != nil {
 		return err
 	}
 
+	var stdout *config.Stdout
+	if s.Config.Stdout.Enabled && hasStdoutPath(logConfig.Spec.Pipeline.Sources) {
+		stdout = &s.Config.Stdout
+	}
+
 	sidecar := corev1.Container{
 		Name: SidecarContainerName,
 		Args: []string{
@@ -151,19 +217,72 @@ func (s *SidecarInjection) patchWithModeEnv(pod *corev1.Pod, logConfig *logconfi
 			fmt.Sprintf("-config.system=%s", EnvKeySystem),
 			fmt.Sprintf("-config.pipeline=%s", EnvKeyPipeline),
 		},
-		Image:        s.Config.Image,
-		Env:          envs,
-		VolumeMounts: mounts,
+		Image: PodImage(s.Config, pod),
+		Env:   configEnvs(pipes, systemConfig),
+	}
+
+	// the hash is of the configured system config, which the effective one is derived from with the pod,
+	// so the pods with a rewritten port are not out of date
+	annotations := map[string]string{
+		ConfigAnnotationKey:     ConfigRef(logConfig),
+		ConfigHashAnnotationKey: ConfigHash(sidecar.Image, s.Config.SystemConfig, pipes),
+		ImageAnnotationKey:      sidecar.Image,
+	}
+	if err := exposeHTTP(pod, &sidecar, s.Config, systemConfig, annotations); err != nil {
+		return err
+	}
+	var heartbeat *config.Heartbeat
+	if s.Config.Heartbeat.Enabled {
+		heartbeat = &s.Config.Heartbeat
+	}
+	return injectSidecar(pod, sidecar, paths, s.Config.IgnoreContainerNames, stdout, heartbeat, annotations)
+}
+
+// injectSidecar adds the sidecar container, its volumes and the annotations to the pod, and records them in the injected status annotation.
+// The stdout of app containers are captured if stdout is not nil, and the sidecar reports to the operator if heartbeat is not nil.
+// A pod which has been injected before would be uninjected first, so injecting twice gives the same result.
+func injectSidecar(pod *corev1.Pod, sidecar corev1.Container, paths []string, ignoreContainerNames []string,
+	stdout *config.Stdout, heartbeat *config.Heartbeat, annotations map[string]string) error {
+	if _, err := Uninject(pod); err != nil {
+		return err
 	}
+
+	status := &InjectedStatus{}
+	registryMount := registryVolumes(pod, status)
+	logMounts := logVolumes(pod, paths, ignoreContainerNames, status)
+	if stdout != nil {
+		stdoutMount, err := captureStdout(pod, stdout, ignoreContainerNames, status)
+		if err != nil {
+			return err
+		}
+		logMounts = append(logMounts, stdoutMount)
+	}
+
+	if heartbeat != nil {
+		wrapReporter(pod, &sidecar, heartbeat, status)
+	}
+
+	sidecar.Name = uniqueContainerName(pod, sidecar.Name)
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, registryMount)
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, logMounts...)
 	pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
-	pod.Spec.Volumes = append(pod.Spec.Volumes, volumes...)
+	status.Containers = append(status.Containers, sidecar.Name)
 
-	return nil
+	for k, v := range annotations {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[k] = v
+		status.Annotations = append(status.Annotations, k)
+	}
+	sort.Strings(status.Annotations)
+
+	return setInjectedStatus(pod, status)
 }
 
-// add registry volumeMount and volume
-func registryVolumes() (corev1.VolumeMount, corev1.Volume) {
-	registryVolName := "registry"
+// add registry volume, returns the volumeMount of sidecar
+func registryVolumes(pod *corev1.Pod, status *InjectedStatus) corev1.VolumeMount {
+	registryVolName := uniqueVolumeName(pod, RegistryVolumeName)
 	registryMount := corev1.VolumeMount{
 		Name:      registryVolName,
 		MountPath: "/data",
@@ -175,16 +294,27 @@ func registryVolumes() (corev1.VolumeMount, corev1.Volume) {
 		},
 	}
 
-	return registryMount, registryVol
+	pod.Spec.Volumes = append(pod.Spec.Volumes, registryVol)
+	status.Volumes = append(status.Volumes, registryVolName)
+	return registryMount
 }
 
-// add paths volume for app container and sidecar
-func logVolumes(pod *corev1.Pod, paths []string, ignoreContainerNames []string) ([]corev1.VolumeMount, []corev1.Volume) {
+// add paths volume for app container and sidecar, returns the volumeMounts of sidecar
+func logVolumes(pod *corev1.Pod, paths []string, ignoreContainerNames []string, status *InjectedStatus) []corev1.VolumeMount {
 	var mounts []corev1.VolumeMount
-	var volumes []corev1.Volume
 	logPaths := files.CommonPath(paths)
 	for i := 0; i < len(logPaths); i++ {
-		logVolName := fmt.Sprintf("loggie-logs-%d", i)
+		// the log path is mounted by app container already, share the same volume with sidecar
+		if existing, ok := volumeMountOfPath(pod, logPaths[i]); ok {
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      existing.Name,
+				MountPath: logPaths[i],
+				SubPath:   existing.SubPath,
+			})
+			continue
+		}
+
+		logVolName := uniqueVolumeName(pod, fmt.Sprintf("%s%d", LogVolumeNamePrefix, i))
 		logMount := corev1.VolumeMount{
 			Name:      logVolName,
 			MountPath: logPaths[i],
@@ -199,10 +329,8 @@ func logVolumes(pod *corev1.Pod, paths []string, ignoreContainerNames []string)
 		// add log volumeMounts to app container
 		for j, container := range pod.Spec.Containers {
 			// if skip mount log volume to this container
-			for _, c := range ignoreContainerNames {
-				if container.Name == c {
-					continue
-				}
+			if contains(ignoreContainerNames, container.Name) {
+				continue
 			}
 
 			applogMount := corev1.VolumeMount{
@@ -211,54 +339,69 @@ func logVolumes(pod *corev1.Pod, paths []string, ignoreContainerNames []string)
 			}
 
 			pod.Spec.Containers[j].VolumeMounts = append(pod.Spec.Containers[j].VolumeMounts, applogMount)
+			status.addVolumeMount(container.Name, logVolName)
 		}
 
 		mounts = append(mounts, logMount)
-		volumes = append(volumes, logVol)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, logVol)
+		status.Volumes = append(status.Volumes, logVolName)
 	}
 
-	return mounts, volumes
+	return mounts
+}
+
+func volumeMountOfPath(pod *corev1.Pod, path string) (corev1.VolumeMount, bool) {
+	for _, c := range pod.Spec.Containers {
+		for _, m := range c.VolumeMounts {
+			if m.MountPath == path {
+				return m, true
+			}
+		}
+	}
+	return corev1.VolumeMount{}, false
 }
 
-func configEnvs(logConfig *logconfigv1beta1.LogConfig, cli client.Client, systemConfig string) ([]corev1.EnvVar, error) {
+func configEnvs(pipelines string, systemConfig string) []corev1.EnvVar {
 	var envs []corev1.EnvVar
 	systemEnv := corev1.EnvVar{
 		Name:  EnvKeySystem,
 		Value: systemConfig,
 	}
-	pipes, err := kubernetes.LogConfigToPipelineStr(logConfig, cli)
-	if err != nil {
-		return nil, err
-	}
 	pipelineEnv := corev1.EnvVar{
 		Name:  EnvKeyPipeline,
-		Value: pipes,
+		Value: pipelines,
 	}
 	envs = append(envs, systemEnv, pipelineEnv)
 
-	return envs, nil
+	return envs
 }
 
+// getMatchedLogConfig returns the LogConfig injected to the pod and the paths to mount, or nil if none matches.
+// The matched LogConfig is returned along with the error if it cannot be injected.
 func (s *SidecarInjection) getMatchedLogConfig(pod *corev1.Pod) (logConfig *logconfigv1beta1.LogConfig, path []string, e error) {
-	// find pod matched LogConfig
-	lgc, err := s.podMatchedLogConfigs(pod)
+	matches, err := s.matchConfigs(context.Background(), pod)
 	if err != nil {
 		return nil, nil, err
 	}
-	if lgc == nil {
-		clgc, err := s.podMatchedClusterLogConfigs(pod)
-		if err != nil {
-			return nil, nil, err
+	var lgc *logconfigv1beta1.LogConfig
+	for _, m := range matches {
+		if m.Matched {
+			lgc = m.lgc
+			break
 		}
-		if clgc == nil {
-			return nil, nil, nil
-		}
-		lgc = clgc.ToLogConfig()
+	}
+	if lgc == nil {
+		return nil, nil, nil
 	}
 
-	paths, err := retrievePathsFromSource(lgc.Spec.Pipeline.Sources)
+	// the stdout of pods injected in volume mode is collected by the Loggie DaemonSet
+	mode, err := InjectionMode(s.Config, lgc, pod)
 	if err != nil {
-		return nil, nil, err
+		return lgc, nil, err
+	}
+	paths, err := retrievePathsFromSource(lgc.Spec.Pipeline.Sources, s.Config.Stdout.Enabled || mode == config.ModeVolume)
+	if err != nil {
+		return lgc, nil, err
 	}
 
 	return lgc, paths, nil
@@ -268,7 +411,8 @@ type pathsInFileSource struct {
 	Paths []string `yaml:"paths,omitempty"`
 }
 
-func retrievePathsFromSource(sources string) ([]string, error) {
+// retrievePathsFromSource returns the paths of sources to mount, path stdout is skipped if captureStdout is true
+func retrievePathsFromSource(sources string, captureStdout bool) ([]string, error) {
 	src := make([]pathsInFileSource, 0)
 	if err := cfg.UnPackFromRaw([]byte(sources), &src).Do(); err != nil {
 		return nil, err
@@ -277,51 +421,74 @@ func retrievePathsFromSource(sources string) ([]string, error) {
 	var result []string
 	for _, s := range src {
 		for _, p := range s.Paths {
-			if p == logconfigv1beta1.PathStdout {
-				return nil, errors.New("pod stdout logs is not supported in loggie sidecar")
+			if p != logconfigv1beta1.PathStdout {
+				result = append(result, p)
+				continue
+			}
+			if !captureStdout {
+				return nil, errors.New("pod stdout logs is not supported in loggie sidecar, unless stdout capture is enabled")
 			}
 		}
-
-		result = append(result, s.Paths...)
 	}
 
 	return result, nil
 }
 
-func (s *SidecarInjection) podMatchedLogConfigs(pod *corev1.Pod) (*logconfigv1beta1.LogConfig, error) {
-	lgcList := &logconfigv1beta1.LogConfigList{}
-	if err := s.Client.List(context.Background(), lgcList, &client.ListOptions{}); err != nil {
-		return nil, err
+func hasStdoutPath(sources string) bool {
+	src := make([]pathsInFileSource, 0)
+	if err := cfg.UnPackFromRaw([]byte(sources), &src).Do(); err != nil {
+		return false
 	}
-
-	for _, lgc := range lgcList.Items {
-		if lgc.Spec.Selector == nil || lgc.Spec.Selector.Type != logconfigv1beta1.SelectorTypePod {
-			continue
-		}
-
-		if kubernetes.LabelsSubset(lgc.Spec.Selector.LabelSelector, pod.Labels) {
-			return lgc.DeepCopy(), nil
+	for _, s := range src {
+		if contains(s.Paths, logconfigv1beta1.PathStdout) {
+			return true
 		}
 	}
-
-	return nil, nil
+	return false
 }
 
-func (s *SidecarInjection) podMatchedClusterLogConfigs(pod *corev1.Pod) (*logconfigv1beta1.ClusterLogConfig, error) {
-	clgcList := &logconfigv1beta1.ClusterLogConfigList{}
-	if err := s.Client.List(context.Background(), clgcList, &client.ListOptions{}); err != nil {
-		return nil, err
+// podMatchedSelector checks if the selector of LogConfig/ClusterLogConfig selects the pod, and tells why.
+// A selector with cluster selects the pods only if the operator runs in the cluster. A selector of type cluster
+// selects the pods by selector.labelSelector in the cluster, or all the pods only if selectAll is set explicitly.
+func podMatchedSelector(selector *logconfigv1beta1.Selector, pod *corev1.Pod, clusterName string, selectAll bool) (bool, []string) {
+	if selector == nil {
+		return false, []string{"selector is empty"}
+	}
+	if !sidecarSelectorTypes[selector.Type] {
+		return false, []string{fmt.Sprintf("selector.type is %s, only %s, %s and %s are supported in sidecar", selector.Type,
+			logconfigv1beta1.SelectorTypePod, logconfigv1beta1.SelectorTypeNode, logconfigv1beta1.SelectorTypeCluster)}
 	}
 
-	for _, lgc := range clgcList.Items {
-		if lgc.Spec.Selector == nil || lgc.Spec.Selector.Type != logconfigv1beta1.SelectorTypePod {
-			continue
-		}
+	matched := true
+	var reasons []string
+	switch {
+	case selector.Cluster != "" && selector.Cluster != clusterName:
+		matched = false
+		reasons = append(reasons, fmt.Sprintf("selector.cluster is %s, the operator runs in cluster %q", selector.Cluster, clusterName))
+	case selector.Cluster != "":
+		reasons = append(reasons, fmt.Sprintf("selector.cluster is %s: matched", selector.Cluster))
+	case selector.Type == logconfigv1beta1.SelectorTypeCluster:
+		matched = false
+		reasons = append(reasons, "selector.cluster is empty")
+	}
+	if selector.Type == logconfigv1beta1.SelectorTypeCluster && len(selector.LabelSelector) == 0 && !selectAll {
+		matched = false
+		reasons = append(reasons, fmt.Sprintf("selector.labelSelector is empty, set annotation %s: \"true\" to select all the pods of the cluster", SelectAllPodsAnnotationKey))
+	}
 
-		if kubernetes.LabelsSubset(lgc.Spec.Selector.LabelSelector, pod.Labels) {
-			return lgc.DeepCopy(), nil
-		}
+	var ok bool
+	var r []string
+	switch selector.Type {
+	case logconfigv1beta1.SelectorTypeNode:
+		ok, r = kubernetes.NodeLabelsGuaranteedWithReasons(selector.NodeSelector.NodeSelector, pod)
+	default:
+		ok, r = kubernetes.LabelsSubsetWithReasons(selector.LabelSelector, pod.Labels)
 	}
+	return matched && ok, append(reasons, r...)
+}
 
-	return nil, nil
+var sidecarSelectorTypes = map[string]bool{
+	logconfigv1beta1.SelectorTypePod:     true,
+	logconfigv1beta1.SelectorTypeNode:    true,
+	logconfigv1beta1.SelectorTypeCluster: true,
 }
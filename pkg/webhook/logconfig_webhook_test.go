@@ -0,0 +1,187 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func testPod() *corev1.Pod {
+	pod := &corev1.Pod{}
+	pod.Name = "tomcat"
+	pod.Namespace = "default"
+	pod.Spec.Containers = []corev1.Container{
+		{
+			Name:  "tomcat",
+			Image: "tomcat",
+		},
+	}
+	return pod
+}
+
+func Test_injectSidecar(t *testing.T) {
+	sidecar := corev1.Container{
+		Name:  SidecarContainerName,
+		Image: "loggieio/loggie:main",
+	}
+	paths := []string{"/usr/local/tomcat/logs/*.log", "/var/log/**"}
+	annotations := map[string]string{
+		ConfigAnnotationKey:     "LogConfig/default/tomcat",
+		ConfigHashAnnotationKey: "2c26b46b68ffc68f",
+		ImageAnnotationKey:      "loggieio/loggie:main",
+	}
+
+	tests := []struct {
+		name           string
+		pod            func() *corev1.Pod
+		stdout         *config.Stdout
+		heartbeat      *config.Heartbeat
+		wantContainers []string
+		wantVolumes    []string
+		wantCommand    []string
+	}{
+		{
+			name:           "common",
+			pod:            testPod,
+			wantContainers: []string{"tomcat", "loggie"},
+			wantVolumes:    []string{"loggie-registry", "loggie-logs-0", "loggie-logs-1"},
+		},
+		{
+			name: "name collision",
+			pod: func() *corev1.Pod {
+				pod := testPod()
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "loggie"})
+				pod.Spec.Volumes = []corev1.Volume{{Name: "registry"}, {Name: "loggie-registry"}, {Name: "loggie-logs-1"}}
+				return pod
+			},
+			wantContainers: []string{"tomcat", "loggie", "loggie-1"},
+			wantVolumes:    []string{"registry", "loggie-registry", "loggie-logs-1", "loggie-registry-1", "loggie-logs-0", "loggie-logs-1-1"},
+		},
+		{
+			name: "log path mounted by app",
+			pod: func() *corev1.Pod {
+				pod := testPod()
+				pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log"}}
+				pod.Spec.Volumes = []corev1.Volume{{Name: "logs"}}
+				return pod
+			},
+			wantContainers: []string{"tomcat", "loggie"},
+			wantVolumes:    []string{"logs", "loggie-registry", "loggie-logs-0"},
+		},
+		{
+			name: "heartbeat",
+			pod:  testPod,
+			heartbeat: &config.Heartbeat{
+				URL:      "https://loggie-operator.loggie.svc:9443/heartbeat",
+				Image:    "loggieio/loggie-operator:main",
+				Command:  []string{"/loggie"},
+				Interval: 30 * time.Second,
+				Audience: "loggie-operator",
+			},
+			wantContainers: []string{"tomcat", "loggie"},
+			wantVolumes:    []string{"loggie-registry", "loggie-logs-0", "loggie-logs-1", "loggie-reporter", "loggie-reporter-token"},
+		},
+		{
+			name: "capture stdout",
+			pod: func() *corev1.Pod {
+				pod := testPod()
+				pod.Annotations = map[string]string{
+					EntrypointAnnotationPrefix + "tomcat": `{"entrypoint": ["catalina.sh"], "cmd": ["run"]}`,
+				}
+				return pod
+			},
+			stdout:         &config.Stdout{Image: "loggieio/loggie-operator:main", MaxFileSize: 100},
+			wantContainers: []string{"tomcat", "loggie"},
+			wantVolumes:    []string{"loggie-registry", "loggie-logs-0", "loggie-logs-1", "loggie-tee", "loggie-stdout"},
+			wantCommand: []string{"/loggie-tee/loggie-tee", "-file", "/loggie-stdout/tomcat/stdout.log", "-max-size", "100", "--",
+				"catalina.sh", "run"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := tt.pod()
+
+			pod := origin.DeepCopy()
+			assert.NoError(t, injectSidecar(pod, sidecar, paths, nil, tt.stdout, tt.heartbeat, annotations))
+
+			var containers []string
+			for _, c := range pod.Spec.Containers {
+				containers = append(containers, c.Name)
+			}
+			var volumes []string
+			for _, v := range pod.Spec.Volumes {
+				volumes = append(volumes, v.Name)
+			}
+			assert.Equal(t, tt.wantContainers, containers)
+			assert.Equal(t, tt.wantVolumes, volumes)
+			assert.Equal(t, tt.wantCommand, pod.Spec.Containers[0].Command)
+
+			// injecting twice gives the same result
+			again := pod.DeepCopy()
+			assert.NoError(t, injectSidecar(again, sidecar, paths, nil, tt.stdout, tt.heartbeat, annotations))
+			assert.Equal(t, pod, again)
+
+			// uninject restores the original pod
+			injected, err := Uninject(pod)
+			assert.NoError(t, err)
+			assert.True(t, injected)
+			assert.Equal(t, origin, pod)
+		})
+	}
+}
+
+func TestUninject_staleStatus(t *testing.T) {
+	sidecar := corev1.Container{Name: SidecarContainerName, Image: "loggieio/loggie:main"}
+	stdout := &config.Stdout{Image: "loggieio/loggie-operator:main", MaxFileSize: 100}
+	pod := testPod()
+	pod.Spec.Containers[0].Command = []string{"catalina.sh", "run"}
+	assert.NoError(t, injectSidecar(pod, sidecar, []string{"/var/log/**"}, nil, stdout, nil, map[string]string{
+		ImageAnnotationKey: sidecar.Image,
+	}))
+
+	// a template copied from the injected pod, whose injected fields are replaced by the user,
+	// keeps the annotation of the injected status
+	pod.Spec.InitContainers = nil
+	pod.Spec.Containers[0].Command = []string{"catalina.sh", "start"}
+	pod.Spec.Containers[1].Image = "busybox"
+	for i, v := range pod.Spec.Volumes {
+		if v.Name == StdoutVolumeName {
+			pod.Spec.Volumes[i].VolumeSource = corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log/stdout"}}
+		}
+	}
+
+	injected, err := Uninject(pod)
+	assert.NoError(t, err)
+	assert.True(t, injected)
+
+	var containers []string
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	var volumes []string
+	for _, v := range pod.Spec.Volumes {
+		volumes = append(volumes, v.Name)
+	}
+	assert.Equal(t, []string{"tomcat", "loggie"}, containers)
+	assert.Equal(t, []string{StdoutVolumeName}, volumes)
+	assert.Equal(t, []string{"catalina.sh", "start"}, pod.Spec.Containers[0].Command)
+	assert.Equal(t, []corev1.VolumeMount{{Name: StdoutVolumeName, MountPath: StdoutPath}}, pod.Spec.Containers[0].VolumeMounts)
+}
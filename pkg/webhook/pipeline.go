@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"github.com/loggie-io/loggie/pkg/core/interceptor"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/loggie/pkg/util/yaml"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultSinkAnnotationKey of namespace is the Sink of the LogConfigs without sink in the namespace
+const DefaultSinkAnnotationKey = "sidecar.loggie.io/default-sink"
+
+// RenderPipelines renders the pipelines of the LogConfig run by the sidecar:
+//   - the stdout paths are replaced with the files written by loggie-tee if stdout capture is enabled
+//   - the sink sends to the LogCluster in annotation sidecar.loggie.io/log-cluster
+//   - the default sink of the namespace or configuration is used if the LogConfig has no sink
+//   - the mandatory interceptors in the configuration are added
+//
+// namespace is the namespace of the pod, whose default sink is used for a ClusterLogConfig.
+func RenderPipelines(conf *config.Sidecar, lgc *logconfigv1beta1.LogConfig, namespace string, reader client.Reader) (string, error) {
+	lgc = lgc.DeepCopy()
+	if conf.Stdout.Enabled {
+		sources, ok, err := rewriteStdoutSources(lgc.Spec.Pipeline.Sources)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			lgc.Spec.Pipeline.Sources = sources
+		}
+	}
+
+	if err := resolveSink(conf, lgc, namespace, reader); err != nil {
+		return "", err
+	}
+
+	pipes, err := kubernetes.LogConfigToPipeline(lgc, reader)
+	if err != nil {
+		return "", err
+	}
+
+	mandatory, err := conf.MandatoryInterceptors()
+	if err != nil {
+		return "", err
+	}
+	for i := range pipes.Pipelines {
+		interceptors, err := mergeMandatoryInterceptors(mandatory, pipes.Pipelines[i].Interceptors)
+		if err != nil {
+			return "", err
+		}
+		pipes.Pipelines[i].Interceptors = interceptors
+	}
+
+	pipeData, err := yaml.Marshal(pipes)
+	if err != nil {
+		return "", err
+	}
+	return string(pipeData), nil
+}
+
+// resolveSink sets the sink of the LogConfig in place, to the LogCluster in annotation sidecar.loggie.io/log-cluster,
+// or the default sink of namespace if the LogConfig has no sink
+func resolveSink(conf *config.Sidecar, lgc *logconfigv1beta1.LogConfig, namespace string, reader client.Reader) error {
+	key, ok, err := LogClusterOf(lgc.Annotations, lgc.Namespace)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if lgc.Spec.Pipeline.Sink != "" || lgc.Spec.Pipeline.SinkRef != "" {
+			return errors.Errorf("annotation %s conflicts with sink or sinkRef", LogClusterAnnotationKey)
+		}
+		sink, err := logClusterSink(context.Background(), key, reader)
+		if err != nil {
+			return err
+		}
+		lgc.Spec.Pipeline.Sink = sink
+	}
+
+	if lgc.Spec.Pipeline.Sink == "" && lgc.Spec.Pipeline.SinkRef == "" {
+		sinkRef, err := defaultSinkRef(conf, namespace, reader)
+		if err != nil {
+			return err
+		}
+		lgc.Spec.Pipeline.SinkRef = sinkRef
+	}
+	return nil
+}
+
+// defaultSinkRef returns the default sink in the namespace annotation, or the one in the configuration
+func defaultSinkRef(conf *config.Sidecar, namespace string, reader client.Reader) (string, error) {
+	if namespace != "" {
+		ns := &corev1.Namespace{}
+		err := reader.Get(context.Background(), types.NamespacedName{Name: namespace}, ns)
+		if err != nil && !kerrors.IsNotFound(err) {
+			return "", err
+		}
+		if sinkRef := ns.Annotations[DefaultSinkAnnotationKey]; sinkRef != "" {
+			return sinkRef, nil
+		}
+	}
+	return conf.DefaultSinkRef, nil
+}
+
+// mergeMandatoryInterceptors puts the mandatory interceptors before the ones of LogConfig. An interceptor of LogConfig
+// with the same type and name as a mandatory one is rejected, so it cannot disable or change the mandatory one.
+func mergeMandatoryInterceptors(mandatory []*interceptor.Config, interceptors []*interceptor.Config) ([]*interceptor.Config, error) {
+	uids := make(map[string]bool)
+	for _, m := range mandatory {
+		uids[m.UID()] = true
+	}
+
+	for _, i := range interceptors {
+		if uids[i.UID()] {
+			return nil, errors.Errorf("interceptor %s is mandatory, which cannot be overridden by LogConfig, remove it or give it another name", i.UID())
+		}
+	}
+	return interceptor.MergeInterceptorList(mandatory, interceptors), nil
+}
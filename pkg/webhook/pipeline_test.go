@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/loggie/pkg/core/log"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	operatorv1beta1 "github.com/loggie-io/operator/api/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+func TestRenderPipelines(t *testing.T) {
+	log.InitDefaultLogger()
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, logconfigv1beta1.AddToScheme(scheme))
+	assert.NoError(t, operatorv1beta1.AddToScheme(scheme))
+
+	ns := &corev1.Namespace{}
+	ns.Name = "team-a"
+	ns.Annotations = map[string]string{DefaultSinkAnnotationKey: "team-a"}
+	sinkA := &logconfigv1beta1.Sink{}
+	sinkA.Name = "team-a"
+	sinkA.Spec.Sink = "type: dev\nname: team-a"
+	sinkDefault := &logconfigv1beta1.Sink{}
+	sinkDefault.Name = "default"
+	sinkDefault.Spec.Sink = "type: dev\nname: default"
+	aggregator := &operatorv1beta1.LogCluster{}
+	aggregator.Namespace = "loggie"
+	aggregator.Name = "aggregator"
+	reader, err := kubernetes.NewObjectReader(scheme, []client.Object{ns, sinkA, sinkDefault, aggregator}...)
+	assert.NoError(t, err)
+
+	conf := &config.Sidecar{
+		DefaultSinkRef: "default",
+		Interceptors: `
+- type: rateLimit
+  qps: 1000
+- type: maxbytes
+  maxBytes: 1024`,
+	}
+
+	lgc := func(namespace string, interceptors string) *logconfigv1beta1.LogConfig {
+		l := &logconfigv1beta1.LogConfig{}
+		l.Namespace = namespace
+		l.Name = "app"
+		l.Spec.Pipeline = &logconfigv1beta1.Pipeline{
+			Sources:      "- type: file\n  name: app\n  paths: [/var/log/*.log]",
+			Interceptors: interceptors,
+		}
+		return l
+	}
+
+	withLogCluster := func(l *logconfigv1beta1.LogConfig, ref string) *logconfigv1beta1.LogConfig {
+		l.Annotations = map[string]string{LogClusterAnnotationKey: ref}
+		return l
+	}
+
+	tests := []struct {
+		name      string
+		lgc       *logconfigv1beta1.LogConfig
+		namespace string
+		wantSink  string
+		wantOrder []string
+	}{
+		{
+			name:      "namespace default sink",
+			lgc:       lgc("team-a", ""),
+			wantSink:  "name: team-a",
+			wantOrder: []string{"type: rateLimit", "type: maxbytes"},
+		},
+		{
+			name:      "configuration default sink",
+			lgc:       lgc("team-b", ""),
+			wantSink:  "name: default",
+			wantOrder: []string{"type: rateLimit", "type: maxbytes"},
+		},
+		{
+			name:      "cluster config with the default sink of the pod namespace",
+			lgc:       lgc("", ""),
+			namespace: "team-a",
+			wantSink:  "name: team-a",
+			wantOrder: []string{"type: rateLimit", "type: maxbytes"},
+		},
+		{
+			name:      "mandatory interceptors before the ones of LogConfig",
+			lgc:       lgc("team-a", "- type: normalize"),
+			wantSink:  "name: team-a",
+			wantOrder: []string{"qps: 1000\n", "type: maxbytes", "type: normalize"},
+		},
+		{
+			name:     "log cluster",
+			lgc:      withLogCluster(lgc("team-a", ""), "loggie/aggregator"),
+			wantSink: "host: aggregator.loggie.svc:6066",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := tt.namespace
+			if namespace == "" {
+				namespace = tt.lgc.Namespace
+			}
+			got, err := RenderPipelines(conf, tt.lgc, namespace, reader)
+			assert.NoError(t, err)
+			assert.Contains(t, got, tt.wantSink)
+			assert.NotContains(t, got, "1000000")
+
+			last := -1
+			for _, s := range tt.wantOrder {
+				i := strings.Index(got, s)
+				assert.Greater(t, i, last, "%s is out of order in:\n%s", s, got)
+				last = i
+			}
+		})
+	}
+
+	_, err = RenderPipelines(conf, lgc("team-a", "- type: rateLimit\n  qps: 1000000\n- type: normalize"), "team-a", reader)
+	assert.EqualError(t, err, "interceptor rateLimit/ is mandatory, which cannot be overridden by LogConfig, remove it or give it another name")
+	_, err = RenderPipelines(&config.Sidecar{}, lgc("team-b", ""), "team-b", reader)
+	assert.EqualError(t, err, "pipeline has no sink or sinkRef")
+	_, err = RenderPipelines(&config.Sidecar{}, withLogCluster(lgc("team-b", ""), "aggregator"), "team-b", reader)
+	assert.EqualError(t, err, "get LogCluster team-b/aggregator: logcluster.operator.loggie.io \"aggregator\" not found")
+}
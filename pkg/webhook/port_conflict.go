@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// maxPortProbes is how many ports after loggie.http.port are tried to find a free one
+const maxPortProbes = 100
+
+// EffectiveSystemConfig returns the system config of the sidecar injected to the pod. If loggie.http.port conflicts
+// with the ports declared by the containers, or the ports of the node for the pods in host network, it is rewritten
+// with the next free port, or an error is returned if the policy is reject.
+// The pod should not be injected, or the ports of the injected sidecar conflict.
+func EffectiveSystemConfig(conf *config.Sidecar, pod *corev1.Pod) (string, error) {
+	h, err := ParseHTTP(conf.SystemConfig)
+	if err != nil {
+		return "", errors.WithMessage(err, "invalid systemConfig")
+	}
+	if !h.Enabled {
+		return conf.SystemConfig, nil
+	}
+
+	taken := takenPorts(conf, pod)
+	conflict, ok := taken[h.Port]
+	if !ok {
+		return conf.SystemConfig, nil
+	}
+	if conf.PortConflict.Policy == config.PortConflictReject {
+		return "", errors.Errorf("port %d of Loggie HTTP server in systemConfig conflicts with %s, "+
+			"change the port of the pod or loggie.http.port in the configuration of operator", h.Port, conflict)
+	}
+
+	for port := h.Port + 1; port <= h.Port+maxPortProbes && port <= 65535; port++ {
+		if _, ok := taken[port]; ok {
+			continue
+		}
+		systemConfig, err := SetHTTPPort(conf.SystemConfig, port)
+		if err != nil {
+			return "", errors.WithMessage(err, "rewrite loggie.http.port of systemConfig failed")
+		}
+		log.Info("port %d of Loggie HTTP server conflicts with %s of pod %s/%s, use port %d instead",
+			h.Port, conflict, pod.Namespace, pod.GenerateName, port)
+		return systemConfig, nil
+	}
+	return "", errors.Errorf("port %d of Loggie HTTP server in systemConfig conflicts with %s, and no free port is found after it", h.Port, conflict)
+}
+
+// takenPorts returns the TCP ports taken in the network namespace of the pod, and what takes each of them
+func takenPorts(conf *config.Sidecar, pod *corev1.Pod) map[int]string {
+	taken := make(map[int]string)
+	add := func(containers []corev1.Container) {
+		for _, c := range containers {
+			for _, p := range c.Ports {
+				if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+					continue
+				}
+				taken[int(p.ContainerPort)] = fmt.Sprintf("port %d of container %s", p.ContainerPort, c.Name)
+			}
+		}
+	}
+	add(pod.Spec.InitContainers)
+	add(pod.Spec.Containers)
+
+	if pod.Spec.HostNetwork {
+		for _, port := range conf.PortConflict.ReservedHostPorts {
+			if _, ok := taken[port]; !ok {
+				taken[port] = fmt.Sprintf("reserved host port %d in host network", port)
+			}
+		}
+	}
+	return taken
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func TestEffectiveSystemConfig(t *testing.T) {
+	log.InitDefaultLogger()
+
+	systemConfig := `loggie:
+  reload:
+    enabled: true
+  http:
+    enabled: true
+`
+	container := func(name string, ports ...corev1.ContainerPort) corev1.Container {
+		return corev1.Container{Name: name, Ports: ports}
+	}
+	tcp := func(port int32) corev1.ContainerPort {
+		return corev1.ContainerPort{ContainerPort: port}
+	}
+
+	tests := []struct {
+		name         string
+		systemConfig string
+		policy       string
+		hostNetwork  bool
+		containers   []corev1.Container
+		wantPort     int
+		wantErr      bool
+	}{
+		{
+			name:         "no conflict",
+			systemConfig: systemConfig,
+			containers:   []corev1.Container{container("app", tcp(8080))},
+			wantPort:     9196,
+		},
+		{
+			name:         "http disabled",
+			systemConfig: "loggie:\n  reload:\n    enabled: true\n",
+			containers:   []corev1.Container{container("app", tcp(9196))},
+			wantPort:     9196,
+		},
+		{
+			name:         "next free port",
+			systemConfig: systemConfig,
+			containers:   []corev1.Container{container("app", tcp(9196), tcp(9197)), container("exporter", tcp(9198))},
+			wantPort:     9199,
+		},
+		{
+			name:         "udp port",
+			systemConfig: systemConfig,
+			containers:   []corev1.Container{container("dns", corev1.ContainerPort{ContainerPort: 9196, Protocol: corev1.ProtocolUDP})},
+			wantPort:     9196,
+		},
+		{
+			name:         "reserved host port",
+			systemConfig: systemConfig,
+			hostNetwork:  true,
+			containers:   []corev1.Container{container("app", tcp(8080))},
+			wantPort:     9197,
+		},
+		{
+			name:         "reject",
+			systemConfig: systemConfig,
+			policy:       config.PortConflictReject,
+			containers:   []corev1.Container{container("app", tcp(9196))},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Sidecar{
+				SystemConfig: tt.systemConfig,
+				PortConflict: config.PortConflict{Policy: tt.policy, ReservedHostPorts: []int{9196}},
+			}
+			pod := &corev1.Pod{}
+			pod.Spec.HostNetwork = tt.hostNetwork
+			pod.Spec.Containers = tt.containers
+
+			got, err := EffectiveSystemConfig(conf, pod)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			h, err := ParseHTTP(got)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPort, h.Port)
+			if h.Port == 9196 {
+				assert.Equal(t, tt.systemConfig, got)
+			} else {
+				assert.Equal(t, fmt.Sprintf("loggie:\n  reload:\n    enabled: true\n  http:\n    enabled: true\n    port: %d\n", tt.wantPort), got)
+			}
+		})
+	}
+}
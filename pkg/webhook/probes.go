@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strconv"
+)
+
+const (
+	// MetricsPortName is the named port of the Loggie HTTP server in the sidecar, which PodMonitors could select
+	MetricsPortName = "loggie-metrics"
+	MetricsPath     = "/metrics"
+
+	ScrapeAnnotationKey     = "prometheus.io/scrape"
+	ScrapePortAnnotationKey = "prometheus.io/port"
+	ScrapePathAnnotationKey = "prometheus.io/path"
+)
+
+// exposeHTTP declares the port of the Loggie HTTP server in the effective system config on the sidecar, and adds the probes on it.
+// The scrape annotations are added to annotations if enabled, unless the pod has them for the app containers.
+// Nothing is added if the HTTP server is disabled or listens on the loopback address only.
+func exposeHTTP(pod *corev1.Pod, sidecar *corev1.Container, conf *config.Sidecar, systemConfig string, annotations map[string]string) error {
+	h, err := ParseHTTP(systemConfig)
+	if err != nil {
+		return errors.WithMessage(err, "invalid systemConfig")
+	}
+	if !h.reachable() {
+		return nil
+	}
+
+	sidecar.Ports = append(sidecar.Ports, corev1.ContainerPort{
+		Name:          MetricsPortName,
+		ContainerPort: int32(h.Port),
+		Protocol:      corev1.ProtocolTCP,
+	})
+
+	if conf.Probes.Enabled {
+		sidecar.ReadinessProbe = httpProbe(&conf.Probes)
+		sidecar.LivenessProbe = httpProbe(&conf.Probes)
+	}
+
+	if conf.Metrics.ScrapeAnnotations {
+		status, err := GetInjectedStatus(pod)
+		if err != nil {
+			return err
+		}
+		if _, ok := pod.Annotations[ScrapeAnnotationKey]; ok && (status == nil || !contains(status.Annotations, ScrapeAnnotationKey)) {
+			log.Info("pod %s/%s has annotation %s already, the scrape annotations of sidecar are not added", pod.Namespace, pod.GenerateName, ScrapeAnnotationKey)
+			return nil
+		}
+		annotations[ScrapeAnnotationKey] = "true"
+		annotations[ScrapePortAnnotationKey] = strconv.Itoa(h.Port)
+		annotations[ScrapePathAnnotationKey] = MetricsPath
+	}
+	return nil
+}
+
+func httpProbe(conf *config.Probes) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: conf.Path,
+				Port: intstr.FromString(MetricsPortName),
+			},
+		},
+		InitialDelaySeconds: int32(conf.InitialDelay.Seconds()),
+		PeriodSeconds:       int32(conf.Period.Seconds()),
+		TimeoutSeconds:      int32(conf.Timeout.Seconds()),
+		FailureThreshold:    int32(conf.FailureThreshold),
+	}
+}
+
+// metricsPort returns the declared metrics port of the sidecar, or 0 if none
+func metricsPort(sidecar *corev1.Container) int32 {
+	for _, p := range sidecar.Ports {
+		if p.Name == MetricsPortName {
+			return p.ContainerPort
+		}
+	}
+	return 0
+}
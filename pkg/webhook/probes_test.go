@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/loggie/pkg/core/log"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func Test_exposeHTTP(t *testing.T) {
+	log.InitDefaultLogger()
+
+	probes := config.Probes{Enabled: true, Path: "/api/v1/controller/pipelines", InitialDelay: 10 * time.Second,
+		Period: 10 * time.Second, Timeout: time.Second, FailureThreshold: 3}
+
+	tests := []struct {
+		name            string
+		systemConfig    string
+		conf            config.Sidecar
+		podAnnotations  map[string]string
+		wantPort        int32
+		wantProbes      bool
+		wantAnnotations map[string]string
+		wantErr         bool
+	}{
+		{
+			name:            "http disabled",
+			systemConfig:    "loggie:\n  reload:\n    enabled: true\n",
+			conf:            config.Sidecar{Probes: probes, Metrics: config.Metrics{ScrapeAnnotations: true}},
+			wantAnnotations: map[string]string{},
+		},
+		{
+			name:            "default port",
+			systemConfig:    "loggie:\n  http:\n    enabled: true\n",
+			wantPort:        9196,
+			wantAnnotations: map[string]string{},
+		},
+		{
+			name:         "probes and scrape annotations",
+			systemConfig: "loggie:\n  http:\n    enabled: true\n    port: 9200\n",
+			conf:         config.Sidecar{Probes: probes, Metrics: config.Metrics{ScrapeAnnotations: true}},
+			wantPort:     9200,
+			wantProbes:   true,
+			wantAnnotations: map[string]string{
+				ScrapeAnnotationKey:     "true",
+				ScrapePortAnnotationKey: "9200",
+				ScrapePathAnnotationKey: MetricsPath,
+			},
+		},
+		{
+			name:            "pod scraped already",
+			systemConfig:    "loggie:\n  http:\n    enabled: true\n",
+			conf:            config.Sidecar{Metrics: config.Metrics{ScrapeAnnotations: true}},
+			podAnnotations:  map[string]string{ScrapeAnnotationKey: "true"},
+			wantPort:        9196,
+			wantAnnotations: map[string]string{},
+		},
+		{
+			name:            "loopback",
+			systemConfig:    "loggie:\n  http:\n    enabled: true\n    host: 127.0.0.1\n",
+			conf:            config.Sidecar{Probes: probes},
+			wantAnnotations: map[string]string{},
+		},
+		{
+			name:         "invalid system config",
+			systemConfig: "loggie: [",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			pod.Annotations = tt.podAnnotations
+			sidecar := &corev1.Container{}
+			annotations := map[string]string{}
+			err := exposeHTTP(pod, sidecar, &tt.conf, tt.systemConfig, annotations)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPort, metricsPort(sidecar))
+			assert.Equal(t, tt.wantProbes, sidecar.ReadinessProbe != nil && sidecar.LivenessProbe != nil)
+			assert.Equal(t, tt.wantAnnotations, annotations)
+		})
+	}
+}
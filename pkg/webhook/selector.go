@@ -0,0 +1,327 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MatchExpressionsAnnotationKey is a list of label selector requirements ANDed with selector.labelSelector, such as
+	// `[{"key": "app", "operator": "In", "values": ["nginx", "tomcat"]}]`
+	MatchExpressionsAnnotationKey = "sidecar.loggie.io/match-expressions"
+	// NamespaceSelectorAnnotationKey is a label selector of the namespace of the pods, such as `{"matchLabels": {"team": "a"}}`
+	NamespaceSelectorAnnotationKey = "sidecar.loggie.io/namespace-selector"
+	// OwnerSelectorAnnotationKey is a comma separated list of workloads owning the pods, such as `Deployment/nginx,StatefulSet/web-*`
+	OwnerSelectorAnnotationKey = "sidecar.loggie.io/owner-selector"
+	// SelectAllPodsAnnotationKey set to "true" allows a selector of type cluster without selector.labelSelector to select all the pods
+	SelectAllPodsAnnotationKey = "sidecar.loggie.io/select-all-pods"
+	// PriorityAnnotationKey decides which config is injected when several configs match a pod, the higher the first
+	PriorityAnnotationKey = "sidecar.loggie.io/priority"
+)
+
+// configMatch is a LogConfig/ClusterLogConfig evaluated against a pod
+type configMatch struct {
+	ConfigMatch
+	lgc *logconfigv1beta1.LogConfig
+}
+
+// matchConfigs evaluates all the LogConfigs and ClusterLogConfigs against the pod, in the order of precedence:
+// the higher priority first, then LogConfig before ClusterLogConfig, then by namespace and name.
+// The first matched one is injected.
+func (s *SidecarInjection) matchConfigs(ctx context.Context, pod *corev1.Pod) ([]configMatch, error) {
+	m := &podMatcher{reader: s.Reader, clusterName: s.Config.ClusterName, pod: pod}
+
+	var matches []configMatch
+	lgcList := &logconfigv1beta1.LogConfigList{}
+	if err := s.Reader.List(ctx, lgcList, &client.ListOptions{}); err != nil {
+		return nil, err
+	}
+	for i := range lgcList.Items {
+		lgc := &lgcList.Items[i]
+		matches = append(matches, m.match(ctx, KindLogConfig, lgc, lgc.Spec.Selector, lgc))
+	}
+
+	if !s.IgnoreClusterLogConfigs {
+		clgcList := &logconfigv1beta1.ClusterLogConfigList{}
+		if err := s.Reader.List(ctx, clgcList, &client.ListOptions{}); err != nil {
+			return nil, err
+		}
+		for i := range clgcList.Items {
+			clgc := &clgcList.Items[i]
+			matches = append(matches, m.match(ctx, KindClusterLogConfig, clgc, clgc.Spec.Selector, clgc.ToLogConfig()))
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].before(matches[j].ConfigMatch)
+	})
+	return matches, nil
+}
+
+// PodMatcher evaluates a LogConfig/ClusterLogConfig against the pods, regardless of the other configs matching them
+type PodMatcher struct {
+	Reader     client.Reader
+	Config     *config.Sidecar
+	namespaces map[string]*corev1.Namespace
+}
+
+// Matches checks if the pod is to be injected and selected by the LogConfig, or the ClusterLogConfig it is converted from
+func (p *PodMatcher) Matches(ctx context.Context, lgc *logconfigv1beta1.LogConfig, pod *corev1.Pod) bool {
+	if !CheckInject(pod.ObjectMeta, p.Config.IgnoreNamespaces) {
+		return false
+	}
+	if p.namespaces == nil {
+		p.namespaces = make(map[string]*corev1.Namespace)
+	}
+
+	kind := KindLogConfig
+	if lgc.Namespace == "" {
+		kind = KindClusterLogConfig
+	}
+	m := &podMatcher{reader: p.Reader, clusterName: p.Config.ClusterName, pod: pod, namespace: p.namespaces[pod.Namespace]}
+	matched := m.match(ctx, kind, lgc, lgc.Spec.Selector, lgc).Matched
+	if m.namespace != nil {
+		p.namespaces[pod.Namespace] = m.namespace
+	}
+	return matched
+}
+
+// before tells if c takes precedence over o when both match a pod
+func (c ConfigMatch) before(o ConfigMatch) bool {
+	if c.Priority != o.Priority {
+		return c.Priority > o.Priority
+	}
+	if c.Kind != o.Kind {
+		return c.Kind == KindLogConfig
+	}
+	if c.Namespace != o.Namespace {
+		return c.Namespace < o.Namespace
+	}
+	return c.Name < o.Name
+}
+
+// Priority returns the priority of the LogConfig/ClusterLogConfig, which is 0 by default
+func Priority(obj metav1.Object) (int, error) {
+	p, ok := obj.GetAnnotations()[PriorityAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "invalid annotation %s", PriorityAnnotationKey)
+	}
+	return priority, nil
+}
+
+// podMatcher evaluates the selectors of LogConfigs/ClusterLogConfigs against a pod,
+// the namespace of the pod is read only once and only if any namespace selector is used.
+type podMatcher struct {
+	reader      client.Reader
+	clusterName string
+	pod         *corev1.Pod
+
+	namespace *corev1.Namespace
+}
+
+// match checks if the LogConfig/ClusterLogConfig is injected to the pod, and tells why.
+// All of the selectors must match the pod.
+func (m *podMatcher) match(ctx context.Context, kind string, obj metav1.Object, selector *logconfigv1beta1.Selector, lgc *logconfigv1beta1.LogConfig) configMatch {
+	c := configMatch{
+		ConfigMatch: ConfigMatch{
+			Kind:      kind,
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+		},
+		lgc: lgc,
+	}
+
+	priority, err := Priority(obj)
+	if err != nil {
+		c.Reasons = []string{err.Error()}
+		return c
+	}
+	c.Priority = priority
+
+	if reason, ok := volumeConfigReason(obj); ok {
+		c.Reasons = []string{reason}
+		return c
+	}
+	if expiresAt, ok, err := ExpiresAt(obj); err == nil && ok && !time.Now().Before(expiresAt) {
+		c.Reasons = []string{fmt.Sprintf("expired at %s", expiresAt.Format(time.RFC3339))}
+		return c
+	}
+	if kind == KindLogConfig && obj.GetNamespace() != m.pod.Namespace {
+		c.Reasons = []string{fmt.Sprintf("LogConfig only selects pods in namespace %s", obj.GetNamespace())}
+		return c
+	}
+
+	matched, reasons := podMatchedSelector(selector, m.pod, m.clusterName, obj.GetAnnotations()[SelectAllPodsAnnotationKey] == "true")
+	if selector == nil || !sidecarSelectorTypes[selector.Type] {
+		c.Reasons = reasons
+		return c
+	}
+	for _, check := range []func(metav1.Object) (bool, []string, error){
+		m.matchExpressions,
+		func(obj metav1.Object) (bool, []string, error) { return m.matchNamespace(ctx, obj) },
+		func(obj metav1.Object) (bool, []string, error) { return m.matchOwner(ctx, obj) },
+	} {
+		ok, r, err := check(obj)
+		if err != nil {
+			ok, r = false, []string{err.Error()}
+		}
+		matched = matched && ok
+		reasons = append(reasons, r...)
+	}
+
+	c.Matched = matched
+	c.Reasons = reasons
+	return c
+}
+
+func (m *podMatcher) matchExpressions(obj metav1.Object) (bool, []string, error) {
+	raw, ok := obj.GetAnnotations()[MatchExpressionsAnnotationKey]
+	if !ok {
+		return true, nil, nil
+	}
+	var requirements []metav1.LabelSelectorRequirement
+	if err := yaml.Unmarshal([]byte(raw), &requirements); err != nil {
+		return false, nil, errors.WithMessagef(err, "invalid annotation %s", MatchExpressionsAnnotationKey)
+	}
+
+	matched := true
+	var reasons []string
+	for _, r := range requirements {
+		selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{r},
+		})
+		if err != nil {
+			return false, nil, errors.WithMessagef(err, "invalid annotation %s", MatchExpressionsAnnotationKey)
+		}
+		if selector.Matches(labels.Set(m.pod.Labels)) {
+			reasons = append(reasons, fmt.Sprintf("%s: matched", selector))
+			continue
+		}
+		matched = false
+		reasons = append(reasons, fmt.Sprintf("%s: not matched", selector))
+	}
+	return matched, reasons, nil
+}
+
+func (m *podMatcher) matchNamespace(ctx context.Context, obj metav1.Object) (bool, []string, error) {
+	raw, ok := obj.GetAnnotations()[NamespaceSelectorAnnotationKey]
+	if !ok {
+		return true, nil, nil
+	}
+	ls := &metav1.LabelSelector{}
+	if err := yaml.Unmarshal([]byte(raw), ls); err != nil {
+		return false, nil, errors.WithMessagef(err, "invalid annotation %s", NamespaceSelectorAnnotationKey)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ls)
+	if err != nil {
+		return false, nil, errors.WithMessagef(err, "invalid annotation %s", NamespaceSelectorAnnotationKey)
+	}
+
+	if m.namespace == nil {
+		ns := &corev1.Namespace{}
+		if err := m.reader.Get(ctx, types.NamespacedName{Name: m.pod.Namespace}, ns); err != nil {
+			return false, nil, errors.WithMessagef(err, "get namespace %s", m.pod.Namespace)
+		}
+		m.namespace = ns
+	}
+
+	if selector.Matches(labels.Set(m.namespace.Labels)) {
+		return true, []string{fmt.Sprintf("namespace %s: matched namespace selector %s", m.pod.Namespace, selector)}, nil
+	}
+	return false, []string{fmt.Sprintf("namespace %s: not matched namespace selector %s", m.pod.Namespace, selector)}, nil
+}
+
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+
+func (m *podMatcher) matchOwner(ctx context.Context, obj metav1.Object) (bool, []string, error) {
+	raw, ok := obj.GetAnnotations()[OwnerSelectorAnnotationKey]
+	if !ok {
+		return true, nil, nil
+	}
+
+	var selectors [][]string
+	for _, owner := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(owner), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return false, nil, errors.Errorf("invalid annotation %s: %s is not in the form of {kind}/{name}", OwnerSelectorAnnotationKey, owner)
+		}
+		selectors = append(selectors, parts)
+	}
+
+	owners := m.owners(ctx, selectors)
+	for _, w := range owners {
+		for _, s := range selectors {
+			if !strings.EqualFold(s[0], w.Kind) {
+				continue
+			}
+			ok, err := path.Match(s[1], w.Name)
+			if err != nil {
+				return false, nil, errors.WithMessagef(err, "invalid annotation %s", OwnerSelectorAnnotationKey)
+			}
+			if ok {
+				return true, []string{fmt.Sprintf("owner %s/%s: matched %s/%s", w.Kind, w.Name, s[0], s[1])}, nil
+			}
+		}
+	}
+	return false, []string{fmt.Sprintf("owner %s/%s: not matched owner selector %s", owners[0].Kind, owners[0].Name, raw)}, nil
+}
+
+// owners returns the workload of the pod, followed by the CronJob owning its Job. The Job is read only if any
+// selector is of CronJob.
+func (m *podMatcher) owners(ctx context.Context, selectors [][]string) []kubernetes.Workload {
+	w := kubernetes.WorkloadOf(m.pod)
+	owners := []kubernetes.Workload{w}
+	if w.Kind != kubernetes.KindJob {
+		return owners
+	}
+	for _, s := range selectors {
+		if !strings.EqualFold(s[0], kubernetes.KindCronJob) {
+			continue
+		}
+		job := &batchv1.Job{}
+		if err := m.reader.Get(ctx, types.NamespacedName{Namespace: w.Namespace, Name: w.Name}, job); err != nil {
+			return owners
+		}
+		if owner := metav1.GetControllerOf(job); owner != nil && owner.Kind == kubernetes.KindCronJob {
+			owners = append(owners, kubernetes.Workload{Kind: kubernetes.KindCronJob, Namespace: w.Namespace, Name: owner.Name})
+		}
+		return owners
+	}
+	return owners
+}
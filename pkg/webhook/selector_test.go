@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/loggie-io/operator/pkg/utils/kubernetes"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func TestMatchConfigs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, logconfigv1beta1.AddToScheme(scheme))
+
+	selector := &logconfigv1beta1.Selector{
+		Type:        logconfigv1beta1.SelectorTypePod,
+		PodSelector: logconfigv1beta1.PodSelector{LabelSelector: map[string]string{"app": "nginx"}},
+	}
+	lgc := func(namespace string, name string, annotations map[string]string) *logconfigv1beta1.LogConfig {
+		return &logconfigv1beta1.LogConfig{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations},
+			Spec:       logconfigv1beta1.Spec{Selector: selector},
+		}
+	}
+	clgc := func(name string, annotations map[string]string) *logconfigv1beta1.ClusterLogConfig {
+		return &logconfigv1beta1.ClusterLogConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec:       logconfigv1beta1.Spec{Selector: selector},
+		}
+	}
+
+	withSelector := func(c *logconfigv1beta1.ClusterLogConfig, selector *logconfigv1beta1.Selector) *logconfigv1beta1.ClusterLogConfig {
+		c.Spec.Selector = selector
+		return c
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a",
+		Name:      "nginx-6799fc88d8-x2x9z",
+		Labels:    map[string]string{"app": "nginx", "tier": "web", "pod-template-hash": "6799fc88d8"},
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "ReplicaSet", Name: "nginx-6799fc88d8", Controller: func() *bool { b := true; return &b }()},
+		},
+	}}
+	pod.Spec.NodeSelector = map[string]string{"zone": "a"}
+	pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+			{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}}}},
+			{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd", "nvme"}}}},
+		}},
+	}}
+
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		want    []string
+		matched []bool
+	}{
+		{
+			name:    "LogConfig before ClusterLogConfig",
+			objs:    []client.Object{clgc("all", nil), lgc("team-a", "b", nil), lgc("team-a", "a", nil), lgc("team-b", "a", nil)},
+			want:    []string{"LogConfig/team-a/a", "LogConfig/team-a/b", "LogConfig/team-b/a", "ClusterLogConfig//all"},
+			matched: []bool{true, true, false, true},
+		},
+		{
+			name:    "higher priority first",
+			objs:    []client.Object{lgc("team-a", "a", nil), clgc("all", map[string]string{PriorityAnnotationKey: "10"})},
+			want:    []string{"ClusterLogConfig//all", "LogConfig/team-a/a"},
+			matched: []bool{true, true},
+		},
+		{
+			name: "match expressions",
+			objs: []client.Object{
+				lgc("team-a", "in", map[string]string{MatchExpressionsAnnotationKey: `[{"key": "tier", "operator": "In", "values": ["web", "api"]}]`}),
+				lgc("team-a", "notin", map[string]string{MatchExpressionsAnnotationKey: `[{"key": "tier", "operator": "NotIn", "values": ["web"]}]`}),
+				lgc("team-a", "exists", map[string]string{MatchExpressionsAnnotationKey: "- key: tier\n  operator: Exists"}),
+				lgc("team-a", "notexist", map[string]string{MatchExpressionsAnnotationKey: `[{"key": "tier", "operator": "DoesNotExist"}]`}),
+				lgc("team-a", "xinvalid", map[string]string{MatchExpressionsAnnotationKey: `[{"key": "tier", "operator": "In"}]`}),
+			},
+			want:    []string{"LogConfig/team-a/exists", "LogConfig/team-a/in", "LogConfig/team-a/notexist", "LogConfig/team-a/notin", "LogConfig/team-a/xinvalid"},
+			matched: []bool{true, true, false, false, false},
+		},
+		{
+			name: "namespace selector",
+			objs: []client.Object{
+				clgc("a", map[string]string{NamespaceSelectorAnnotationKey: `{"matchLabels": {"team": "a"}}`}),
+				clgc("b", map[string]string{NamespaceSelectorAnnotationKey: `{"matchExpressions": [{"key": "team", "operator": "NotIn", "values": ["a"]}]}`}),
+			},
+			want:    []string{"ClusterLogConfig//a", "ClusterLogConfig//b"},
+			matched: []bool{true, false},
+		},
+		{
+			name: "owner selector",
+			objs: []client.Object{
+				clgc("a", map[string]string{OwnerSelectorAnnotationKey: "StatefulSet/nginx, Deployment/ngin*"}),
+				clgc("b", map[string]string{OwnerSelectorAnnotationKey: "Deployment/tomcat"}),
+				clgc("c", map[string]string{OwnerSelectorAnnotationKey: "nginx"}),
+			},
+			want:    []string{"ClusterLogConfig//a", "ClusterLogConfig//b", "ClusterLogConfig//c"},
+			matched: []bool{true, false, false},
+		},
+		{
+			name: "cluster",
+			objs: []client.Object{
+				// type cluster selects all the pods only with the annotation
+				withSelector(clgc("a", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeCluster, Cluster: "prod"}),
+				withSelector(clgc("b", map[string]string{SelectAllPodsAnnotationKey: "true"}), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeCluster, Cluster: "test"}),
+				withSelector(clgc("c", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypePod, Cluster: "test"}),
+				withSelector(clgc("d", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypePod, Cluster: "prod"}),
+				withSelector(clgc("e", map[string]string{SelectAllPodsAnnotationKey: "true"}), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeCluster, Cluster: "prod"}),
+				withSelector(clgc("f", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeCluster, Cluster: "prod",
+					PodSelector: logconfigv1beta1.PodSelector{LabelSelector: map[string]string{"app": "nginx"}}}),
+			},
+			want:    []string{"ClusterLogConfig//a", "ClusterLogConfig//b", "ClusterLogConfig//c", "ClusterLogConfig//d", "ClusterLogConfig//e", "ClusterLogConfig//f"},
+			matched: []bool{false, false, false, true, true, true},
+		},
+		{
+			name: "node",
+			objs: []client.Object{
+				withSelector(clgc("a", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeNode,
+					NodeSelector: logconfigv1beta1.NodeSelector{NodeSelector: map[string]string{"zone": "a"}}}),
+				withSelector(clgc("b", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeNode,
+					NodeSelector: logconfigv1beta1.NodeSelector{NodeSelector: map[string]string{"disk": "ssd"}}}),
+				withSelector(clgc("c", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeNode,
+					NodeSelector: logconfigv1beta1.NodeSelector{NodeSelector: map[string]string{"disk": "*"}}}),
+				withSelector(clgc("d", nil), &logconfigv1beta1.Selector{Type: logconfigv1beta1.SelectorTypeNode,
+					NodeSelector: logconfigv1beta1.NodeSelector{NodeSelector: map[string]string{"gpu": "*"}}}),
+			},
+			want:    []string{"ClusterLogConfig//a", "ClusterLogConfig//b", "ClusterLogConfig//c", "ClusterLogConfig//d"},
+			matched: []bool{true, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := kubernetes.NewObjectReader(scheme, append(tt.objs, ns)...)
+			assert.NoError(t, err)
+			s := &SidecarInjection{Reader: reader, Config: &config.Sidecar{ClusterName: "prod"}}
+
+			matches, err := s.matchConfigs(context.Background(), pod)
+			assert.NoError(t, err)
+
+			var got []string
+			var matched []bool
+			for _, m := range matches {
+				got = append(got, m.Kind+"/"+m.Namespace+"/"+m.Name)
+				matched = append(matched, m.Matched)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.matched, matched)
+		})
+	}
+}
+
+func Test_podMatcher_matchOwner(t *testing.T) {
+	controller := true
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		Name:            "backup-28000000",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "backup", Controller: &controller}},
+	}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		Name:            "backup-28000000-abcde",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "backup-28000000", Controller: &controller}},
+	}}
+	reader, err := kubernetes.NewObjectReader(clientgoscheme.Scheme, job)
+	assert.NoError(t, err)
+	m := &podMatcher{reader: reader, pod: pod}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "CronJob/backup", want: true},
+		{selector: "Job/backup-*", want: true},
+		{selector: "CronJob/restore, Deployment/backup", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			obj := &metav1.ObjectMeta{Annotations: map[string]string{OwnerSelectorAnnotationKey: tt.selector}}
+			got, _, err := m.matchOwner(context.Background(), obj)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// StdoutContainersAnnotationKey chooses the containers to capture stdout, separated by comma.
+	// All the containers except the ignored ones are captured by default.
+	StdoutContainersAnnotationKey = "sidecar.loggie.io/stdout-containers"
+	// EntrypointAnnotationPrefix is followed by the container name, the value is the JSON of config.Entrypoint
+	EntrypointAnnotationPrefix = "entrypoint.sidecar.loggie.io/"
+
+	TeeInitContainerName = "loggie-tee"
+	TeeVolumeName        = "loggie-tee"
+	TeePath              = "/loggie-tee"
+	// TeeImagePath is where loggie-tee is in stdout.image
+	TeeImagePath = "/usr/local/bin/loggie-tee"
+
+	StdoutVolumeName = "loggie-stdout"
+	StdoutPath       = "/loggie-stdout"
+	StdoutFileName   = "stdout.log"
+)
+
+// teeCommand is loggie-tee installed in the tee volume, which wraps the command of the app containers
+var teeCommand = filepath.Join(TeePath, filepath.Base(TeeImagePath))
+
+// rewriteStdoutSources replaces path stdout in the sources with the files of all the captured containers,
+// and returns false if there is no stdout path
+func rewriteStdoutSources(sources string) (string, bool, error) {
+	var src []yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(sources), &src); err != nil {
+		return "", false, err
+	}
+
+	var found bool
+	for _, s := range src {
+		for i, item := range s {
+			if item.Key != "paths" {
+				continue
+			}
+			paths, ok := item.Value.([]interface{})
+			if !ok {
+				continue
+			}
+			for j, p := range paths {
+				if p == logconfigv1beta1.PathStdout {
+					paths[j] = filepath.Join(StdoutPath, "*", StdoutFileName)
+					found = true
+				}
+			}
+			s[i].Value = paths
+		}
+	}
+	if !found {
+		return sources, false, nil
+	}
+
+	out, err := yaml.Marshal(src)
+	if err != nil {
+		return "", false, err
+	}
+	return string(out), true, nil
+}
+
+// captureStdout wraps the app containers with loggie-tee, which writes their stdout and stderr to the stdout volume as well.
+// It returns the volumeMount of sidecar to read the files.
+func captureStdout(pod *corev1.Pod, conf *config.Stdout, ignoreContainerNames []string, status *InjectedStatus) (corev1.VolumeMount, error) {
+	containers := chosenContainers(pod, ignoreContainerNames)
+	if len(containers) == 0 {
+		return corev1.VolumeMount{}, errors.New("no container to capture stdout")
+	}
+
+	teeVolName := uniqueVolumeName(pod, TeeVolumeName)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         teeVolName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	status.Volumes = append(status.Volumes, teeVolName)
+
+	stdoutVolName := uniqueVolumeName(pod, StdoutVolumeName)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         stdoutVolName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	status.Volumes = append(status.Volumes, stdoutVolName)
+
+	initContainer := corev1.Container{
+		Name:    uniqueContainerName(pod, TeeInitContainerName),
+		Image:   conf.Image,
+		Command: []string{TeeImagePath, "-install", TeePath},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: teeVolName, MountPath: TeePath},
+		},
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
+	status.InitContainers = append(status.InitContainers, initContainer.Name)
+
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if !contains(containers, c.Name) {
+			continue
+		}
+
+		command, err := resolveCommand(pod, c, conf)
+		if err != nil {
+			return corev1.VolumeMount{}, err
+		}
+		status.addCommand(c)
+
+		c.Command = append([]string{
+			teeCommand,
+			"-file", filepath.Join(StdoutPath, c.Name, StdoutFileName),
+			"-max-size", strconv.Itoa(conf.MaxFileSize),
+			"--",
+		}, command...)
+		c.Args = nil
+
+		c.VolumeMounts = append(c.VolumeMounts,
+			corev1.VolumeMount{Name: teeVolName, MountPath: TeePath, ReadOnly: true},
+			corev1.VolumeMount{Name: stdoutVolName, MountPath: StdoutPath},
+		)
+		status.addVolumeMount(c.Name, teeVolName)
+		status.addVolumeMount(c.Name, stdoutVolName)
+	}
+
+	return corev1.VolumeMount{Name: stdoutVolName, MountPath: StdoutPath, ReadOnly: true}, nil
+}
+
+func chosenContainers(pod *corev1.Pod, ignoreContainerNames []string) []string {
+	var chosen []string
+	if list, ok := pod.Annotations[StdoutContainersAnnotationKey]; ok {
+		for _, name := range strings.Split(list, ",") {
+			chosen = append(chosen, strings.TrimSpace(name))
+		}
+		return chosen
+	}
+
+	for _, c := range pod.Spec.Containers {
+		if contains(ignoreContainerNames, c.Name) {
+			continue
+		}
+		chosen = append(chosen, c.Name)
+	}
+	return chosen
+}
+
+// resolveCommand returns the full command line the container runs. The ENTRYPOINT and CMD of the image are unknown
+// to the webhook, so they are resolved by the annotation or configuration if the container has no command.
+func resolveCommand(pod *corev1.Pod, c *corev1.Container, conf *config.Stdout) ([]string, error) {
+	if len(c.Command) > 0 {
+		return append(append([]string{}, c.Command...), c.Args...), nil
+	}
+
+	var ep config.Entrypoint
+	if raw, ok := pod.Annotations[EntrypointAnnotationPrefix+c.Name]; ok {
+		if err := json.Unmarshal([]byte(raw), &ep); err != nil {
+			return nil, errors.WithMessagef(err, "invalid annotation %s%s", EntrypointAnnotationPrefix, c.Name)
+		}
+	} else if e, ok := conf.Entrypoints[c.Image]; ok {
+		ep = e
+	} else {
+		return nil, errors.Errorf("entrypoint of container %s is unknown, set the command of container or annotation %s%s",
+			c.Name, EntrypointAnnotationPrefix, c.Name)
+	}
+
+	command := append([]string{}, ep.Entrypoint...)
+	if len(c.Args) > 0 {
+		command = append(command, c.Args...)
+	} else {
+		command = append(command, ep.Cmd...)
+	}
+	if len(command) == 0 {
+		return nil, errors.Errorf("entrypoint of container %s is empty", c.Name)
+	}
+	return command, nil
+}
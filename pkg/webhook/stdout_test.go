@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_rewriteStdoutSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources string
+		want    string
+		wantOk  bool
+	}{
+		{
+			name: "stdout",
+			sources: `
+- type: file
+  name: stdout
+  paths:
+  - stdout
+  - /var/log/*.log
+`,
+			want: `- type: file
+  name: stdout
+  paths:
+  - /loggie-stdout/*/stdout.log
+  - /var/log/*.log
+`,
+			wantOk: true,
+		},
+		{
+			name: "no stdout",
+			sources: `
+- type: file
+  paths:
+  - /var/log/*.log
+`,
+			want: `
+- type: file
+  paths:
+  - /var/log/*.log
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := rewriteStdoutSources(tt.sources)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
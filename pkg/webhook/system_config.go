@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/loggie-io/loggie/pkg/util/yaml"
+	"github.com/pkg/errors"
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+// DefaultHTTPPort is the port of the Loggie HTTP server if loggie.http.port is not set
+const DefaultHTTPPort = 9196
+
+// LoggieHTTP is loggie.http of the system config, the HTTP server of the API and metrics of Loggie
+type LoggieHTTP struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Host    string `yaml:"host,omitempty"`
+	Port    int    `yaml:"port,omitempty"`
+}
+
+type systemConfig struct {
+	Loggie struct {
+		HTTP LoggieHTTP `yaml:"http,omitempty"`
+	} `yaml:"loggie,omitempty"`
+}
+
+// ParseHTTP returns loggie.http of the system config, with the default port of Loggie
+func ParseHTTP(raw string) (*LoggieHTTP, error) {
+	sc := &systemConfig{}
+	if err := yaml.Unmarshal([]byte(raw), sc); err != nil {
+		return nil, err
+	}
+	if sc.Loggie.HTTP.Port == 0 {
+		sc.Loggie.HTTP.Port = DefaultHTTPPort
+	}
+	return &sc.Loggie.HTTP, nil
+}
+
+// reachable checks if the HTTP server listens on the pod IP, which is where the kubelet and Prometheus connect
+func (h *LoggieHTTP) reachable() bool {
+	switch h.Host {
+	case "localhost", "127.0.0.1", "::1":
+		return false
+	}
+	return h.Enabled
+}
+
+// SetHTTPPort rewrites loggie.http.port of the system config, keeping the order of the other fields
+func SetHTTPPort(raw string, port int) (string, error) {
+	root := yamlv2.MapSlice{}
+	if err := yaml.Unmarshal([]byte(raw), &root); err != nil {
+		return "", err
+	}
+
+	loggie, ok := mapValue(root, "loggie")
+	if !ok {
+		return "", errors.New("loggie is not found")
+	}
+	http, ok := mapValue(loggie, "http")
+	if !ok {
+		return "", errors.New("loggie.http is not found")
+	}
+	http = setMapValue(http, "port", port)
+	loggie = setMapValue(loggie, "http", http)
+	root = setMapValue(root, "loggie", loggie)
+
+	out, err := yaml.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func mapValue(m yamlv2.MapSlice, key string) (yamlv2.MapSlice, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			v, ok := item.Value.(yamlv2.MapSlice)
+			return v, ok
+		}
+	}
+	return nil, false
+}
+
+func setMapValue(m yamlv2.MapSlice, key string, value interface{}) yamlv2.MapSlice {
+	for i := range m {
+		if m[i].Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yamlv2.MapItem{Key: key, Value: value})
+}
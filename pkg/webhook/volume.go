@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/loggie-io/loggie/pkg/core/cfg"
+	"github.com/loggie-io/loggie/pkg/core/interceptor"
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/loggie/pkg/util/yaml"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+const (
+	// ModeAnnotationKey of pod or LogConfig/ClusterLogConfig overrides sidecar.mode in the configuration, sidecar or volume.
+	// The annotation of pod takes precedence.
+	ModeAnnotationKey = "sidecar.loggie.io/mode"
+
+	// VolumeConfigLabelKey is added to the pods injected in volume mode, which is selected by the LogConfig/ClusterLogConfig
+	// collected by the Loggie DaemonSet, see VolumeConfigLabel
+	VolumeConfigLabelKey = "sidecar.loggie.io/volume-config"
+	// VolumeOfAnnotationKey marks the LogConfig/ClusterLogConfig collected by the Loggie DaemonSet for the pods injected
+	// in volume mode, whose value is the reference of the one injected, see ConfigRef
+	VolumeOfAnnotationKey = "sidecar.loggie.io/volume-of"
+
+	// VolumeConfigSuffix is appended to the name of LogConfig/ClusterLogConfig collected by the Loggie DaemonSet
+	VolumeConfigSuffix = "-volume"
+)
+
+// InjectionMode returns the mode the LogConfig/ClusterLogConfig is injected to the pod with, pod could be nil
+// to get the mode of the LogConfig/ClusterLogConfig.
+func InjectionMode(conf *config.Sidecar, obj metav1.Object, pod *corev1.Pod) (string, error) {
+	mode := conf.Mode
+	if mode == "" {
+		mode = config.ModeSidecar
+	}
+	if m, ok := obj.GetAnnotations()[ModeAnnotationKey]; ok {
+		mode = m
+	}
+	if pod != nil {
+		if m, ok := pod.Annotations[ModeAnnotationKey]; ok {
+			mode = m
+		}
+	}
+
+	if mode != config.ModeSidecar && mode != config.ModeVolume {
+		return "", errors.Errorf("invalid annotation %s: %s, should be %s or %s", ModeAnnotationKey, mode, config.ModeSidecar, config.ModeVolume)
+	}
+	return mode, nil
+}
+
+// VolumeConfigLabel returns the value of label sidecar.loggie.io/volume-config for the LogConfig/ClusterLogConfig,
+// which is its reference with "/" replaced by "_", or the hash of the reference if it is not a valid label value.
+func VolumeConfigLabel(lgc *logconfigv1beta1.LogConfig) string {
+	ref := ConfigRef(lgc)
+	value := strings.ReplaceAll(ref, "/", "_")
+	if len(validation.IsValidLabelValue(value)) == 0 {
+		return value
+	}
+	sum := sha256.Sum256([]byte(ref))
+	return "hash_" + hex.EncodeToString(sum[:])[:16]
+}
+
+// VolumeConfigName returns the name of LogConfig/ClusterLogConfig collected by the Loggie DaemonSet for the one injected
+func VolumeConfigName(name string) string {
+	return name + VolumeConfigSuffix
+}
+
+// injectVolumes puts the log paths of the app containers on emptyDir volumes, and labels the pod for the Loggie DaemonSet
+// to collect. No container is added, and the volumes, labels and annotations are recorded in the injected status.
+func injectVolumes(pod *corev1.Pod, lgc *logconfigv1beta1.LogConfig, paths []string, ignoreContainerNames []string,
+	annotations map[string]string) error {
+	if _, err := Uninject(pod); err != nil {
+		return err
+	}
+
+	status := &InjectedStatus{}
+	logVolumes(pod, paths, ignoreContainerNames, status)
+
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	pod.Labels[VolumeConfigLabelKey] = VolumeConfigLabel(lgc)
+	status.Labels = append(status.Labels, VolumeConfigLabelKey)
+
+	for k, v := range annotations {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[k] = v
+		status.Annotations = append(status.Annotations, k)
+	}
+	sort.Strings(status.Annotations)
+
+	return setInjectedStatus(pod, status)
+}
+
+// VolumeConfigSpec returns the spec of LogConfig/ClusterLogConfig collected by the Loggie DaemonSet for the pods injected
+// with lgc in volume mode. It selects the pods by label sidecar.loggie.io/volume-config, and has the same pipeline
+// as the sidecar, except the stdout paths are left to the DaemonSet.
+func VolumeConfigSpec(conf *config.Sidecar, lgc *logconfigv1beta1.LogConfig, reader client.Reader) (*logconfigv1beta1.Spec, error) {
+	if lgc.Spec.Pipeline == nil {
+		return nil, errors.New("spec.pipeline is required")
+	}
+	lgc = lgc.DeepCopy()
+	// the spec of a ClusterLogConfig is shared by the namespaces, so only the default sink of the configuration applies
+	if err := resolveSink(conf, lgc, lgc.Namespace, reader); err != nil {
+		return nil, err
+	}
+
+	mandatory, err := conf.MandatoryInterceptors()
+	if err != nil {
+		return nil, err
+	}
+	if len(mandatory) > 0 {
+		interceptors := make([]*interceptor.Config, 0)
+		if lgc.Spec.Pipeline.Interceptors != "" {
+			if err := cfg.UnPackFromRaw([]byte(lgc.Spec.Pipeline.Interceptors), &interceptors).Do(); err != nil {
+				return nil, errors.WithMessage(err, "invalid interceptors")
+			}
+		}
+		merged, err := mergeMandatoryInterceptors(mandatory, interceptors)
+		if err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal(merged)
+		if err != nil {
+			return nil, err
+		}
+		lgc.Spec.Pipeline.Interceptors = string(out)
+	}
+
+	spec := &logconfigv1beta1.Spec{
+		Selector: &logconfigv1beta1.Selector{
+			Type: logconfigv1beta1.SelectorTypePod,
+			PodSelector: logconfigv1beta1.PodSelector{
+				LabelSelector: map[string]string{VolumeConfigLabelKey: VolumeConfigLabel(lgc)},
+			},
+		},
+		Pipeline: lgc.Spec.Pipeline,
+	}
+	if lgc.Spec.Selector != nil {
+		spec.Selector.Cluster = lgc.Spec.Selector.Cluster
+	}
+	return spec, nil
+}
+
+// volumeConfigReason tells why the LogConfig/ClusterLogConfig collected by the Loggie DaemonSet is not injected
+func volumeConfigReason(obj metav1.Object) (string, bool) {
+	ref, ok := obj.GetAnnotations()[VolumeOfAnnotationKey]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("collected by the Loggie DaemonSet for the pods injected with %s in volume mode", ref), true
+}
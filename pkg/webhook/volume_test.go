@@ -0,0 +1,117 @@
+/*
+Copyright 2023 Loggie.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	logconfigv1beta1 "github.com/loggie-io/loggie/pkg/discovery/kubernetes/apis/loggie/v1beta1"
+	"github.com/loggie-io/operator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+func TestInjectionMode(t *testing.T) {
+	withMode := func(mode string) map[string]string {
+		return map[string]string{ModeAnnotationKey: mode}
+	}
+
+	tests := []struct {
+		name    string
+		conf    string
+		config  map[string]string
+		pod     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: config.ModeSidecar,
+		},
+		{
+			name: "configuration",
+			conf: config.ModeVolume,
+			want: config.ModeVolume,
+		},
+		{
+			name:   "config annotation",
+			conf:   config.ModeVolume,
+			config: withMode(config.ModeSidecar),
+			want:   config.ModeSidecar,
+		},
+		{
+			name:   "pod annotation",
+			config: withMode(config.ModeSidecar),
+			pod:    withMode(config.ModeVolume),
+			want:   config.ModeVolume,
+		},
+		{
+			name:    "invalid",
+			pod:     withMode("daemonset"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lgc := &logconfigv1beta1.LogConfig{}
+			lgc.Annotations = tt.config
+			pod := testPod()
+			pod.Annotations = tt.pod
+
+			got, err := InjectionMode(&config.Sidecar{Mode: tt.conf}, lgc, pod)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_injectVolumes(t *testing.T) {
+	lgc := &logconfigv1beta1.LogConfig{}
+	lgc.Namespace = "default"
+	lgc.Name = "tomcat"
+	annotations := map[string]string{ConfigAnnotationKey: ConfigRef(lgc)}
+
+	origin := testPod()
+	pod := origin.DeepCopy()
+	assert.NoError(t, injectVolumes(pod, lgc, []string{"/usr/local/tomcat/logs/*.log"}, nil, annotations))
+
+	assert.Len(t, pod.Spec.Containers, 1)
+	assert.Equal(t, []corev1.VolumeMount{{Name: "loggie-logs-0", MountPath: "/usr/local/tomcat/logs"}}, pod.Spec.Containers[0].VolumeMounts)
+	assert.NotNil(t, pod.Spec.Volumes[0].EmptyDir)
+	assert.Equal(t, "LogConfig_default_tomcat", pod.Labels[VolumeConfigLabelKey])
+
+	// uninject restores the original pod
+	injected, err := Uninject(pod)
+	assert.NoError(t, err)
+	assert.True(t, injected)
+	assert.Equal(t, origin, pod)
+}
+
+func TestVolumeConfigLabel(t *testing.T) {
+	lgc := &logconfigv1beta1.LogConfig{}
+	lgc.Name = "tomcat"
+	assert.Equal(t, "ClusterLogConfig_tomcat", VolumeConfigLabel(lgc))
+
+	lgc.Name = strings.Repeat("tomcat", 20)
+	label := VolumeConfigLabel(lgc)
+	assert.True(t, strings.HasPrefix(label, "hash_"))
+	assert.Len(t, label, 21)
+}